@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/miner"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// auditAllSessions makes the audit command cover every session
+const auditAllSessions = "all"
+
+var (
+	auditSession string
+	auditSince   string
+	auditUntil   string
+	auditGroupBy string
+	auditOutput  string
+	auditExport  string
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Check the shares the pool credited against what the steps earned",
+	Long: `Check the shares the pool credited against what the steps earned
+
+While mining, every STEPOK and STEPFAIL is matched with the step it
+answers. The shares a step should earn follow from --share-rule: the
+same --step-shares for every step (flat), or one for a step of the
+shortest target the pool accepts and 16 times as many for every
+character more (length). 'noso-go mine' saves the expected and
+credited shares of every block to --stats-file, and this command
+reports them per block (or pool, day, ...), with the steps credited
+differently and the share of steps the pool rejected.
+
+By default the most recent mining session is audited. --session takes
+the id of another session (see --group-by session) or 'all'. --since and
+--until work as in 'noso-go stats'.
+
+Example usage:
+
+./noso-go audit
+./noso-go audit --session all --group-by pool
+./noso-go audit --since 7d --group-by day --output csv --export audit.csv
+`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return bindMineFlags(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		now := time.Now()
+		since, err := parseTimeArg(auditSince, now)
+		if err != nil {
+			cmd.PrintErrf("Error: --since: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		until, err := parseTimeArg(auditUntil, now)
+		if err != nil {
+			cmd.PrintErrf("Error: --until: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+
+		path := miner.DataPath(viper.GetString("data-dir"), viper.GetString("stats-file"))
+		if _, err := os.Stat(path); err != nil {
+			cmd.PrintErrf("Error: no stats at %s: %v\n", path, err)
+			os.Exit(ExitConfig)
+		}
+		db, err := miner.OpenStatsDB(path)
+		if err != nil {
+			cmd.PrintErrf("Error: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		records, err := db.Records(since, until)
+		if err != nil {
+			cmd.PrintErrf("Error: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		records, session := auditedRecords(records, auditSession)
+
+		totals, err := miner.SumBlockRecords(records, auditGroupBy)
+		if err != nil {
+			cmd.PrintErrf("Error: --group-by: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		total, _ := miner.SumBlockRecords(records, miner.GroupTotal)
+
+		out := io.Writer(os.Stdout)
+		var f *os.File
+		if auditExport != "" {
+			f, err = os.Create(auditExport)
+			if err != nil {
+				cmd.PrintErrf("Error: %v\n", err)
+				os.Exit(ExitConfig)
+			}
+			out = f
+		}
+
+		switch auditOutput {
+		case OutputTable:
+			err = writeAuditTable(out, totals, total, auditGroupBy, session)
+		case OutputCSV:
+			err = writeAuditCSV(out, totals, auditGroupBy)
+		case OutputJSON:
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(totals)
+		default:
+			err = fmt.Errorf("unknown --output %q, use %s, %s or %s", auditOutput, OutputTable, OutputCSV, OutputJSON)
+		}
+		// Closed before exiting, which skips deferred calls
+		if f != nil {
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			cmd.PrintErrf("Error: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		if auditExport != "" {
+			fmt.Printf("Exported %d row(s) to %s\n", len(totals), auditExport)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+
+	auditCmd.Flags().String("data-dir", defaultDataDir(), "Directory payments.csv and the stats file are kept in")
+	auditCmd.Flags().String("stats-file", miner.DefaultStatsFile, "File in --data-dir the per-block mining statistics are saved in")
+	auditCmd.Flags().StringVar(&auditSession, "session", "", "Mining session to audit, or 'all' (default the most recent one)")
+	auditCmd.Flags().StringVar(&auditSince, "since", "", "Only audit blocks that ended after this time")
+	auditCmd.Flags().StringVar(&auditUntil, "until", "", "Only audit blocks that ended before this time")
+	auditCmd.Flags().StringVar(&auditGroupBy, "group-by", miner.GroupBlock, fmt.Sprintf("Add up the audit by %s", strings.Join(miner.StatsGroups, ", ")))
+	auditCmd.Flags().StringVarP(&auditOutput, "output", "o", OutputTable, fmt.Sprintf("Output format: %s, %s or %s", OutputTable, OutputCSV, OutputJSON))
+	auditCmd.Flags().StringVar(&auditExport, "export", "", "Write the output to this file instead of the terminal")
+}
+
+// auditedRecords returns the records of session, or of the most recent
+// session when it's empty, and the session picked. Records saved before
+// the miner audited shares are left out
+func auditedRecords(records []miner.BlockRecord, session string) ([]miner.BlockRecord, string) {
+	if session == "" {
+		for _, rec := range records {
+			if rec.Session != "" {
+				session = rec.Session
+			}
+		}
+	}
+
+	audited := []miner.BlockRecord{}
+	for _, rec := range records {
+		if rec.Session != "" && (session == auditAllSessions || rec.Session == session) {
+			audited = append(audited, rec)
+		}
+	}
+	return audited, session
+}
+
+func auditRow(t miner.StatsTotals) []string {
+	failRate := ""
+	if answered := t.StepsAccepted + t.StepsFailed; answered > 0 {
+		failRate = fmt.Sprintf("%.1f%%", 100*float64(t.StepsFailed)/float64(answered))
+	}
+	return []string{
+		strconv.Itoa(t.Blocks),
+		strconv.Itoa(t.StepsSent),
+		strconv.Itoa(t.StepsAccepted),
+		strconv.Itoa(t.StepsFailed),
+		failRate,
+		strconv.Itoa(t.SharesEarned),
+		strconv.Itoa(t.SharesExpected),
+		fmt.Sprintf("%+d", t.SharesEarned-t.SharesExpected),
+		strconv.Itoa(t.ShareDiscrepancies),
+	}
+}
+
+var auditHeader = []string{"BLOCKS", "POP SENT", "ACCEPTED", "FAILED", "FAIL %", "SHARES", "EXPECTED", "DIFFERENCE", "DISCREPANCIES"}
+
+func writeAuditTable(out io.Writer, totals, total []miner.StatsTotals, group, session string) error {
+	if len(totals) == 0 {
+		_, err := fmt.Fprintln(out, "No audited blocks recorded for this session and time range")
+		return err
+	}
+
+	if session != auditAllSessions {
+		fmt.Fprintf(out, "Session %s\n\n", session)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	header := auditHeader
+	if group != miner.GroupTotal {
+		header = append([]string{strings.ToUpper(group)}, header...)
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, t := range totals {
+		row := auditRow(t)
+		if group != miner.GroupTotal {
+			row = append([]string{t.Key}, row...)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	t := total[0]
+	fmt.Fprintln(out)
+	switch {
+	case t.SharesEarned < t.SharesExpected:
+		fmt.Fprintf(out, "The pool credited %d share(s) fewer than expected (%d of %d)", t.SharesExpected-t.SharesEarned, t.SharesEarned, t.SharesExpected)
+	case t.SharesEarned > t.SharesExpected:
+		fmt.Fprintf(out, "The pool credited %d share(s) more than expected (%d of %d)", t.SharesEarned-t.SharesExpected, t.SharesEarned, t.SharesExpected)
+	default:
+		fmt.Fprintf(out, "The pool credited the %d share(s) expected", t.SharesExpected)
+	}
+	fmt.Fprintf(out, ", %d step(s) were credited differently", t.ShareDiscrepancies)
+	if answered := t.StepsAccepted + t.StepsFailed; answered > 0 {
+		fmt.Fprintf(out, " and %.1f%% of the answered steps failed", 100*float64(t.StepsFailed)/float64(answered))
+	}
+	_, err := fmt.Fprintln(out)
+	return err
+}
+
+func writeAuditCSV(out io.Writer, totals []miner.StatsTotals, group string) error {
+	w := csv.NewWriter(out)
+	header := []string{"group", "blocks", "pop_sent", "pop_accepted", "pop_failed", "shares_earned", "shares_expected", "share_discrepancies"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, t := range totals {
+		key := t.Key
+		if group == miner.GroupTotal {
+			key = miner.GroupTotal
+		}
+		w.Write([]string{
+			key,
+			strconv.Itoa(t.Blocks),
+			strconv.Itoa(t.StepsSent),
+			strconv.Itoa(t.StepsAccepted),
+			strconv.Itoa(t.StepsFailed),
+			strconv.Itoa(t.SharesEarned),
+			strconv.Itoa(t.SharesExpected),
+			strconv.Itoa(t.ShareDiscrepancies),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
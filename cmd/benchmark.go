@@ -22,31 +22,86 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
 
+	"github.com/Noso-Project/noso-go/internal/hasher"
+	"github.com/Noso-Project/noso-go/internal/miner"
 	"github.com/spf13/cobra"
 )
 
+var (
+	benchDuration   time.Duration
+	benchMaxThreads int
+	benchJson       bool
+	benchHasher     string
+)
+
 // benchmarkCmd represents the benchmark command
 var benchmarkCmd = &cobra.Command{
 	Use:   "benchmark",
 	Short: "Benchmark your processor for the best --cpu setting",
-	Long:  `Benchmark your process for the best --cpu setting`,
+	Long: `Benchmark your processor for the best --cpu setting
+
+Runs the mining hash loop against a synthetic job (no pool connection
+required) for every thread count from 1 to the number of CPUs, and
+reports the hash rate of each along with the recommended --cpu value.
+Example usage:
+
+./noso-go benchmark
+./noso-go benchmark --duration 30s
+./noso-go benchmark --json > $(hostname)-benchmark.json
+./noso-go benchmark --hasher go --max-threads 1
+`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Benchmarking coming soon")
+		if benchMaxThreads < 1 {
+			cmd.PrintErrln("Error: --max-threads cannot be less than 1")
+			os.Exit(1)
+		}
+		if benchDuration <= 0 {
+			cmd.PrintErrln("Error: --duration must be greater than 0")
+			os.Exit(1)
+		}
+
+		var progress func(miner.BenchmarkResult)
+		if !benchJson {
+			fmt.Printf("Benchmarking 1 to %d threads, %s per step\n", benchMaxThreads, benchDuration)
+			progress = func(r miner.BenchmarkResult) {
+				fmt.Printf("  %2d thread(s): %d hashes/s\n", r.Threads, r.HashRate)
+			}
+		}
+
+		report, err := miner.Benchmark(benchMaxThreads, benchDuration, benchHasher, progress)
+		if err != nil {
+			cmd.PrintErrf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if benchJson {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not encode benchmark results: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		report.PrettyPrint()
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(benchmarkCmd)
 
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// benchmarkCmd.PersistentFlags().String("foo", "", "A help for foo")
+	benchmarkCmd.Flags().DurationVarP(&benchDuration, "duration", "d", 10*time.Second, "How long to hash at each thread count")
+	benchmarkCmd.Flags().IntVar(&benchMaxThreads, "max-threads", runtime.NumCPU(), "Highest thread count to benchmark")
+	benchmarkCmd.Flags().StringVar(&benchHasher, "hasher", hasher.Auto, fmt.Sprintf("SHA-256 backend: %s or one of %s", hasher.Auto, strings.Join(hasher.Names(), ", ")))
+	benchmarkCmd.Flags().BoolVar(&benchJson, "json", false, "Print results as JSON")
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// benchmarkCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	benchmarkCmd.Flags().SortFlags = false
 }
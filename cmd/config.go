@@ -0,0 +1,301 @@
+/*
+Copyright © 2021 Levi Noecker <levi.noecker@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/miner"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const envPrefix = "NOSO"
+
+// mineKeys are the configuration keys that make up miner.Opts. Each key
+// matches the name of its command line flag, and can also be set with
+// a NOSO_ prefixed environment variable (e.g. NOSO_STATUS_INTERVAL) or
+// in the config file
+var mineKeys = []string{
+	"address",
+	"port",
+	"password",
+	"wallet",
+	"cpu",
+	"hasher",
+	"max-solution-errors",
+	"show-pop",
+	"status-interval",
+	"exit-on-retry",
+	"random-wallet",
+	"api-listen",
+	"metrics-listen",
+	"rate-windows",
+	"auto-payment",
+	"payment-min-balance",
+	"payment-interval",
+	"payment-windows",
+	"data-dir",
+	"stats-file",
+	"share-rule",
+	"step-shares",
+	"failover-after",
+	"failback-interval",
+	"proxy",
+	"tls",
+	"tls-ca",
+	"tls-skip-verify",
+}
+
+var configShowOpts = &miner.Opts{}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the noso-go configuration",
+	Long: `Inspect the noso-go configuration
+
+Every 'noso-go mine' flag can also be set with an environment variable
+or in the config file (default $HOME/.noso-go.yaml, see --config).
+Settings are applied in the following order, highest priority first:
+
+  1. Command line flag   (--status-interval 30)
+  2. Environment variable (NOSO_STATUS_INTERVAL=30)
+  3. Config file          (status-interval: 30)
+  4. Default value
+
+Example config file:
+
+address: noso.dukedog.io
+port: 8082
+password: duke
+wallet:
+  - Nm6jiGfRg7DVHHMfbMJL9CT1DtkUCF
+cpu: 4
+`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective mining configuration",
+	Long: `Print the effective mining configuration after merging command line
+flags, NOSO_* environment variables, the config file and defaults.
+The pool password is masked.
+Example usage:
+
+./noso-go config show
+NOSO_CPU=8 ./noso-go config show --status-interval 30
+`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return bindMineFlags(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if f := viper.ConfigFileUsed(); f != "" {
+			fmt.Printf("Config file: %s\n\n", f)
+		} else {
+			fmt.Printf("Config file: none found\n\n")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, key := range mineKeys {
+			value := fmt.Sprint(viper.Get(key))
+			switch key {
+			case "password":
+				value = maskPassword(viper.GetString(key))
+			case "proxy":
+				value = maskProxyPassword(viper.GetString(key))
+			case "wallet":
+				value = strings.Join(getWallets(), ",")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", key, value, configSource(cmd, key))
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+
+	addMineFlags(configShowCmd, configShowOpts)
+}
+
+// bindMineFlags binds the mine flags of cmd to their viper keys. This has
+// to happen just before the command runs, since several commands define
+// flags with the same name
+func bindMineFlags(cmd *cobra.Command) error {
+	for _, key := range mineKeys {
+		if f := cmd.Flags().Lookup(key); f != nil {
+			if err := viper.BindPFlag(key, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadMineOpts fills opts from the merged flag, environment, config file
+// and default values
+func loadMineOpts(opts *miner.Opts) {
+	opts.IpAddr = viper.GetString("address")
+	opts.IpPort = viper.GetInt("port")
+	opts.PoolPw = viper.GetString("password")
+	opts.Wallets = getWallets()
+	opts.Cpu = viper.GetInt("cpu")
+	opts.Hasher = viper.GetString("hasher")
+	opts.MaxSolutionErrors = viper.GetInt("max-solution-errors")
+	opts.ShowPop = viper.GetBool("show-pop")
+	opts.StatusInterval = viper.GetInt("status-interval")
+	if opts.StatusInterval <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: --status-interval must be a positive number of seconds\n")
+		os.Exit(ExitConfig)
+	}
+	opts.ExitOnRetry = viper.GetBool("exit-on-retry")
+	opts.ApiListen = viper.GetString("api-listen")
+	opts.MetricsListen = viper.GetString("metrics-listen")
+	windows, err := getRateWindows()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --rate-windows: %v\n", err)
+		os.Exit(ExitConfig)
+	}
+	opts.RateWindows = windows
+	policy, err := getPaymentPolicy()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitConfig)
+	}
+	opts.Payments = policy
+	opts.DataDir = viper.GetString("data-dir")
+	opts.StatsFile = viper.GetString("stats-file")
+	opts.ShareRule = miner.ShareRule{
+		Name:   viper.GetString("share-rule"),
+		Shares: viper.GetInt("step-shares"),
+	}
+	if err := opts.ShareRule.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --share-rule: %v\n", err)
+		os.Exit(ExitConfig)
+	}
+	opts.FailoverAfter = viper.GetInt("failover-after")
+	opts.FailbackInterval = viper.GetDuration("failback-interval")
+	opts.Dial = miner.DialOpts{
+		Proxy:         viper.GetString("proxy"),
+		TLS:           viper.GetBool("tls"),
+		TLSCA:         viper.GetString("tls-ca"),
+		TLSSkipVerify: viper.GetBool("tls-skip-verify"),
+	}
+}
+
+// getRateWindows returns the configured hash rate windows. Like wallets,
+// they may be separated with spaces or commas
+func getRateWindows() ([]time.Duration, error) {
+	windows := []time.Duration{}
+	for _, w := range viper.GetStringSlice("rate-windows") {
+		for _, s := range strings.Split(w, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, err
+			}
+			if d <= 0 {
+				return nil, fmt.Errorf("%s is not a positive duration", s)
+			}
+			windows = append(windows, d)
+		}
+	}
+	return windows, nil
+}
+
+// getWallets returns the configured wallets. Environment variables may
+// separate wallets with spaces or commas
+func getWallets() []string {
+	wallets := []string{}
+	for _, w := range viper.GetStringSlice("wallet") {
+		for _, s := range strings.Split(w, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				wallets = append(wallets, s)
+			}
+		}
+	}
+	return wallets
+}
+
+// requireKeys exits with an error if any of keys has no value
+func requireKeys(cmd *cobra.Command, keys ...string) {
+	missing := []string{}
+	for _, key := range keys {
+		if key == "wallet" && len(getWallets()) == 0 || key != "wallet" && viper.GetString(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(missing) > 0 {
+		cmd.PrintErrf("Error: required setting(s) \"%s\" not set\n", strings.Join(missing, `", "`))
+		cmd.PrintErrf("Set them with a flag, a %s_ environment variable or the config file\n", envPrefix)
+		cmd.PrintErrf("Run '%v --help' for usage.\n", cmd.CommandPath())
+		os.Exit(ExitConfig)
+	}
+}
+
+func configSource(cmd *cobra.Command, key string) string {
+	if f := cmd.Flags().Lookup(key); f != nil && f.Changed {
+		return "flag"
+	}
+	if _, ok := os.LookupEnv(envKey(key)); ok {
+		return "env (" + envKey(key) + ")"
+	}
+	if viper.InConfig(key) {
+		return "config file"
+	}
+	return "default"
+}
+
+func envKey(key string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+func maskPassword(pw string) string {
+	if pw == "" {
+		return ""
+	}
+	return "********"
+}
+
+// maskProxyPassword hides the password in a proxy URL
+func maskProxyPassword(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil || u.User == nil {
+		return proxy
+	}
+	if _, ok := u.User.Password(); !ok {
+		return proxy
+	}
+	return fmt.Sprintf("%s://%s:%s@%s", u.Scheme, u.User.Username(), maskPassword("x"), u.Host)
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Noso-Project/noso-go/internal/miner"
+)
+
+// Exit codes of the mine, pool and proxy commands. Scripts that restart
+// noso-go (see examples/noso-go.sh) should stop on ExitConfig and
+// ExitAuthFailed, since restarting doesn't fix a bad setting or password.
+// 2 is left out because the Go runtime exits with it when it crashes
+const (
+	ExitOK             = 0
+	ExitConfig         = 1
+	ExitConnectionLost = 3
+	ExitAuthFailed     = 4
+)
+
+// exitCode returns the exit code for the error a mining session ended
+// with
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, miner.ErrConnectionLost):
+		return ExitConnectionLost
+	case errors.Is(err, miner.ErrAuthFailed):
+		return ExitAuthFailed
+	default:
+		return ExitConfig
+	}
+}
+
+// runSession runs fn with a context that is cancelled on SIGINT or
+// SIGTERM, then closes the log file and exits with the code matching the
+// error fn returned. A second signal kills noso-go right away
+func runSession(fn func(ctx context.Context) error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := fn(ctx)
+	stop()
+
+	closeLogging()
+	os.Exit(exitCode(err))
+}
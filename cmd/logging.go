@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// logFile is the file setupLogging opened, if any
+var logFile *logging.RotatingFile
+
+// logKeys are the configuration keys of the logging flags, which every
+// command accepts
+var logKeys = []string{
+	"log-level",
+	"log-format",
+	"log-file",
+	"log-max-size",
+	"log-max-age",
+	"log-max-backups",
+	"log-retention",
+}
+
+func addLogFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+	flags.String("log-level", "info", "Log level: debug, info, warn or error. Pool traffic is logged at debug")
+	flags.String("log-format", logging.FormatText, "Log format: text or json")
+	flags.String("log-file", "", "Log file (default noso-go.log next to the executable when mining, \"-\" disables it)")
+	flags.Int("log-max-size", 10, "Rotate the log file once it reaches this many MB (0 disables)")
+	flags.Duration("log-max-age", 0, "Rotate the log file once it is this old, e.g. 24h (0 disables)")
+	flags.Int("log-max-backups", 5, "Number of rotated log files to keep (0 keeps all)")
+	flags.Duration("log-retention", 0, "Delete rotated log files older than this, e.g. 168h (0 keeps them)")
+
+	for _, key := range logKeys {
+		viper.BindPFlag(key, flags.Lookup(key))
+	}
+}
+
+// setupLogging configures the default logger from the log settings. Log
+// messages go to stdout, and also to the log file if one is set. When
+// defaultFile is true and no log file is set, noso-go.log next to the
+// executable is used
+func setupLogging(defaultFile bool) {
+	level, err := logging.ParseLevel(viper.GetString("log-level"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitConfig)
+	}
+	format, err := logging.ParseFormat(viper.GetString("log-format"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitConfig)
+	}
+
+	fileName := viper.GetString("log-file")
+	if fileName == "" && defaultFile {
+		ex, _ := os.Executable()
+		fileName = filepath.Join(filepath.Dir(ex), "noso-go.log")
+	}
+
+	var out io.Writer = os.Stdout
+	if fileName != "" && fileName != "-" {
+		file := &logging.RotatingFile{
+			Path:       fileName,
+			MaxSize:    int64(viper.GetInt("log-max-size")) * 1024 * 1024,
+			MaxAge:     viper.GetDuration("log-max-age"),
+			MaxBackups: viper.GetInt("log-max-backups"),
+			Retention:  viper.GetDuration("log-retention"),
+		}
+		if err := file.Open(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing to log file: %v\n", err)
+		} else {
+			out = io.MultiWriter(os.Stdout, file)
+			logFile = file
+			defer logging.Infof("Writing logs to: %s", fileName)
+		}
+	}
+
+	logger := logging.New(out, level, format)
+	logging.SetDefault(logger)
+
+	// Anything still using the standard library logger ends up in the same
+	// place
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logging.LevelInfo))
+}
+
+// closeLogging flushes and closes the log file before noso-go exits
+func closeLogging() {
+	if logFile != nil {
+		logFile.Close()
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright © 2021 Levi Noecker <levi.noecker@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/mockpool"
+	"github.com/spf13/cobra"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
+)
+
+var (
+	mockPoolCfg            = mockpool.DefaultConfig()
+	mockPoolListen         string
+	mockPoolStatusInterval int
+	mockPoolTLSCert        string
+	mockPoolTLSKey         string
+)
+
+// mockPoolCmd represents the mockpool command
+var mockPoolCmd = &cobra.Command{
+	Use:   "mockpool",
+	Short: "Run a local mock Noso pool for development and testing",
+	Long: `Run a local server that speaks the pool side of the Noso pool protocol.
+It answers JOIN, PING, STEP, PAYMENT and STATUS, validates every step
+by recomputing its hash, and starts a new block every --block-interval.
+Faults can be injected to test how miners cope with a misbehaving pool.
+Example usage:
+
+Start an easy pool and mine against it
+./noso-go mockpool --listen 127.0.0.1:8082 --diff 40
+./noso-go mine --address 127.0.0.1 --port 8082 --password x --wallet test
+
+Reject 10% of steps and drop every connection every 2 minutes
+./noso-go mockpool --step-fail-rate 0.1 --drop-interval 2m
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		setupLogging(false)
+		mockPoolCfg.Logf = logging.Debugf
+
+		if mockPoolTLSCert != "" || mockPoolTLSKey != "" {
+			cert, err := tls.LoadX509KeyPair(mockPoolTLSCert, mockPoolTLSKey)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not load TLS certificate: %v\n", err)
+				os.Exit(1)
+			}
+			mockPoolCfg.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+		}
+
+		pool := mockpool.New(mockPoolCfg)
+		if err := pool.Start(mockPoolListen); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not start mock pool: %v\n", err)
+			os.Exit(1)
+		}
+		logging.Infof("Mock pool listening on %s (block %d, diff %d, target chars %d)\n",
+			pool.Addr(), mockPoolCfg.Block, mockPoolCfg.Diff, mockPoolCfg.TargetChars)
+
+		for range time.Tick(time.Duration(mockPoolStatusInterval) * time.Second) {
+			block, _ := pool.Block()
+			stats := pool.Stats()
+			logging.Infof("Block %d: %d connections, %d joins, %d steps accepted, %d steps failed\n",
+				block, stats.Connections, stats.Joins, stats.StepsAccepted, stats.StepsFailed)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mockPoolCmd)
+
+	mockPoolCmd.Flags().StringVar(&mockPoolListen, "listen", "127.0.0.1:8082", "Address to listen on")
+	mockPoolCmd.Flags().StringVar(&mockPoolCfg.Password, "password", "", "Pool password (default accepts any)")
+	mockPoolCmd.Flags().StringVar(&mockPoolCfg.PoolAddr, "pool-addr", mockPoolCfg.PoolAddr, "Pool wallet address sent in JOINOK and hashed into every step")
+	mockPoolCmd.Flags().StringVar(&mockPoolCfg.MinerSeed, "miner-seed", mockPoolCfg.MinerSeed, "Miner seed sent in JOINOK")
+	mockPoolCmd.Flags().IntVar(&mockPoolCfg.Block, "block", mockPoolCfg.Block, "First block number")
+	mockPoolCmd.Flags().IntVar(&mockPoolCfg.TargetChars, "chars", mockPoolCfg.TargetChars, "Target chars")
+	mockPoolCmd.Flags().IntVar(&mockPoolCfg.Diff, "diff", mockPoolCfg.Diff, "Difficulty, lower is easier")
+	mockPoolCmd.Flags().IntVar(&mockPoolCfg.PoolDepth, "depth", mockPoolCfg.PoolDepth, "Pool depth, the number of target lengths accepted below the difficulty")
+	mockPoolCmd.Flags().IntVar(&mockPoolCfg.Shares, "shares", mockPoolCfg.Shares, "Shares credited for every accepted step")
+	mockPoolCmd.Flags().Int64Var(&mockPoolCfg.Balance, "balance", mockPoolCfg.Balance, "Starting balance of every wallet, in the pool's integer units")
+	mockPoolCmd.Flags().IntVar(&mockPoolCfg.BlocksTillPayment, "blocks-till-payment", mockPoolCfg.BlocksTillPayment, "Blocks till payment reported to every wallet")
+	mockPoolCmd.Flags().DurationVar(&mockPoolCfg.BlockInterval, "block-interval", 10*time.Minute, "Start a new block this often (0 disables)")
+	mockPoolCmd.Flags().BoolVar(&mockPoolCfg.PassFailed, "pass-failed", false, "Answer every JOIN with PASSFAILED")
+	mockPoolCmd.Flags().Float64Var(&mockPoolCfg.StepFailRate, "step-fail-rate", 0, "Fraction of valid steps answered with STEPFAIL")
+	mockPoolCmd.Flags().DurationVar(&mockPoolCfg.MalformedInterval, "malformed-interval", 0, "Send a malformed line to every miner this often (0 disables)")
+	mockPoolCmd.Flags().DurationVar(&mockPoolCfg.DropInterval, "drop-interval", 0, "Drop every connection this often (0 disables)")
+	mockPoolCmd.Flags().StringVar(&mockPoolTLSCert, "tls-cert", "", "Serve TLS with this PEM certificate")
+	mockPoolCmd.Flags().StringVar(&mockPoolTLSKey, "tls-key", "", "PEM private key of --tls-cert")
+	mockPoolCmd.Flags().IntVar(&mockPoolStatusInterval, "status-interval", 60, "Status Interval Timer (in seconds)")
+
+	mockPoolCmd.Flags().SortFlags = false
+}
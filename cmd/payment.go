@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/miner"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	paymentPassword string
+	paymentTimeout  time.Duration
+	paymentDial     miner.DialOpts
+)
+
+var paymentCmd = &cobra.Command{
+	Use:   "payment",
+	Short: "Request payments from a pool",
+	Long: `Request payments from a pool
+
+While mining, noso-go asks the pool to pay out a vested balance according
+to its payment policy (see --auto-payment, --payment-min-balance,
+--payment-interval and --payment-windows on 'noso-go mine'). Use
+'noso-go payment request' to ask for a payment yourself.
+`,
+}
+
+var paymentRequestCmd = &cobra.Command{
+	Use:   "request <pool>",
+	Short: "Ask a pool to pay out the balance of a wallet",
+	Long: `Ask a pool to pay out the balance of a wallet
+
+Sends a single PAYMENT to the pool and waits for its PAYMENTOK. Both are
+logged to payments.csv in --data-dir, like the payments made while
+mining (see 'noso-go payments').
+
+<pool> is a pool name (see 'noso-go pool list') or a host[:port], in
+which case --password is needed too.
+
+Example usage:
+
+./noso-go payment request devnoso --wallet <your wallet address>
+./noso-go payment request 75.45.193.238:8082 --password duke --wallet <your wallet address>
+`,
+	Args: cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return bindMineFlags(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		requireKeys(cmd, "wallet")
+		wallets := getWallets()
+		if len(wallets) != 1 {
+			cmd.PrintErrln("Error: give the one wallet to pay with --wallet")
+			os.Exit(ExitConfig)
+		}
+
+		endpoints, err := argPool(args[0], paymentPassword, paymentDial)
+		if err != nil {
+			cmd.PrintErrf("Error: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+
+		opts := &miner.Opts{Wallets: wallets, DataDir: viper.GetString("data-dir")}
+		if err := usePools(opts, endpoints[:1]); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not get IP address for domain: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+
+		setupLogging(false)
+		payment, err := miner.RequestPayment(opts, paymentTimeout)
+		closeLogging()
+		if err != nil {
+			cmd.PrintErrf("Error: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		amount, _ := miner.ParseNosoAmount(payment.Amount)
+		fmt.Printf("Paid %s Noso to %s in block %d, order %s\n", amount, payment.Wallet, payment.Block, payment.OrderId)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(paymentCmd)
+	paymentCmd.AddCommand(paymentRequestCmd)
+
+	paymentRequestCmd.Flags().StringSliceP("wallet", "w", []string{}, "Noso wallet address to pay")
+	paymentRequestCmd.Flags().StringVarP(&paymentPassword, "password", "p", "", "Pool password, when <pool> is a host[:port]")
+	paymentRequestCmd.Flags().DurationVar(&paymentTimeout, "timeout", 30*time.Second, "How long to wait for the payment")
+	paymentRequestCmd.Flags().String("data-dir", defaultDataDir(), "Directory payments.csv is kept in")
+	addDialFlags(paymentRequestCmd, &paymentDial)
+}
+
+// addPaymentFlags defines the flags that make up the payment policy
+func addPaymentFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("auto-payment", true, "Ask the pool to pay out the balance once it is vested (false leaves it to 'noso-go payment request')")
+	cmd.Flags().String("payment-min-balance", "0", "Only ask for payment once the balance is at least this many Noso (e.g. '10' or '2.5')")
+	cmd.Flags().Duration("payment-interval", miner.DefaultPaymentInterval, "Least time between payment requests for a wallet (e.g. '24h' for once a day)")
+	cmd.Flags().StringSlice("payment-windows", []string{}, "Only ask for payment during these local times of day (e.g. '02:00-06:00,22:00-23:00')")
+}
+
+// getPaymentPolicy returns the configured payment policy
+func getPaymentPolicy() (miner.PaymentPolicy, error) {
+	policy := miner.PaymentPolicy{
+		Disabled: !viper.GetBool("auto-payment"),
+		Interval: viper.GetDuration("payment-interval"),
+	}
+
+	min, err := parseNosoArg(viper.GetString("payment-min-balance"))
+	if err != nil {
+		return policy, fmt.Errorf("--payment-min-balance: %v", err)
+	}
+	policy.MinBalance = min
+
+	if policy.Interval < 0 {
+		return policy, errors.New("--payment-interval can't be negative")
+	}
+
+	for _, w := range viper.GetStringSlice("payment-windows") {
+		for _, s := range strings.Split(w, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			window, err := miner.ParsePaymentWindow(s)
+			if err != nil {
+				return policy, fmt.Errorf("--payment-windows: %v", err)
+			}
+			policy.Windows = append(policy.Windows, window)
+		}
+	}
+
+	return policy, nil
+}
+
+// parseNosoArg parses an amount given on the command line. Unlike in pool
+// messages, whole numbers are Noso, not the pool's units. Empty is zero
+func parseNosoArg(s string) (miner.NosoAmount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return miner.ParseNosoAmount(s)
+}
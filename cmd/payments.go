@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/miner"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	paymentsFile       string
+	paymentsSince      string
+	paymentsUntil      string
+	paymentsWallet     string
+	paymentsGroupBy    string
+	paymentsUnanswered bool
+	paymentsOutput     string
+	paymentsExport     string
+)
+
+var paymentsCmd = &cobra.Command{
+	Use:   "payments",
+	Short: "Reconcile and report the payouts logged in payments.csv",
+	Long: `Reconcile and report the payouts logged in payments.csv
+
+'noso-go mine' logs every payment it requests, and every payment the
+pool makes, to payments.csv in --data-dir. This command matches each
+response with the requests for it (by wallet and block, at the same
+pool), flags requests that never got a response, and lists the payouts
+or adds them up by wallet, pool or month.
+
+The miner asks for payment again every 10 minutes until it is paid, so
+one payout often answers several requests. Requests still waiting for an
+answer are shown as unanswered.
+
+--since and --until take a duration back from now (e.g. 12h or 7d), a
+date (2021-05-01) or an RFC 3339 time.
+
+Example usage:
+
+./noso-go payments
+./noso-go payments --unanswered
+./noso-go payments --since 2021-05-01 --group-by month
+./noso-go payments --group-by wallet --output csv --export payouts.csv
+`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return bindMineFlags(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		now := time.Now()
+		since, err := parseTimeArg(paymentsSince, now)
+		if err != nil {
+			cmd.PrintErrf("Error: --since: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		until, err := parseTimeArg(paymentsUntil, now)
+		if err != nil {
+			cmd.PrintErrf("Error: --until: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+
+		path := miner.DataPath(viper.GetString("data-dir"), paymentsFile)
+		records, err := miner.ReadPayments(path)
+		if err != nil {
+			cmd.PrintErrf("Error: no payments at %s: %v\n", path, err)
+			os.Exit(ExitConfig)
+		}
+
+		payouts := []miner.Payout{}
+		for _, p := range miner.ReconcilePayments(records) {
+			t := p.Time()
+			if !since.IsZero() && t.Before(since) {
+				continue
+			}
+			if !until.IsZero() && !t.Before(until) {
+				continue
+			}
+			if paymentsWallet != "" && p.Wallet != paymentsWallet {
+				continue
+			}
+			if paymentsUnanswered && p.Status != miner.PayoutUnanswered {
+				continue
+			}
+			payouts = append(payouts, p)
+		}
+
+		var totals []miner.PayoutTotals
+		if paymentsGroupBy != "" {
+			if totals, err = miner.SumPayouts(payouts, paymentsGroupBy); err != nil {
+				cmd.PrintErrf("Error: --group-by: %v\n", err)
+				os.Exit(ExitConfig)
+			}
+		}
+
+		out := io.Writer(os.Stdout)
+		if paymentsExport != "" {
+			f, err := os.Create(paymentsExport)
+			if err != nil {
+				cmd.PrintErrf("Error: %v\n", err)
+				os.Exit(ExitConfig)
+			}
+			defer f.Close()
+			out = f
+		}
+
+		rows := len(payouts)
+		if totals != nil {
+			rows = len(totals)
+			err = writePayoutTotals(out, totals, paymentsGroupBy, paymentsOutput)
+		} else {
+			err = writePayouts(out, payouts, paymentsOutput)
+		}
+		if err != nil {
+			cmd.PrintErrf("Error: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		if paymentsExport != "" {
+			fmt.Printf("Exported %d row(s) to %s\n", rows, paymentsExport)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(paymentsCmd)
+
+	paymentsCmd.Flags().String("data-dir", defaultDataDir(), "Directory payments.csv is kept in")
+	paymentsCmd.Flags().StringVar(&paymentsFile, "file", miner.PaymentsFile, "Payments file to read, in --data-dir")
+	paymentsCmd.Flags().StringVar(&paymentsSince, "since", "", "Only show payouts made (or requested, if unanswered) after this time")
+	paymentsCmd.Flags().StringVar(&paymentsUntil, "until", "", "Only show payouts made (or requested, if unanswered) before this time")
+	paymentsCmd.Flags().StringVar(&paymentsWallet, "wallet", "", "Only show payouts to this wallet")
+	paymentsCmd.Flags().StringVar(&paymentsGroupBy, "group-by", "", fmt.Sprintf("Add up the payouts by %s, instead of listing them", strings.Join(miner.PayoutGroups, ", ")))
+	paymentsCmd.Flags().BoolVar(&paymentsUnanswered, "unanswered", false, "Only show requests the pool never answered")
+	paymentsCmd.Flags().StringVarP(&paymentsOutput, "output", "o", OutputTable, fmt.Sprintf("Output format: %s, %s or %s", OutputTable, OutputCSV, OutputJSON))
+	paymentsCmd.Flags().StringVar(&paymentsExport, "export", "", "Write the output to this file instead of the terminal")
+}
+
+func formatPayoutTime(t *time.Time, layout string) string {
+	if t == nil {
+		return ""
+	}
+	return t.Local().Format(layout)
+}
+
+func formatBlock(block int) string {
+	if block == 0 {
+		return ""
+	}
+	return strconv.Itoa(block)
+}
+
+func writePayouts(out io.Writer, payouts []miner.Payout, output string) error {
+	switch output {
+	case OutputTable:
+		if len(payouts) == 0 {
+			_, err := fmt.Fprintln(out, "No payments logged in this time range")
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "STATUS\tPOOL\tWALLET\tREQUESTED\tREQUESTS\tBALANCE\tPAID\tBLOCK\tAMOUNT\tORDER ID")
+		var (
+			paid       miner.NosoAmount
+			unanswered int
+		)
+		for _, p := range payouts {
+			balance, amount := "", ""
+			if p.Requested != nil {
+				balance = p.RequestBalance.String()
+			}
+			if p.Paid != nil {
+				amount = p.Amount.String()
+				paid += p.Amount
+			} else {
+				unanswered += p.Requests
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+				p.Status, p.Pool, p.Wallet,
+				formatPayoutTime(p.Requested, "2006-01-02 15:04"), p.Requests, balance,
+				formatPayoutTime(p.Paid, "2006-01-02 15:04"), formatBlock(p.Block), amount, p.OrderId)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "\nTotal paid: %s Noso\n", paid)
+		if unanswered > 0 {
+			fmt.Fprintf(out, "Warning: %d payment request(s) never got a response (see --unanswered)\n", unanswered)
+		}
+		return nil
+	case OutputCSV:
+		w := csv.NewWriter(out)
+		w.Write([]string{"status", "pool", "wallet", "requested", "requests", "request_block", "request_balance", "paid", "block", "amount", "order_id"})
+		for _, p := range payouts {
+			balance, amount := "", ""
+			if p.Requested != nil {
+				balance = p.RequestBalance.String()
+			}
+			if p.Paid != nil {
+				amount = p.Amount.String()
+			}
+			w.Write([]string{
+				p.Status,
+				p.Pool,
+				p.Wallet,
+				formatPayoutTime(p.Requested, time.RFC3339),
+				strconv.Itoa(p.Requests),
+				formatBlock(p.RequestBlock),
+				balance,
+				formatPayoutTime(p.Paid, time.RFC3339),
+				formatBlock(p.Block),
+				amount,
+				p.OrderId,
+			})
+		}
+		w.Flush()
+		return w.Error()
+	case OutputJSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(payouts)
+	default:
+		return fmt.Errorf("unknown --output %q, use %s, %s or %s", output, OutputTable, OutputCSV, OutputJSON)
+	}
+}
+
+func writePayoutTotals(out io.Writer, totals []miner.PayoutTotals, group, output string) error {
+	switch output {
+	case OutputTable:
+		if len(totals) == 0 {
+			_, err := fmt.Fprintln(out, "No payments logged in this time range")
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		header := "PAYOUTS\tPAID\tREQUESTS\tUNANSWERED"
+		if group != miner.GroupTotal {
+			header = strings.ToUpper(group) + "\t" + header
+		}
+		fmt.Fprintln(w, header)
+		for _, t := range totals {
+			row := fmt.Sprintf("%d\t%s\t%d\t%d", t.Payouts, t.Paid, t.Requests, t.Unanswered)
+			if group != miner.GroupTotal {
+				row = t.Key + "\t" + row
+			}
+			fmt.Fprintln(w, row)
+		}
+		return w.Flush()
+	case OutputCSV:
+		w := csv.NewWriter(out)
+		w.Write([]string{"group", "payouts", "paid", "requests", "unanswered"})
+		for _, t := range totals {
+			key := t.Key
+			if group == miner.GroupTotal {
+				key = miner.GroupTotal
+			}
+			w.Write([]string{key, strconv.Itoa(t.Payouts), t.Paid.String(), strconv.Itoa(t.Requests), strconv.Itoa(t.Unanswered)})
+		}
+		w.Flush()
+		return w.Error()
+	case OutputJSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(totals)
+	default:
+		return fmt.Errorf("unknown --output %q, use %s, %s or %s", output, OutputTable, OutputCSV, OutputJSON)
+	}
+}
@@ -22,31 +22,30 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
 	"os"
-	"sort"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/Noso-Project/noso-go/internal/hasher"
 	"github.com/Noso-Project/noso-go/internal/miner"
+	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var (
-	list     bool
-	info     bool
-	pools    map[string]PoolInfo
-	poolOpts = &miner.Opts{}
+	list      bool
+	info      bool
+	poolsFile string
+	pools     map[string]miner.PoolEntry
+	poolOpts  = &miner.Opts{}
 )
 
-type PoolInfo struct {
-	primary string
-	aliases []string
-	opts    *miner.Opts
-}
-
 // poolCmd represents the pool command
 var poolCmd = &cobra.Command{
 	Use:   "pool",
@@ -65,6 +64,10 @@ Start mining with a pool
 ./noso-go mine pool leviable   --wallet <your wallet address>
 ./noso-go mine pool dukedog    --wallet <your wallet address>
 ./noso-go mine pool russiapool --wallet <your wallet address>
+
+Private pools can be added to the pool registry with 'noso-go pool add'.
+If a pool has fallback addresses, the miner fails over to them in order
+when the pool is down (see --failover-after and --failback-interval)
 `,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if list {
@@ -80,6 +83,9 @@ Start mining with a pool
 
 		return nil
 	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return bindMineFlags(cmd)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		if list {
 			listPools()
@@ -94,13 +100,10 @@ Start mining with a pool
 			return
 		}
 
-		if len(poolOpts.Wallets) == 0 {
-			cmd.PrintErrln("Error: required flag(s) \"--wallet\" not set")
-			cmd.PrintErrf("Run '%v --help' for usage.\n", cmd.CommandPath())
-			os.Exit(1)
-		}
+		requireKeys(cmd, "wallet")
+		loadMineOpts(poolOpts)
 
-		if randomize, _ := cmd.Flags().GetBool("random-wallet"); randomize {
+		if viper.GetBool("random-wallet") {
 			w := poolOpts.Wallets
 			rand.Seed(time.Now().UnixNano())
 			rand.Shuffle(len(w), func(i, j int) { w[i], w[j] = w[j], w[i] })
@@ -108,112 +111,95 @@ Start mining with a pool
 
 		if poolOpts.Cpu < 1 {
 			cmd.PrintErrln("Error: --cpu cannot be less than 1")
-			os.Exit(1)
+			os.Exit(ExitConfig)
 		}
 
-		ipAddr, err := lookupIP(pool.opts.IpAddr)
-		if err != nil {
+		if err := usePools(poolOpts, withDialOpts(pool.Endpoints(), poolOpts.Dial)); err != nil {
 			fmt.Fprintf(os.Stderr, "Could not get IP address for domain: %v\n", err)
-			os.Exit(1)
+			os.Exit(ExitConfig)
 		}
-		poolOpts.IpAddr = ipAddr
-		poolOpts.IpPort = pool.opts.IpPort
-		poolOpts.PoolPw = pool.opts.PoolPw
 
-		miner.Mine(poolOpts)
+		setupLogging(true)
+		runSession(func(ctx context.Context) error { return miner.Mine(ctx, poolOpts) })
 	},
 }
 
 func init() {
-	loadPools()
 	mineCmd.AddCommand(poolCmd)
 
 	poolCmd.Flags().BoolVarP(&list, "list", "l", false, "List known pool names")
 	poolCmd.Flags().BoolVarP(&info, "info", "i", false, "Print Pool information and exit")
 	poolCmd.Flags().StringSliceVarP(&poolOpts.Wallets, "wallet", "w", []string{}, "Noso wallet address to send payments to")
 	poolCmd.Flags().IntVarP(&poolOpts.Cpu, "cpu", "c", 4, "Number of CPU cores to use")
+	poolCmd.Flags().StringVar(&poolOpts.Hasher, "hasher", hasher.Auto, fmt.Sprintf("SHA-256 backend: %s or one of %s", hasher.Auto, strings.Join(hasher.Names(), ", ")))
+	poolCmd.Flags().IntVar(&poolOpts.MaxSolutionErrors, "max-solution-errors", miner.DefaultMaxSolutionErrors, "Stop a mining thread after this many of its solutions fail verification (0 only warns)")
 	poolCmd.Flags().BoolVarP(&poolOpts.ShowPop, "show-pop", "", false, "Show PoP solutions in output")
 	poolCmd.Flags().IntVar(&poolOpts.StatusInterval, "status-interval", 60, "Status Interval Timer (in seconds)")
-	poolCmd.Flags().BoolVarP(&poolOpts.ExitOnRetry, "exit-on-retry", "", false, "Quit noso-go if pool connection is lost")
+	poolCmd.Flags().BoolVarP(&poolOpts.ExitOnRetry, "exit-on-retry", "", false, "Quit noso-go with exit code 3 if the pool connection is lost")
 	poolCmd.Flags().BoolP("random-wallet", "", false, "Randomize order wallets are used")
+	poolCmd.Flags().StringVar(&poolOpts.ApiListen, "api-listen", "", "Serve miner status as JSON on this address (e.g. ':8080' or '127.0.0.1:8080')")
+	poolCmd.Flags().StringVar(&poolOpts.MetricsListen, "metrics-listen", "", "Serve Prometheus metrics on this address (e.g. ':9100')")
+	poolCmd.Flags().StringSlice("rate-windows", []string{"1m", "5m", "15m", "1h"}, "Periods to average hash rates over in the status message, status API and metrics")
+	addPaymentFlags(poolCmd)
+	poolCmd.Flags().String("data-dir", defaultDataDir(), "Directory payments.csv and the stats file are kept in")
+	poolCmd.Flags().String("stats-file", miner.DefaultStatsFile, "Save the mining statistics of every block to this file in --data-dir (empty disables it, see 'noso-go stats')")
+	addShareRuleFlags(poolCmd, poolOpts)
+	addFailoverFlags(poolCmd, poolOpts)
+	addDialFlags(poolCmd, &poolOpts.Dial)
 
 	poolCmd.Flags().SortFlags = false
 	poolCmd.Flags().PrintDefaults()
 }
 
-func printPoolInfo(p PoolInfo) {
+func printPoolInfo(p miner.PoolEntry) {
 	msg := `Pool info for %s:
 	Pool Address : %s
 	Pool Port    : %d
 	Pool Password: %s
 `
-	fmt.Printf(msg, p.primary, p.opts.IpAddr, p.opts.IpPort, p.opts.PoolPw)
+	fmt.Printf(msg, p.Name, p.Address, p.Port, p.Password)
+	if len(p.Aliases) > 0 {
+		fmt.Printf("\tAliases      : %s\n", strings.Join(p.Aliases, ", "))
+	}
+	if len(p.Fallback) > 0 {
+		fmt.Printf("\tFallbacks    : %s\n", strings.Join(p.Fallback, ", "))
+	}
+	if d := p.DialOpts().Summary(); d != "" {
+		fmt.Printf("\tConnection   : %s\n", d)
+	}
 }
 
 func listPools() {
-	poolNames := make(map[string]bool)
-	for _, pool := range pools {
-		name := pool.primary
-		if _, ok := poolNames[name]; !ok {
-			poolNames[name] = true
-		}
-	}
-
 	names := []string{}
-	for k, _ := range poolNames {
-		names = append(names, k)
+	for _, pool := range miner.UniquePools(pools) {
+		names = append(names, pool.Name)
 	}
-	sort.Strings(names)
 
 	nameList := strings.Join(names, "\n\t- ")
 	fmt.Printf("Please use one of the following pool names:\n\t- %s\n", nameList)
 }
 
+// loadPools merges the built in pools with those in the user's pool
+// registry file (see 'noso-go pool --help')
 func loadPools() {
-	// TODO: support loading a pools config file at runtime too
-	// TODO: Use github.com/markbates/pkger to package a Yaml
-	//       file instead of hard coding these here
-	pools = make(map[string]PoolInfo)
-	pools["devnoso"] = PoolInfo{
-		primary: "devnoso",
-		aliases: []string{"devnoso", "devnosoeu", "devnoso.eu"},
-		opts: &miner.Opts{
-			IpAddr: "DevNosoEU.nosocoin.com",
-			IpPort: 8082,
-			PoolPw: "UnMaTcHeD",
-		},
-	}
-	pools["leviable"] = PoolInfo{
-		primary: "leviable",
-		aliases: []string{"leviable", "nosodev", "noso.dev", "poolnosodev", "pool.noso.dev"},
-		opts: &miner.Opts{
-			IpAddr: "pool.noso.dev",
-			IpPort: 8082,
-			PoolPw: "password",
-		},
-	}
-	pools["dukedog"] = PoolInfo{
-		primary: "dukedog",
-		aliases: []string{"dukedogio", "dukedog.io", "duke"},
-		opts: &miner.Opts{
-			IpAddr: "noso.dukedog.io",
-			IpPort: 8082,
-			PoolPw: "duke",
-		},
-	}
-	pools["russiapool"] = PoolInfo{
-		primary: "russiapool",
-		aliases: []string{"russiapool"},
-		opts: &miner.Opts{
-			IpAddr: "95.54.44.147",
-			IpPort: 8082,
-			PoolPw: "RussiaPool",
-		},
+	reg, err := miner.LoadPoolRegistry(poolsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not load pool registry: %v\n", err)
+		os.Exit(ExitConfig)
 	}
 
-	for _, pool := range pools {
-		for _, alias := range pool.aliases {
-			pools[alias] = pool
-		}
+	pools = miner.MergePools(miner.DefaultPools(), reg)
+}
+
+// defaultPoolsFile returns $HOME/.noso-go-pools.yaml, or just the file name
+// if the home directory can't be found
+func defaultPoolsFile() string {
+	name := ".noso-go-pools.yaml"
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return name
 	}
+
+	return filepath.Join(home, name)
 }
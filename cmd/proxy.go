@@ -0,0 +1,115 @@
+/*
+Copyright © 2021 Levi Noecker <levi.noecker@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Noso-Project/noso-go/internal/miner"
+	"github.com/spf13/cobra"
+)
+
+var (
+	proxyOpts     = &miner.Opts{}
+	proxyListen   = &miner.ProxyOpts{}
+	proxyPoolName string
+)
+
+// proxyCmd represents the proxy command
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Share one pool connection between many local miners",
+	Long: `Hold a single connection to a Noso pool and let local noso-go miners
+mine through it. Each miner gets its own slice of the seed space, and
+their steps are forwarded to the pool with per-miner accounting.
+Example usage:
+
+Start the proxy
+./noso-go proxy --pool devnoso --wallet <your wallet address> --listen :8082
+
+Point the miners at the proxy (the wallet is only used for accounting,
+all payments go to the proxy's wallet)
+./noso-go mine --address <proxy address> --port 8082 --password x --wallet rig01
+`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return bindMineFlags(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if proxyPoolName != "" {
+			requireKeys(cmd, "wallet")
+		} else {
+			requireKeys(cmd, "address", "password", "wallet")
+		}
+		loadMineOpts(proxyOpts)
+
+		var upstream []miner.PoolEndpoint
+		if proxyPoolName != "" {
+			pool, ok := pools[strings.ToLower(proxyPoolName)]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Unrecognized pool name %q. Use 'noso-go pool list' for list of pools\n", proxyPoolName)
+				os.Exit(ExitConfig)
+			}
+			upstream = withDialOpts(pool.Endpoints(), proxyOpts.Dial)
+		} else {
+			var err error
+			upstream, err = miner.ParsePoolList(proxyOpts.IpAddr, proxyOpts.IpPort, proxyOpts.PoolPw, proxyOpts.Dial)
+			if err != nil {
+				cmd.PrintErrf("Error: %v\n", err)
+				os.Exit(ExitConfig)
+			}
+		}
+
+		if err := usePools(proxyOpts, upstream); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not get IP address for domain: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+
+		setupLogging(true)
+		runSession(func(ctx context.Context) error { return miner.Proxy(ctx, proxyOpts, proxyListen) })
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(proxyCmd)
+
+	proxyCmd.Flags().StringVar(&proxyListen.Listen, "listen", ":8082", "Address local miners connect to")
+	proxyCmd.Flags().StringVar(&proxyListen.Password, "listen-password", "", "Password local miners must use (default accepts any)")
+	proxyCmd.Flags().IntVar(&proxyListen.Slots, "slots", 16, "Maximum number of local miners, each gets 1/slots of the seed space")
+	proxyCmd.Flags().StringVar(&proxyPoolName, "pool", "", "Named pool to connect to (see 'noso-go pool list'), instead of --address, --port and --password")
+	proxyCmd.Flags().StringVarP(&proxyOpts.IpAddr, "address", "a", "", "Pool IP address (e.g. 'noso.dukedog.io' or '75.45.193.238'), or a comma separated list of host[:port] pools to fail over between")
+	proxyCmd.Flags().IntVar(&proxyOpts.IpPort, "port", 8082, "Pool port")
+	proxyCmd.Flags().StringVarP(&proxyOpts.PoolPw, "password", "p", "", "Pool password")
+	proxyCmd.Flags().StringSliceVarP(&proxyOpts.Wallets, "wallet", "w", []string{}, "Noso wallet address to send payments to")
+	proxyCmd.Flags().IntVar(&proxyOpts.StatusInterval, "status-interval", 60, "Status Interval Timer (in seconds)")
+	proxyCmd.Flags().StringVar(&proxyOpts.ApiListen, "api-listen", "", "Serve proxy status as JSON on this address (e.g. ':8080' or '127.0.0.1:8080')")
+	proxyCmd.Flags().StringVar(&proxyOpts.MetricsListen, "metrics-listen", "", "Serve Prometheus metrics on this address (e.g. ':9100')")
+	proxyCmd.Flags().StringSlice("rate-windows", []string{"1m", "5m", "15m", "1h"}, "Periods to average the effective hash rate over in the proxy status, status API and metrics")
+	addPaymentFlags(proxyCmd)
+	proxyCmd.Flags().String("data-dir", defaultDataDir(), "Directory payments.csv is kept in")
+	addFailoverFlags(proxyCmd, proxyOpts)
+	addDialFlags(proxyCmd, &proxyOpts.Dial)
+
+	proxyCmd.Flags().SortFlags = false
+}
@@ -24,6 +24,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/Noso-Project/noso-go/internal/miner"
 	"github.com/spf13/cobra"
@@ -64,14 +65,16 @@ func Execute() {
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initConfig, loadPools)
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	// TODO: allow use of config file
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.noso-go.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.noso-go.yaml)")
+	rootCmd.PersistentFlags().StringVar(&poolsFile, "pools-file", defaultPoolsFile(), "Pool registry file (YAML, or JSON if it ends in .json)")
+	viper.BindPFlag("pools-file", rootCmd.PersistentFlags().Lookup("pools-file"))
+	addLogFlags(rootCmd)
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -94,10 +97,18 @@ func initConfig() {
 		viper.SetConfigName(".noso-go")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// read in environment variables that match, e.g. NOSO_STATUS_INTERVAL
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	} else if _, notFound := err.(viper.ConfigFileNotFoundError); !notFound || cfgFile != "" {
+		fmt.Fprintf(os.Stderr, "Error reading config file: %v\n", err)
+		os.Exit(1)
 	}
+
+	poolsFile = viper.GetString("pools-file")
 }
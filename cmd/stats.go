@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/miner"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Output formats of the stats command
+const (
+	OutputTable = "table"
+	OutputCSV   = "csv"
+	OutputJSON  = "json"
+)
+
+var (
+	statsSince   string
+	statsUntil   string
+	statsGroupBy string
+	statsOutput  string
+	statsExport  string
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the mining statistics saved across restarts",
+	Long: `Show the mining statistics saved across restarts
+
+'noso-go mine' saves what it did on every block (PoP sent, accepted and
+failed, shares earned, hashes and hash rate) to --stats-file. This
+command adds them up over a time range, optionally grouped by block,
+day, month, pool or wallet, and prints or exports them.
+
+--since and --until take a duration back from now (e.g. 12h or 7d), a
+date (2021-05-01) or an RFC 3339 time.
+
+Example usage:
+
+./noso-go stats
+./noso-go stats --since 7d --group-by day
+./noso-go stats --since 2021-05-01 --until 2021-06-01 --group-by pool
+./noso-go stats --group-by block --output csv --export blocks.csv
+`,
+	Args: cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return bindMineFlags(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		now := time.Now()
+		since, err := parseTimeArg(statsSince, now)
+		if err != nil {
+			cmd.PrintErrf("Error: --since: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		until, err := parseTimeArg(statsUntil, now)
+		if err != nil {
+			cmd.PrintErrf("Error: --until: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+
+		path := miner.DataPath(viper.GetString("data-dir"), viper.GetString("stats-file"))
+		if _, err := os.Stat(path); err != nil {
+			cmd.PrintErrf("Error: no stats at %s: %v\n", path, err)
+			os.Exit(ExitConfig)
+		}
+		db, err := miner.OpenStatsDB(path)
+		if err != nil {
+			cmd.PrintErrf("Error: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		records, err := db.Records(since, until)
+		if err != nil {
+			cmd.PrintErrf("Error: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		totals, err := miner.SumBlockRecords(records, statsGroupBy)
+		if err != nil {
+			cmd.PrintErrf("Error: --group-by: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+
+		out := io.Writer(os.Stdout)
+		if statsExport != "" {
+			f, err := os.Create(statsExport)
+			if err != nil {
+				cmd.PrintErrf("Error: %v\n", err)
+				os.Exit(ExitConfig)
+			}
+			defer f.Close()
+			out = f
+		}
+
+		switch statsOutput {
+		case OutputTable:
+			err = writeStatsTable(out, totals, statsGroupBy)
+		case OutputCSV:
+			err = writeStatsCSV(out, totals, statsGroupBy)
+		case OutputJSON:
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(totals)
+		default:
+			err = fmt.Errorf("unknown --output %q, use %s, %s or %s", statsOutput, OutputTable, OutputCSV, OutputJSON)
+		}
+		if err != nil {
+			cmd.PrintErrf("Error: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		if statsExport != "" {
+			fmt.Printf("Exported %d row(s) to %s\n", len(totals), statsExport)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+
+	statsCmd.Flags().String("data-dir", defaultDataDir(), "Directory payments.csv and the stats file are kept in")
+	statsCmd.Flags().String("stats-file", miner.DefaultStatsFile, "File in --data-dir the per-block mining statistics are saved in")
+	statsCmd.Flags().StringVar(&statsSince, "since", "", "Only count blocks that ended after this time")
+	statsCmd.Flags().StringVar(&statsUntil, "until", "", "Only count blocks that ended before this time")
+	statsCmd.Flags().StringVar(&statsGroupBy, "group-by", miner.GroupTotal, fmt.Sprintf("Add up the statistics by %s", strings.Join(miner.StatsGroups, ", ")))
+	statsCmd.Flags().StringVarP(&statsOutput, "output", "o", OutputTable, fmt.Sprintf("Output format: %s, %s or %s", OutputTable, OutputCSV, OutputJSON))
+	statsCmd.Flags().StringVar(&statsExport, "export", "", "Write the output to this file instead of the terminal")
+}
+
+// parseTimeArg parses a --since or --until value: a duration back from
+// now (with d for days), a date or an RFC 3339 time. Empty returns the
+// zero time
+func parseTimeArg(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a duration, date or time", s)
+}
+
+func statsRow(t miner.StatsTotals) []string {
+	ratio := ""
+	if answered := t.StepsAccepted + t.StepsFailed; answered > 0 {
+		ratio = fmt.Sprintf("%.1f%%", 100*float64(t.StepsAccepted)/float64(answered))
+	}
+	return []string{
+		strconv.Itoa(t.Blocks),
+		t.First.Local().Format("2006-01-02 15:04"),
+		t.Last.Local().Format("2006-01-02 15:04"),
+		t.Mined.Round(time.Second).String(),
+		strconv.Itoa(t.StepsSent),
+		strconv.Itoa(t.StepsAccepted),
+		strconv.Itoa(t.StepsFailed),
+		ratio,
+		strconv.Itoa(t.SharesEarned),
+		strconv.Itoa(t.Hashes),
+		miner.FormatHashRate(t.HashRate),
+	}
+}
+
+var statsHeader = []string{"BLOCKS", "FIRST", "LAST", "MINED", "POP SENT", "ACCEPTED", "FAILED", "ACCEPT %", "SHARES", "HASHES", "HASH RATE"}
+
+func writeStatsTable(out io.Writer, totals []miner.StatsTotals, group string) error {
+	if len(totals) == 0 {
+		_, err := fmt.Fprintln(out, "No blocks recorded in this time range")
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	header := statsHeader
+	if group != miner.GroupTotal {
+		header = append([]string{strings.ToUpper(group)}, header...)
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, t := range totals {
+		row := statsRow(t)
+		if group != miner.GroupTotal {
+			row = append([]string{t.Key}, row...)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func writeStatsCSV(out io.Writer, totals []miner.StatsTotals, group string) error {
+	w := csv.NewWriter(out)
+	header := []string{"group", "blocks", "first", "last", "mined_seconds", "pop_sent", "pop_accepted", "pop_failed", "shares_earned", "hashes", "hash_rate"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, t := range totals {
+		key := t.Key
+		if group == miner.GroupTotal {
+			key = miner.GroupTotal
+		}
+		w.Write([]string{
+			key,
+			strconv.Itoa(t.Blocks),
+			t.First.Format(time.RFC3339),
+			t.Last.Format(time.RFC3339),
+			strconv.Itoa(int(t.Mined.Seconds())),
+			strconv.Itoa(t.StepsSent),
+			strconv.Itoa(t.StepsAccepted),
+			strconv.Itoa(t.StepsFailed),
+			strconv.Itoa(t.SharesEarned),
+			strconv.Itoa(t.Hashes),
+			strconv.Itoa(t.HashRate),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
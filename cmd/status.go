@@ -22,20 +22,50 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/Noso-Project/noso-go/internal/miner"
 	"github.com/spf13/cobra"
 )
 
+var (
+	statusPassword   string
+	statusDial       miner.DialOpts
+	statusTimeout    time.Duration
+	statusMiners     bool
+	statusSort       string
+	statusMatch      string
+	statusMinBalance string
+	statusVested     bool
+	statusLimit      int
+	statusOutput     string
+)
+
 // statusCmd represents the status command
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Returns status of Noso pool",
-	Long: `Returns the status (miners connected, hashrate, etc) of a given Noso pool. For example:
+	Long: `Returns the status (miners connected, hashrate, etc) of a given Noso pool
+and the pool's entry (balance, blocks till payment) for every --wallet.
+
+<pool> is a pool name (see 'noso-go pool list') or a host[:port], in
+which case --password is needed too.
+
+--miners lists every miner the pool reports, sorted with --sort. --match,
+--min-balance and --vested pick some of them, and imply --miners. In the
+table the given wallets are marked with a '*'. --output csv and json
+write the result for spreadsheets and scripts, the csv has a row per
+miner listed, or per wallet without --miners.
+
 Example usage:
 
 List available pools
@@ -47,8 +77,11 @@ List info about a specific pool
 Get status of a pool
 ./noso-go status devnoso    --wallet <your wallet address>
 ./noso-go status dukedog.io --wallet <your wallet address>
-./noso-go status mining.moe --wallet <your wallet address>
-./noso-go status russiapool --wallet <your wallet address>
+./noso-go status 75.45.193.238:8082 --password duke --wallet <your wallet address>
+
+List the miners of a pool
+./noso-go status devnoso --wallet <your wallet address> --miners --sort blocks
+./noso-go status devnoso --wallet <your wallet address> --min-balance 10 --vested --output csv > miners.csv
 `,
 	Args: func(cmd *cobra.Command, args []string) error {
 		if list {
@@ -57,12 +90,6 @@ Get status of a pool
 		if len(args) < 1 {
 			return errors.New("requires a pool name (e.g. 'noso-go status devnoso')")
 		}
-		poolName := strings.ToLower(args[0])
-		if _, ok := pools[poolName]; !ok {
-			errMsg := fmt.Sprintf("Unrecognized pool name %q. Use 'noso-go status --list' for list of pools", poolName)
-			return errors.New(errMsg)
-		}
-
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
@@ -71,10 +98,12 @@ Get status of a pool
 			return
 		}
 
-		poolName := strings.ToLower(args[0])
-		pool := pools[poolName]
-
 		if info {
+			pool, ok := pools[strings.ToLower(args[0])]
+			if !ok {
+				cmd.PrintErrf("Error: unrecognized pool name %q. Use 'noso-go status --list' for list of pools\n", args[0])
+				os.Exit(ExitConfig)
+			}
 			printPoolInfo(pool)
 			return
 		}
@@ -82,14 +111,68 @@ Get status of a pool
 		if len(poolOpts.Wallets) == 0 {
 			cmd.PrintErrln("Error: required flag(s) \"--wallet\" not set")
 			cmd.PrintErrf("Run '%v --help' for usage.\n", cmd.CommandPath())
-			os.Exit(1)
+			os.Exit(ExitConfig)
 		}
+		// The client takes turns with the wallets, keep them in order
+		wallets := append([]string{}, poolOpts.Wallets...)
 
-		poolOpts.IpAddr = pool.opts.IpAddr
-		poolOpts.IpPort = pool.opts.IpPort
-		poolOpts.PoolPw = pool.opts.PoolPw
+		switch statusOutput {
+		case OutputTable, OutputCSV, OutputJSON:
+		default:
+			cmd.PrintErrf("Error: unknown --output %q, use %s, %s or %s\n", statusOutput, OutputTable, OutputCSV, OutputJSON)
+			os.Exit(ExitConfig)
+		}
+		minBalance, err := parseNosoArg(statusMinBalance)
+		if err != nil {
+			cmd.PrintErrf("Error: --min-balance: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		filter := miner.MinerFilter{Match: statusMatch, MinBalance: minBalance, Vested: statusVested}
+		listed := statusMiners || filter != (miner.MinerFilter{})
 
-		miner.GetPoolStatus(poolOpts)
+		endpoints, err := argPool(args[0], statusPassword, statusDial)
+		if err != nil {
+			cmd.PrintErrf("Error: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+		if err := usePools(poolOpts, endpoints[:1]); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not get IP address for domain: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+
+		status, err := miner.GetPoolStatus(poolOpts, statusTimeout)
+		if err != nil {
+			cmd.PrintErrf("Error: %v\n", err)
+			os.Exit(ExitConfig)
+		}
+
+		var miners []miner.MinerInfo
+		if listed {
+			miners = filter.Filter(status.Miners)
+			if err := miner.SortMiners(miners, statusSort); err != nil {
+				cmd.PrintErrf("Error: --sort: %v\n", err)
+				os.Exit(ExitConfig)
+			}
+			if statusLimit > 0 && len(miners) > statusLimit {
+				miners = miners[:statusLimit]
+			}
+		}
+
+		report := newStatusReport(args[0], status, wallets, miners)
+		switch statusOutput {
+		case OutputTable:
+			err = writeStatusTable(os.Stdout, report, listed)
+		case OutputCSV:
+			err = writeStatusCSV(os.Stdout, report, listed)
+		case OutputJSON:
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(report)
+		}
+		if err != nil {
+			cmd.PrintErrf("Error: %v\n", err)
+			os.Exit(ExitConfig)
+		}
 	},
 }
 
@@ -98,8 +181,145 @@ func init() {
 
 	statusCmd.Flags().BoolVarP(&list, "list", "l", false, "List known pool names")
 	statusCmd.Flags().BoolVarP(&info, "info", "i", false, "Print Pool information and exit")
-	statusCmd.Flags().StringSliceVarP(&poolOpts.Wallets, "wallet", "w", []string{}, "Noso wallet address to send payments to")
+	statusCmd.Flags().StringSliceVarP(&poolOpts.Wallets, "wallet", "w", []string{}, "Noso wallet address to show the pool's entry for")
+	statusCmd.Flags().StringVarP(&statusPassword, "password", "p", "", "Pool password, when <pool> is a host[:port]")
+	statusCmd.Flags().DurationVar(&statusTimeout, "timeout", 10*time.Second, "How long to wait for the pool's status")
+	statusCmd.Flags().BoolVarP(&statusMiners, "miners", "m", false, "List every miner of the pool")
+	statusCmd.Flags().StringVar(&statusSort, "sort", miner.SortByBalance, fmt.Sprintf("Sort the miners by %s", strings.Join(miner.MinerSorts, ", ")))
+	statusCmd.Flags().StringVar(&statusMatch, "match", "", "Only list the miners whose address contains this")
+	statusCmd.Flags().StringVar(&statusMinBalance, "min-balance", "", "Only list the miners with at least this many Noso (e.g. '10' or '2.5')")
+	statusCmd.Flags().BoolVar(&statusVested, "vested", false, "Only list the miners whose balance can be paid out")
+	statusCmd.Flags().IntVar(&statusLimit, "limit", 0, "List at most this many miners (0 lists all)")
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", OutputTable, fmt.Sprintf("Output format: %s, %s or %s", OutputTable, OutputCSV, OutputJSON))
+	addDialFlags(statusCmd, &statusDial)
 
 	statusCmd.Flags().SortFlags = false
-	statusCmd.Flags().PrintDefaults()
+}
+
+// statusMiner is a miner of the pool as the status command reports it
+type statusMiner struct {
+	Address           string           `json:"address"`
+	Balance           miner.NosoAmount `json:"balance"`
+	BlocksTillPayment int              `json:"blocks_till_payment"`
+	// Wallet is set for the wallets given with --wallet
+	Wallet bool `json:"wallet"`
+	// InPool is false for a wallet the pool doesn't list
+	InPool bool `json:"in_pool"`
+}
+
+// statusReport is the output of the status command
+type statusReport struct {
+	Pool         string        `json:"pool"`
+	HashRate     int64         `json:"hash_rate"`
+	FeePercent   float64       `json:"fee_percent"`
+	SharePercent float64       `json:"share_percent"`
+	MinerCount   int           `json:"miner_count"`
+	Wallets      []statusMiner `json:"wallets"`
+	Miners       []statusMiner `json:"miners,omitempty"`
+
+	hashRate string
+	fee      string
+	share    string
+}
+
+func newStatusReport(pool string, status miner.PoolStatus, wallets []string, miners []miner.MinerInfo) statusReport {
+	hr, _ := strconv.ParseInt(status.HashRateRaw, 10, 64)
+	count, _ := strconv.Atoi(status.MinerCnt)
+	report := statusReport{
+		Pool:         pool,
+		HashRate:     hr * 1000,
+		FeePercent:   float64(status.FeeRaw) / 100,
+		SharePercent: float64(status.ShareRaw) / 100,
+		MinerCount:   count,
+		Wallets:      []statusMiner{},
+		hashRate:     strings.TrimSpace(status.HashRate),
+		fee:          status.Fee,
+		share:        status.Share,
+	}
+
+	ours := map[string]bool{}
+	for _, w := range wallets {
+		ours[w] = true
+		m, ok := status.Find(w)
+		if !ok {
+			report.Wallets = append(report.Wallets, statusMiner{Address: w, Wallet: true})
+			continue
+		}
+		report.Wallets = append(report.Wallets, newStatusMiner(m, true))
+	}
+	for _, m := range miners {
+		report.Miners = append(report.Miners, newStatusMiner(m, ours[m.Address]))
+	}
+	return report
+}
+
+func newStatusMiner(m miner.MinerInfo, wallet bool) statusMiner {
+	return statusMiner{
+		Address:           m.Address,
+		Balance:           m.Amount(),
+		BlocksTillPayment: m.Blocks(),
+		Wallet:            wallet,
+		InPool:            true,
+	}
+}
+
+func writeStatusTable(out io.Writer, report statusReport, listed bool) error {
+	fmt.Fprintf(out, "Pool:       %s\n", report.Pool)
+	fmt.Fprintf(out, "Hash Rate:  %s\n", report.hashRate)
+	fmt.Fprintf(out, "Fee:        %s\n", report.fee)
+	fmt.Fprintf(out, "Share:      %s\n", report.share)
+	fmt.Fprintf(out, "Miners:     %d\n\n", report.MinerCount)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "WALLET\tBALANCE\tBLOCKS TILL PAYMENT")
+	for _, m := range report.Wallets {
+		if !m.InPool {
+			fmt.Fprintf(w, "%s\tnot in the pool's miner list\t\n", m.Address)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\n", m.Address, m.Balance, m.BlocksTillPayment)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if !listed {
+		return nil
+	}
+
+	fmt.Fprintf(out, "\n%d miner(s), sorted by %s\n", len(report.Miners), statusSort)
+	if len(report.Miners) == 0 {
+		return nil
+	}
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tADDRESS\tBALANCE\tBLOCKS TILL PAYMENT")
+	for _, m := range report.Miners {
+		mark := ""
+		if m.Wallet {
+			mark = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", mark, m.Address, m.Balance, m.BlocksTillPayment)
+	}
+	return w.Flush()
+}
+
+func writeStatusCSV(out io.Writer, report statusReport, listed bool) error {
+	w := csv.NewWriter(out)
+	if err := w.Write([]string{"address", "balance", "blocks_till_payment", "wallet", "in_pool"}); err != nil {
+		return err
+	}
+	rows := report.Wallets
+	if listed {
+		rows = report.Miners
+	}
+	for _, m := range rows {
+		w.Write([]string{
+			m.Address,
+			m.Balance.String(),
+			strconv.Itoa(m.BlocksTillPayment),
+			strconv.FormatBool(m.Wallet),
+			strconv.FormatBool(m.InPool),
+		})
+	}
+	w.Flush()
+	return w.Error()
 }
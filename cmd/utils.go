@@ -2,10 +2,28 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/Noso-Project/noso-go/internal/miner"
+	homedir "github.com/mitchellh/go-homedir"
 )
 
+// defaultDataDir is where payments.csv and the stats file are kept unless
+// --data-dir says otherwise: .noso-go in the home directory, next to the
+// config file
+func defaultDataDir() string {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(home, ".noso-go")
+}
+
 func lookupIP(addr string) (string, error) {
 	r := &net.Resolver{
 		PreferGo: true,
@@ -22,3 +40,53 @@ func lookupIP(addr string) (string, error) {
 	}
 	return ip[0], nil
 }
+
+// usePools resolves the address of every pool and makes them the pools
+// opts mines on, in order. The primary pool has to resolve, fallback pools
+// that don't are kept by name and looked up again when they are dialed
+func usePools(opts *miner.Opts, pools []miner.PoolEndpoint) error {
+	for i := range pools {
+		if !pools[i].Dial.Direct() {
+			// TLS needs the host name to verify the pool's certificate,
+			// and a proxy resolves it on the other side
+			continue
+		}
+		ipAddr, err := lookupIP(pools[i].Address)
+		if err != nil {
+			if i == 0 {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Could not get IP address for fallback pool %s: %v\n", pools[i].Address, err)
+			continue
+		}
+		pools[i].Address = ipAddr
+	}
+
+	opts.Pools = pools
+	opts.IpAddr = pools[0].Address
+	opts.IpPort = pools[0].Port
+	opts.PoolPw = pools[0].Password
+
+	return nil
+}
+
+// argPool returns the pool named by a command's argument: a named pool, or
+// a host[:port] when password is given
+func argPool(arg, password string, dial miner.DialOpts) ([]miner.PoolEndpoint, error) {
+	if pool, ok := pools[strings.ToLower(arg)]; ok {
+		return withDialOpts(pool.Endpoints(), dial), nil
+	}
+	if password == "" {
+		return nil, fmt.Errorf("unrecognized pool name %q, use 'noso-go pool list' for a list of pools, or give --password with a host[:port]", arg)
+	}
+	return miner.ParsePoolList(arg, 8082, password, dial)
+}
+
+// withDialOpts fills in the dial settings a pool doesn't set itself from
+// those given on the command line
+func withDialOpts(pools []miner.PoolEndpoint, dial miner.DialOpts) []miner.PoolEndpoint {
+	for i := range pools {
+		pools[i].Dial = pools[i].Dial.Merge(dial)
+	}
+	return pools
+}
@@ -58,5 +58,10 @@ tasklist /FI "IMAGENAME eq noso-go.exe" 2>NUL | find /I /N "noso-go.exe">NUL
 if "%ERRORLEVEL%"=="0" taskkill /F /im noso-go.exe
 
 noso-go.exe mine pool !POOL! --wallet !WALLET! --cpu !CPU!
+REM 0: stopped with Ctrl-C, 1: a setting is wrong, 4: the pool rejected
+REM the password. Don't restart for those
+if "%ERRORLEVEL%"=="0" goto :eof
+if "%ERRORLEVEL%"=="1" goto :eof
+if "%ERRORLEVEL%"=="4" goto :eof
 timeout 10
 goto loop
@@ -44,8 +44,11 @@ while true; do
     --exit-on-retry \
     --random-wallet
 
+  # 0 means noso-go was stopped (e.g. with Ctrl-C), 1 means a setting is
+  # wrong and 4 that the pool rejected the password, so don't restart it.
+  # Anything else, like 3 for a lost pool connection, starts it again
   exit_code=$?
-  if [ "$exit_code" != "1" ]; then
+  if [ "$exit_code" != "0" ] && [ "$exit_code" != "1" ] && [ "$exit_code" != "4" ]; then
       continue
   else
       exit $exit_code
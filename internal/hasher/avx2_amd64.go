@@ -0,0 +1,20 @@
+package hasher
+
+// block8AVX2 hashes one block for each of 8 lanes, see laneHasher for the
+// layout of state and words. k points to the round constants
+//
+//go:noescape
+func block8AVX2(state, words, k *uint32)
+
+func init() {
+	register(backend{
+		name:      "avx2",
+		desc:      "AVX2, 8 messages at a time",
+		available: hasAVX2,
+		new: func() Hasher {
+			return newLaneHasher("avx2", 8, func(state, words []uint32) {
+				block8AVX2(&state[0], &words[0], &k[0])
+			})
+		},
+	})
+}
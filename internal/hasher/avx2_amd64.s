@@ -0,0 +1,132 @@
+#include "textflag.h"
+
+// Eight lane SHA-256 compression with AVX2. Every YMM register holds the
+// same 32 bit word of 8 different messages, so each instruction works on
+// all lanes. Y0-Y7 hold a-h, Y8-Y13 are scratch.
+
+// dst = x rotated right by n
+#define ROTR(x, n, m, dst, tmp) \
+	VPSRLD $n, x, dst; \
+	VPSLLD $m, x, tmp; \
+	VPOR   tmp, dst, dst
+
+// One round. W[t] is at woff(R9) and K[t] at koff(DX). d is updated to the
+// new e and h to the new a, the other registers are renamed by the caller
+#define ROUND(a, b, c, d, e, f, g, h, woff, koff) \
+	ROTR(e, 6, 26, Y8, Y9); \
+	ROTR(e, 11, 21, Y9, Y10); \
+	VPXOR        Y9, Y8, Y8; \
+	ROTR(e, 25, 7, Y9, Y10); \
+	VPXOR        Y9, Y8, Y8; \
+	VPAND        f, e, Y9; \
+	VPANDN       g, e, Y10; \
+	VPXOR        Y10, Y9, Y9; \
+	VPADDD       Y9, Y8, Y8; \
+	VPADDD       h, Y8, Y8; \
+	VPADDD       woff(R9), Y8, Y8; \
+	VPBROADCASTD koff(DX), Y9; \
+	VPADDD       Y9, Y8, Y8; \
+	VPADDD       Y8, d, d; \
+	ROTR(a, 2, 30, Y9, Y10); \
+	ROTR(a, 13, 19, Y10, Y11); \
+	VPXOR        Y10, Y9, Y9; \
+	ROTR(a, 22, 10, Y10, Y11); \
+	VPXOR        Y10, Y9, Y9; \
+	VPOR         b, a, Y10; \
+	VPAND        c, Y10, Y10; \
+	VPAND        b, a, Y11; \
+	VPOR         Y11, Y10, Y10; \
+	VPADDD       Y10, Y9, Y9; \
+	VPADDD       Y9, Y8, h
+
+// func block8AVX2(state, words, k *uint32)
+TEXT ·block8AVX2(SB), 0, $2048-24
+	MOVQ state+0(FP), DI
+	MOVQ words+8(FP), SI
+	MOVQ k+16(FP), DX
+
+	// The message schedule W[0..63] of every lane goes on the stack
+	MOVQ SP, R8
+
+	MOVQ R8, R9
+	MOVQ $16, CX
+
+copy:
+	VMOVDQU (SI), Y8
+	VMOVDQU Y8, (R9)
+	ADDQ    $32, SI
+	ADDQ    $32, R9
+	DECQ    CX
+	JNZ     copy
+
+	// W[t] = s1(W[t-2]) + W[t-7] + s0(W[t-15]) + W[t-16], with R9 at W[t-16]
+	MOVQ R8, R9
+	MOVQ $48, CX
+
+schedule:
+	VMOVDQU 32(R9), Y9
+	ROTR(Y9, 7, 25, Y10, Y11)
+	ROTR(Y9, 18, 14, Y11, Y12)
+	VPXOR   Y11, Y10, Y10
+	VPSRLD  $3, Y9, Y11
+	VPXOR   Y11, Y10, Y10
+	VMOVDQU 448(R9), Y9
+	ROTR(Y9, 17, 15, Y11, Y12)
+	ROTR(Y9, 19, 13, Y12, Y13)
+	VPXOR   Y12, Y11, Y11
+	VPSRLD  $10, Y9, Y12
+	VPXOR   Y12, Y11, Y11
+	VPADDD  Y11, Y10, Y10
+	VPADDD  (R9), Y10, Y10
+	VPADDD  288(R9), Y10, Y10
+	VMOVDQU Y10, 512(R9)
+	ADDQ    $32, R9
+	DECQ    CX
+	JNZ     schedule
+
+	VMOVDQU 0(DI), Y0
+	VMOVDQU 32(DI), Y1
+	VMOVDQU 64(DI), Y2
+	VMOVDQU 96(DI), Y3
+	VMOVDQU 128(DI), Y4
+	VMOVDQU 160(DI), Y5
+	VMOVDQU 192(DI), Y6
+	VMOVDQU 224(DI), Y7
+
+	// Eight rounds per loop, after which the registers are back in place
+	MOVQ R8, R9
+	MOVQ $8, CX
+
+rounds:
+	ROUND(Y0, Y1, Y2, Y3, Y4, Y5, Y6, Y7, 0, 0)
+	ROUND(Y7, Y0, Y1, Y2, Y3, Y4, Y5, Y6, 32, 4)
+	ROUND(Y6, Y7, Y0, Y1, Y2, Y3, Y4, Y5, 64, 8)
+	ROUND(Y5, Y6, Y7, Y0, Y1, Y2, Y3, Y4, 96, 12)
+	ROUND(Y4, Y5, Y6, Y7, Y0, Y1, Y2, Y3, 128, 16)
+	ROUND(Y3, Y4, Y5, Y6, Y7, Y0, Y1, Y2, 160, 20)
+	ROUND(Y2, Y3, Y4, Y5, Y6, Y7, Y0, Y1, 192, 24)
+	ROUND(Y1, Y2, Y3, Y4, Y5, Y6, Y7, Y0, 224, 28)
+	ADDQ $256, R9
+	ADDQ $32, DX
+	DECQ CX
+	JNZ  rounds
+
+	VPADDD  0(DI), Y0, Y0
+	VPADDD  32(DI), Y1, Y1
+	VPADDD  64(DI), Y2, Y2
+	VPADDD  96(DI), Y3, Y3
+	VPADDD  128(DI), Y4, Y4
+	VPADDD  160(DI), Y5, Y5
+	VPADDD  192(DI), Y6, Y6
+	VPADDD  224(DI), Y7, Y7
+	VMOVDQU Y0, 0(DI)
+	VMOVDQU Y1, 32(DI)
+	VMOVDQU Y2, 64(DI)
+	VMOVDQU Y3, 96(DI)
+	VMOVDQU Y4, 128(DI)
+	VMOVDQU Y5, 160(DI)
+	VMOVDQU Y6, 192(DI)
+	VMOVDQU Y7, 224(DI)
+
+	VZEROUPPER
+	RET
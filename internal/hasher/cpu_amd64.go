@@ -0,0 +1,35 @@
+package hasher
+
+// cpuid and xgetbv are implemented in cpu_amd64.s
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+func xgetbv() (eax, edx uint32)
+
+// These are set before any init function runs, since the backends use
+// them to register
+var hasAVX2, hasSHA = detectCPU()
+
+func detectCPU() (avx2, sha bool) {
+	maxLeaf, _, _, _ := cpuid(0, 0)
+	if maxLeaf < 7 {
+		return false, false
+	}
+
+	_, _, ecx1, _ := cpuid(1, 0)
+	_, ebx7, _, _ := cpuid(7, 0)
+
+	hasSSSE3 := ecx1&(1<<9) != 0
+	hasSSE41 := ecx1&(1<<19) != 0
+	hasOSXSAVE := ecx1&(1<<27) != 0
+	hasAVX := ecx1&(1<<28) != 0
+
+	// The OS has to save the YMM registers on context switches
+	osYMM := false
+	if hasOSXSAVE {
+		xcr0, _ := xgetbv()
+		osYMM = xcr0&6 == 6
+	}
+
+	avx2 = hasAVX && osYMM && ebx7&(1<<5) != 0
+	sha = hasSSSE3 && hasSSE41 && ebx7&(1<<29) != 0
+	return avx2, sha
+}
@@ -0,0 +1,240 @@
+// Package hasher computes the SHA-256 digests of mining candidates. Every
+// candidate of a job is the same seed prefix followed by a short suffix,
+// so a Hasher is given the prefix once and then hashes several suffixes
+// ("lanes") per call. Backends using SIMD instructions are registered by
+// the architecture specific files and only offered when the CPU has the
+// instructions they need.
+package hasher
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"fmt"
+	"hash"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Size is the size of a SHA-256 digest in bytes
+const Size = sha256.Size
+
+// Auto is the name that selects the fastest backend available
+const Auto = "auto"
+
+// Hasher hashes a constant prefix followed by Lanes() different suffixes
+// at a time. A Hasher is not safe for concurrent use, every worker needs
+// its own
+type Hasher interface {
+	// Name is the name of the backend, as given to New
+	Name() string
+	// Lanes is the number of messages each call to Sum hashes
+	Lanes() int
+	// SetPrefix sets the bytes every message starts with, and the length
+	// of the suffix that follows them
+	SetPrefix(prefix []byte, suffixLen int)
+	// Suffix returns the suffix of a lane, to be filled in before calling
+	// Sum. The slice stays valid until the next call to SetPrefix
+	Suffix(lane int) []byte
+	// Sum sets sums[lane] to the digest of the message of every lane
+	Sum(sums [][Size]byte)
+}
+
+type backend struct {
+	name      string
+	desc      string
+	available bool
+	new       func() Hasher
+}
+
+var backends = []backend{
+	{
+		name:      "go",
+		desc:      "crypto/sha256, one message at a time",
+		available: true,
+		new:       func() Hasher { return &goHasher{} },
+	},
+}
+
+// register adds a backend, called from the init functions of the
+// architecture specific files
+func register(b backend) {
+	backends = append(backends, b)
+}
+
+func lookup(name string) (backend, bool) {
+	for _, b := range backends {
+		if b.name == name {
+			return b, true
+		}
+	}
+	return backend{}, false
+}
+
+// Names returns the name of every backend built for this architecture,
+// whether or not the CPU supports it
+func Names() []string {
+	names := make([]string, 0, len(backends))
+	for _, b := range backends {
+		names = append(names, b.name)
+	}
+	return names
+}
+
+// Available returns the backends this CPU supports
+func Available() []string {
+	names := []string{}
+	for _, b := range backends {
+		if b.available {
+			names = append(names, b.name)
+		}
+	}
+	return names
+}
+
+// Describe returns a one line description of a backend
+func Describe(name string) string {
+	b, ok := lookup(name)
+	if !ok {
+		return ""
+	}
+	return b.desc
+}
+
+// New returns a Hasher using the named backend, or the fastest available
+// one if name is Auto or empty
+func New(name string) (Hasher, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == Auto {
+		name = Best()
+	}
+
+	b, ok := lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown hasher %q, expected %s or one of %s", name, Auto, strings.Join(Names(), ", "))
+	}
+	if !b.available {
+		return nil, fmt.Errorf("hasher %q is not supported by this CPU, available: %s", name, strings.Join(Available(), ", "))
+	}
+	return b.new(), nil
+}
+
+var (
+	bestOnce sync.Once
+	best     string
+)
+
+// Best returns the fastest backend that hashes correctly on this machine.
+// Every available backend is checked against crypto/sha256 and timed
+// briefly the first time Best is called
+func Best() string {
+	bestOnce.Do(func() {
+		best = "go"
+		bestRate := 0.0
+		for _, b := range backends {
+			if !b.available || SelfTest(b.new()) != nil {
+				continue
+			}
+			if rate := measure(b.new(), 20*time.Millisecond); rate > bestRate {
+				best, bestRate = b.name, rate
+			}
+		}
+	})
+	return best
+}
+
+// measure returns the hashes per second h manages with a mining sized
+// message during d
+func measure(h Hasher, d time.Duration) float64 {
+	h.SetPrefix(bytes.Repeat([]byte{'n'}, 48), 4)
+	sums := make([][Size]byte, h.Lanes())
+
+	hashes := 0
+	start := time.Now()
+	for time.Since(start) < d {
+		for i := 0; i < 256; i++ {
+			h.Sum(sums)
+		}
+		hashes += 256 * h.Lanes()
+	}
+	return float64(hashes) / time.Since(start).Seconds()
+}
+
+// SelfTest compares the digests h computes for random messages of many
+// lengths with crypto/sha256
+func SelfTest(h Hasher) error {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	sums := make([][Size]byte, h.Lanes())
+
+	for _, n := range []int{0, 1, 43, 48, 51, 52, 55, 56, 60, 63, 64, 65, 100, 119, 120, 128, 200} {
+		for _, suffixLen := range []int{0, 4, 9} {
+			prefix := make([]byte, n)
+			r.Read(prefix)
+			h.SetPrefix(prefix, suffixLen)
+
+			for round := 0; round < 3; round++ {
+				for lane := 0; lane < h.Lanes(); lane++ {
+					r.Read(h.Suffix(lane))
+				}
+				h.Sum(sums)
+
+				for lane := 0; lane < h.Lanes(); lane++ {
+					msg := append(append([]byte{}, prefix...), h.Suffix(lane)...)
+					if want := sha256.Sum256(msg); sums[lane] != want {
+						return fmt.Errorf("hasher %s: wrong digest for a %d byte message in lane %d: got %x want %x",
+							h.Name(), len(msg), lane, sums[lane], want)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// goHasher uses crypto/sha256, which has its own assembly for some CPUs.
+// The state after the prefix's complete 64 byte blocks is computed once,
+// so only the remaining bytes are hashed for each suffix
+type goHasher struct {
+	h        hash.Hash
+	state    []byte
+	msg      []byte
+	suffixAt int
+}
+
+func (g *goHasher) Name() string { return "go" }
+func (g *goHasher) Lanes() int   { return 1 }
+
+func (g *goHasher) SetPrefix(prefix []byte, suffixLen int) {
+	g.state = nil
+
+	full := len(prefix) / sha256.BlockSize * sha256.BlockSize
+	if full > 0 {
+		g.h = sha256.New()
+		g.h.Write(prefix[:full])
+		// crypto/sha256 has implemented BinaryMarshaler since Go 1.10
+		g.state, _ = g.h.(encoding.BinaryMarshaler).MarshalBinary()
+	}
+	if g.state == nil {
+		full = 0
+	}
+
+	g.msg = make([]byte, len(prefix)-full+suffixLen)
+	g.suffixAt = copy(g.msg, prefix[full:])
+}
+
+func (g *goHasher) Suffix(lane int) []byte {
+	return g.msg[g.suffixAt:]
+}
+
+func (g *goHasher) Sum(sums [][Size]byte) {
+	if g.state == nil {
+		sums[0] = sha256.Sum256(g.msg)
+		return
+	}
+
+	g.h.(encoding.BinaryUnmarshaler).UnmarshalBinary(g.state)
+	g.h.Write(g.msg)
+	g.h.Sum(sums[0][:0])
+}
@@ -0,0 +1,68 @@
+package hasher
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestBackends(t *testing.T) {
+	for _, name := range Names() {
+		h, err := New(name)
+		if err != nil {
+			t.Logf("skipping %s: %v", name, err)
+			continue
+		}
+		if h.Name() != name {
+			t.Errorf("New(%q) returned %s", name, h.Name())
+		}
+		for i := 0; i < 20; i++ {
+			if err := SelfTest(h); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("md5"); err == nil {
+		t.Error("expected an error for an unknown hasher")
+	}
+
+	h, err := New(Auto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Name() != Best() {
+		t.Errorf("auto picked %s, Best is %s", h.Name(), Best())
+	}
+}
+
+func TestBlockGeneric(t *testing.T) {
+	msg := bytes.Repeat([]byte("noso"), 32)
+	state := iv
+	blockGeneric(&state, tail(msg, 0, len(msg)))
+
+	var got [Size]byte
+	for i, v := range state {
+		got[4*i], got[4*i+1], got[4*i+2], got[4*i+3] = byte(v>>24), byte(v>>16), byte(v>>8), byte(v)
+	}
+	if want := sha256.Sum256(msg); got != want {
+		t.Errorf("got %x want %x", got, want)
+	}
+}
+
+func BenchmarkBackends(b *testing.B) {
+	prefix := []byte("1bd1a0ee9!!!N4ZR3fKhTUod34evnEcDQX3i6XufBDU00001")
+	for _, name := range Available() {
+		b.Run(name, func(b *testing.B) {
+			h, _ := New(name)
+			h.SetPrefix(prefix, 4)
+			sums := make([][Size]byte, h.Lanes())
+			b.SetBytes(int64(len(prefix) + 4))
+			for i := 0; i < b.N; i += h.Lanes() {
+				h.Sum(sums)
+			}
+		})
+	}
+}
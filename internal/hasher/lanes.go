@@ -0,0 +1,149 @@
+package hasher
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+var iv = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
+	0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+var k = [64]uint32{
+	0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+	0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+	0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+	0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+	0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+	0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+	0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+	0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+}
+
+// blockGeneric runs the SHA-256 compression function over every 64 byte
+// block of p. It is only used for the prefix, once per job
+func blockGeneric(state *[8]uint32, p []byte) {
+	var w [64]uint32
+
+	for ; len(p) >= 64; p = p[64:] {
+		for t := 0; t < 16; t++ {
+			w[t] = binary.BigEndian.Uint32(p[4*t:])
+		}
+		for t := 16; t < 64; t++ {
+			s0 := bits.RotateLeft32(w[t-15], -7) ^ bits.RotateLeft32(w[t-15], -18) ^ w[t-15]>>3
+			s1 := bits.RotateLeft32(w[t-2], -17) ^ bits.RotateLeft32(w[t-2], -19) ^ w[t-2]>>10
+			w[t] = s1 + w[t-7] + s0 + w[t-16]
+		}
+
+		a, b, c, d, e, f, g, h := state[0], state[1], state[2], state[3], state[4], state[5], state[6], state[7]
+		for t := 0; t < 64; t++ {
+			t1 := h + (bits.RotateLeft32(e, -6) ^ bits.RotateLeft32(e, -11) ^ bits.RotateLeft32(e, -25)) + (e&f ^ ^e&g) + k[t] + w[t]
+			t2 := (bits.RotateLeft32(a, -2) ^ bits.RotateLeft32(a, -13) ^ bits.RotateLeft32(a, -22)) + (a&b ^ a&c ^ b&c)
+			h, g, f, e, d, c, b, a = g, f, e, d+t1, c, b, a, t1+t2
+		}
+
+		state[0] += a
+		state[1] += b
+		state[2] += c
+		state[3] += d
+		state[4] += e
+		state[5] += f
+		state[6] += g
+		state[7] += h
+	}
+}
+
+// tail pads the part of a message after its complete blocks, which are
+// len(message)-len(rest) bytes long, ready to be hashed
+func tail(rest []byte, suffixLen, msgLen int) []byte {
+	n := len(rest) + suffixLen
+	padded := make([]byte, (n+1+8+63)/64*64)
+	copy(padded, rest)
+	padded[n] = 0x80
+	binary.BigEndian.PutUint64(padded[len(padded)-8:], uint64(msgLen)*8)
+	return padded
+}
+
+// laneHasher is the Hasher of the multi-buffer backends. The message of
+// every lane is kept padded, and its words are laid out lane by lane, so
+// word t of block b for a lane is words[(b*16+t)*lanes+lane]. block hashes
+// one such block for every lane at once, with the state laid out the same
+// way. Only the words holding suffix bytes change between calls to Sum
+type laneHasher struct {
+	name  string
+	lanes int
+	block func(state, words []uint32)
+
+	mid      [8]uint32
+	tails    [][]byte
+	suffixAt int
+	suffixTo int
+	words    []uint32
+	state    []uint32
+}
+
+func newLaneHasher(name string, lanes int, block func(state, words []uint32)) *laneHasher {
+	return &laneHasher{
+		name:  name,
+		lanes: lanes,
+		block: block,
+		state: make([]uint32, 8*lanes),
+	}
+}
+
+func (l *laneHasher) Name() string { return l.name }
+func (l *laneHasher) Lanes() int   { return l.lanes }
+
+func (l *laneHasher) SetPrefix(prefix []byte, suffixLen int) {
+	full := len(prefix) / 64 * 64
+	l.mid = iv
+	blockGeneric(&l.mid, prefix[:full])
+
+	l.tails = make([][]byte, l.lanes)
+	for lane := range l.tails {
+		l.tails[lane] = tail(prefix[full:], suffixLen, len(prefix)+suffixLen)
+	}
+	l.suffixAt = len(prefix) - full
+	l.suffixTo = l.suffixAt + suffixLen
+
+	l.words = make([]uint32, len(l.tails[0])/4*l.lanes)
+	for w := 0; w < len(l.tails[0])/4; w++ {
+		l.setWord(w)
+	}
+}
+
+func (l *laneHasher) setWord(w int) {
+	for lane, t := range l.tails {
+		l.words[w*l.lanes+lane] = binary.BigEndian.Uint32(t[4*w:])
+	}
+}
+
+func (l *laneHasher) Suffix(lane int) []byte {
+	return l.tails[lane][l.suffixAt:l.suffixTo]
+}
+
+func (l *laneHasher) Sum(sums [][Size]byte) {
+	if l.suffixTo > l.suffixAt {
+		for w := l.suffixAt / 4; w <= (l.suffixTo-1)/4; w++ {
+			l.setWord(w)
+		}
+	}
+
+	for i, v := range l.mid {
+		for lane := 0; lane < l.lanes; lane++ {
+			l.state[i*l.lanes+lane] = v
+		}
+	}
+
+	blockWords := 16 * l.lanes
+	for b := 0; b < len(l.words); b += blockWords {
+		l.block(l.state, l.words[b:b+blockWords])
+	}
+
+	for lane := 0; lane < l.lanes; lane++ {
+		for i := 0; i < 8; i++ {
+			binary.BigEndian.PutUint32(sums[lane][4*i:], l.state[i*l.lanes+lane])
+		}
+	}
+}
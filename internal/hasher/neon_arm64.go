@@ -0,0 +1,35 @@
+package hasher
+
+import "golang.org/x/sys/cpu"
+
+// block4NEON hashes one block for each of 4 lanes, see laneHasher for the
+// layout of state and words. k points to the round constants repeated for
+// every lane, and w to room for the message schedule of every lane
+//
+//go:noescape
+func block4NEON(state, words, k, w *uint32)
+
+// k4 holds every round constant 4 times, so it loads straight into a
+// vector register
+var k4 = func() (t [64 * 4]uint32) {
+	for i, v := range k {
+		for lane := 0; lane < 4; lane++ {
+			t[4*i+lane] = v
+		}
+	}
+	return t
+}()
+
+func init() {
+	register(backend{
+		name:      "neon",
+		desc:      "ARM NEON, 4 messages at a time",
+		available: cpu.ARM64.HasASIMD,
+		new: func() Hasher {
+			w := make([]uint32, 64*4)
+			return newLaneHasher("neon", 4, func(state, words []uint32) {
+				block4NEON(&state[0], &words[0], &k4[0], &w[0])
+			})
+		},
+	})
+}
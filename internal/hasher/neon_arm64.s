@@ -0,0 +1,124 @@
+#include "textflag.h"
+
+// Four lane SHA-256 compression with NEON. Every vector register holds the
+// same 32 bit word of 4 different messages, so each instruction works on
+// all lanes. V0-V7 hold a-h, V16-V21 are scratch.
+
+// dst = x rotated right by n
+#define ROTR(x, n, m, dst, tmp) \
+	VUSHR $n, x.S4, dst.S4; \
+	VSHL  $m, x.S4, tmp.S4; \
+	VORR  tmp.B16, dst.B16, dst.B16
+
+// One round, with W[t] at R3 and K[t] at R2. d is updated to the new e and
+// h to the new a, the other registers are renamed by the caller
+#define ROUND(a, b, c, d, e, f, g, h) \
+	VLD1.P 16(R3), [V16.S4]; \
+	VLD1.P 16(R2), [V17.S4]; \
+	VADD   V17.S4, V16.S4, V16.S4; \
+	VADD   h.S4, V16.S4, V16.S4; \
+	ROTR(e, 6, 26, V17, V18); \
+	ROTR(e, 11, 21, V18, V19); \
+	VEOR   V18.B16, V17.B16, V17.B16; \
+	ROTR(e, 25, 7, V18, V19); \
+	VEOR   V18.B16, V17.B16, V17.B16; \
+	VADD   V17.S4, V16.S4, V16.S4; \
+	VEOR   g.B16, f.B16, V17.B16; \
+	VAND   e.B16, V17.B16, V17.B16; \
+	VEOR   g.B16, V17.B16, V17.B16; \
+	VADD   V17.S4, V16.S4, V16.S4; \
+	VADD   V16.S4, d.S4, d.S4; \
+	ROTR(a, 2, 30, V17, V18); \
+	ROTR(a, 13, 19, V18, V19); \
+	VEOR   V18.B16, V17.B16, V17.B16; \
+	ROTR(a, 22, 10, V18, V19); \
+	VEOR   V18.B16, V17.B16, V17.B16; \
+	VORR   b.B16, a.B16, V18.B16; \
+	VAND   c.B16, V18.B16, V18.B16; \
+	VAND   b.B16, a.B16, V19.B16; \
+	VORR   V19.B16, V18.B16, V18.B16; \
+	VADD   V18.S4, V17.S4, V17.S4; \
+	VADD   V17.S4, V16.S4, h.S4
+
+// func block4NEON(state, words, k, w *uint32)
+TEXT ·block4NEON(SB), NOSPLIT, $0-32
+	MOVD state+0(FP), R0
+	MOVD words+8(FP), R1
+	MOVD k+16(FP), R2
+	MOVD w+24(FP), R3
+
+	MOVD R3, R4
+	MOVD $16, R5
+
+copy:
+	VLD1.P 16(R1), [V16.S4]
+	VST1.P [V16.S4], 16(R4)
+	SUB    $1, R5
+	CBNZ   R5, copy
+
+	// W[t] = s1(W[t-2]) + W[t-7] + s0(W[t-15]) + W[t-16], with R6 at W[t-16]
+	// and R4 at W[t]
+	MOVD R3, R6
+	MOVD $48, R5
+
+schedule:
+	ADD  $16, R6, R7
+	VLD1 (R7), [V17.S4]
+	ROTR(V17, 7, 25, V18, V19)
+	ROTR(V17, 18, 14, V19, V20)
+	VEOR  V19.B16, V18.B16, V18.B16
+	VUSHR $3, V17.S4, V19.S4
+	VEOR  V19.B16, V18.B16, V18.B16
+	ADD   $224, R6, R7
+	VLD1  (R7), [V17.S4]
+	ROTR(V17, 17, 15, V19, V20)
+	ROTR(V17, 19, 13, V20, V21)
+	VEOR   V20.B16, V19.B16, V19.B16
+	VUSHR  $10, V17.S4, V20.S4
+	VEOR   V20.B16, V19.B16, V19.B16
+	VADD   V19.S4, V18.S4, V18.S4
+	VLD1   (R6), [V17.S4]
+	VADD   V17.S4, V18.S4, V18.S4
+	ADD    $144, R6, R7
+	VLD1   (R7), [V17.S4]
+	VADD   V17.S4, V18.S4, V18.S4
+	VST1.P [V18.S4], 16(R4)
+	ADD    $16, R6
+	SUB    $1, R5
+	CBNZ   R5, schedule
+
+	MOVD R0, R8
+	VLD1.P 64(R8), [V0.S4, V1.S4, V2.S4, V3.S4]
+	VLD1   (R8), [V4.S4, V5.S4, V6.S4, V7.S4]
+
+	// Eight rounds per loop, after which the registers are back in place
+	MOVD $8, R5
+
+rounds:
+	ROUND(V0, V1, V2, V3, V4, V5, V6, V7)
+	ROUND(V7, V0, V1, V2, V3, V4, V5, V6)
+	ROUND(V6, V7, V0, V1, V2, V3, V4, V5)
+	ROUND(V5, V6, V7, V0, V1, V2, V3, V4)
+	ROUND(V4, V5, V6, V7, V0, V1, V2, V3)
+	ROUND(V3, V4, V5, V6, V7, V0, V1, V2)
+	ROUND(V2, V3, V4, V5, V6, V7, V0, V1)
+	ROUND(V1, V2, V3, V4, V5, V6, V7, V0)
+	SUB  $1, R5
+	CBNZ R5, rounds
+
+	MOVD   R0, R8
+	VLD1.P 64(R8), [V16.S4, V17.S4, V18.S4, V19.S4]
+	VADD   V16.S4, V0.S4, V0.S4
+	VADD   V17.S4, V1.S4, V1.S4
+	VADD   V18.S4, V2.S4, V2.S4
+	VADD   V19.S4, V3.S4, V3.S4
+	VLD1   (R8), [V16.S4, V17.S4, V18.S4, V19.S4]
+	VADD   V16.S4, V4.S4, V4.S4
+	VADD   V17.S4, V5.S4, V5.S4
+	VADD   V18.S4, V6.S4, V6.S4
+	VADD   V19.S4, V7.S4, V7.S4
+
+	MOVD   R0, R8
+	VST1.P [V0.S4, V1.S4, V2.S4, V3.S4], 64(R8)
+	VST1   [V4.S4, V5.S4, V6.S4, V7.S4], (R8)
+	RET
@@ -0,0 +1,60 @@
+package hasher
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/sys/cpu"
+)
+
+// blockSHA2 runs the SHA-256 compression function with the ARMv8 SHA-256
+// instructions over every 64 byte block of p. k points to the round
+// constants
+//
+//go:noescape
+func blockSHA2(state *[8]uint32, p []byte, k *uint32)
+
+func init() {
+	register(backend{
+		name:      "sha2",
+		desc:      "ARMv8 SHA-256 instructions, one message at a time",
+		available: cpu.ARM64.HasSHA2,
+		new:       func() Hasher { return &sha2Hasher{} },
+	})
+}
+
+// sha2Hasher hashes the padded message after the prefix's complete blocks,
+// starting from the state the prefix left
+type sha2Hasher struct {
+	mid      [8]uint32
+	state    [8]uint32
+	tail     []byte
+	suffixAt int
+	suffixTo int
+}
+
+func (s *sha2Hasher) Name() string { return "sha2" }
+func (s *sha2Hasher) Lanes() int   { return 1 }
+
+func (s *sha2Hasher) SetPrefix(prefix []byte, suffixLen int) {
+	full := len(prefix) / 64 * 64
+	s.mid = iv
+	if full > 0 {
+		blockSHA2(&s.mid, prefix[:full], &k[0])
+	}
+
+	s.tail = tail(prefix[full:], suffixLen, len(prefix)+suffixLen)
+	s.suffixAt = len(prefix) - full
+	s.suffixTo = s.suffixAt + suffixLen
+}
+
+func (s *sha2Hasher) Suffix(lane int) []byte {
+	return s.tail[s.suffixAt:s.suffixTo]
+}
+
+func (s *sha2Hasher) Sum(sums [][Size]byte) {
+	s.state = s.mid
+	blockSHA2(&s.state, s.tail, &k[0])
+	for i, v := range s.state {
+		binary.BigEndian.PutUint32(sums[0][4*i:], v)
+	}
+}
@@ -0,0 +1,74 @@
+#include "textflag.h"
+
+// SHA-256 with the ARMv8 SHA-256 instructions. V0 holds state words a-d
+// and V1 e-h, V2/V3 the working copy of them for the block, V4-V7 the 16
+// most recent message words and V16-V31 the round constants. SHA256H and
+// SHA256H2 do four rounds with the message words plus constants in V9.
+
+// Four rounds, with the message words in w and the constants in kt
+#define ROUNDS(kt, w) \
+	VADD      kt.S4, w.S4, V9.S4; \
+	VMOV      V2.B16, V8.B16; \
+	SHA256H   V9.S4, V3, V2; \
+	SHA256H2  V9.S4, V8, V3
+
+// Four rounds that also extend the message schedule, w0 becomes the words
+// 16 after it
+#define SCHEDULE(kt, w0, w1, w2, w3) \
+	VADD      kt.S4, w0.S4, V9.S4; \
+	SHA256SU0 w1.S4, w0.S4; \
+	VMOV      V2.B16, V8.B16; \
+	SHA256H   V9.S4, V3, V2; \
+	SHA256H2  V9.S4, V8, V3; \
+	SHA256SU1 w3.S4, w2.S4, w0.S4
+
+// func blockSHA2(state *[8]uint32, p []byte, k *uint32)
+TEXT ·blockSHA2(SB), NOSPLIT, $0-40
+	MOVD state+0(FP), R0
+	MOVD p_base+8(FP), R1
+	MOVD p_len+16(FP), R3
+	MOVD k+32(FP), R2
+	LSR  $6, R3, R3
+	CBZ  R3, done
+
+	VLD1   (R0), [V0.S4, V1.S4]
+	VLD1.P 64(R2), [V16.S4, V17.S4, V18.S4, V19.S4]
+	VLD1.P 64(R2), [V20.S4, V21.S4, V22.S4, V23.S4]
+	VLD1.P 64(R2), [V24.S4, V25.S4, V26.S4, V27.S4]
+	VLD1   (R2), [V28.S4, V29.S4, V30.S4, V31.S4]
+
+loop:
+	VLD1.P 64(R1), [V4.B16, V5.B16, V6.B16, V7.B16]
+	VREV32 V4.B16, V4.B16
+	VREV32 V5.B16, V5.B16
+	VREV32 V6.B16, V6.B16
+	VREV32 V7.B16, V7.B16
+	VMOV   V0.B16, V2.B16
+	VMOV   V1.B16, V3.B16
+
+	SCHEDULE(V16, V4, V5, V6, V7)
+	SCHEDULE(V17, V5, V6, V7, V4)
+	SCHEDULE(V18, V6, V7, V4, V5)
+	SCHEDULE(V19, V7, V4, V5, V6)
+	SCHEDULE(V20, V4, V5, V6, V7)
+	SCHEDULE(V21, V5, V6, V7, V4)
+	SCHEDULE(V22, V6, V7, V4, V5)
+	SCHEDULE(V23, V7, V4, V5, V6)
+	SCHEDULE(V24, V4, V5, V6, V7)
+	SCHEDULE(V25, V5, V6, V7, V4)
+	SCHEDULE(V26, V6, V7, V4, V5)
+	SCHEDULE(V27, V7, V4, V5, V6)
+	ROUNDS(V28, V4)
+	ROUNDS(V29, V5)
+	ROUNDS(V30, V6)
+	ROUNDS(V31, V7)
+
+	VADD V2.S4, V0.S4, V0.S4
+	VADD V3.S4, V1.S4, V1.S4
+	SUB  $1, R3, R3
+	CBNZ R3, loop
+
+	VST1 [V0.S4, V1.S4], (R0)
+
+done:
+	RET
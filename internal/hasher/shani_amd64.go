@@ -0,0 +1,55 @@
+package hasher
+
+import "encoding/binary"
+
+// blockSHANI runs the SHA-256 compression function with the SHA extensions
+// over every 64 byte block of p. k points to the round constants
+//
+//go:noescape
+func blockSHANI(state *[8]uint32, p []byte, k *uint32)
+
+func init() {
+	register(backend{
+		name:      "sha-ni",
+		desc:      "Intel SHA extensions, one message at a time",
+		available: hasSHA,
+		new:       func() Hasher { return &shaniHasher{} },
+	})
+}
+
+// shaniHasher hashes the padded message after the prefix's complete
+// blocks, starting from the state the prefix left
+type shaniHasher struct {
+	mid      [8]uint32
+	state    [8]uint32
+	tail     []byte
+	suffixAt int
+	suffixTo int
+}
+
+func (s *shaniHasher) Name() string { return "sha-ni" }
+func (s *shaniHasher) Lanes() int   { return 1 }
+
+func (s *shaniHasher) SetPrefix(prefix []byte, suffixLen int) {
+	full := len(prefix) / 64 * 64
+	s.mid = iv
+	if full > 0 {
+		blockSHANI(&s.mid, prefix[:full], &k[0])
+	}
+
+	s.tail = tail(prefix[full:], suffixLen, len(prefix)+suffixLen)
+	s.suffixAt = len(prefix) - full
+	s.suffixTo = s.suffixAt + suffixLen
+}
+
+func (s *shaniHasher) Suffix(lane int) []byte {
+	return s.tail[s.suffixAt:s.suffixTo]
+}
+
+func (s *shaniHasher) Sum(sums [][Size]byte) {
+	s.state = s.mid
+	blockSHANI(&s.state, s.tail, &k[0])
+	for i, v := range s.state {
+		binary.BigEndian.PutUint32(sums[0][4*i:], v)
+	}
+}
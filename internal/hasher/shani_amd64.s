@@ -0,0 +1,247 @@
+#include "textflag.h"
+
+// SHA-256 with the Intel SHA extensions, following the sequence in Intel's
+// "New Instructions Supporting the Secure Hash Algorithm on Intel
+// Architecture Processors". X1 holds state words ABEF and X2 CDGH,
+// SHA256RNDS2 does two rounds with the message words plus constants in X0,
+// and X3-X6 hold the 16 most recent message words.
+
+// func blockSHANI(state *[8]uint32, p []byte, k *uint32)
+TEXT ·blockSHANI(SB), NOSPLIT, $0-40
+	MOVQ state+0(FP), DI
+	MOVQ p_base+8(FP), SI
+	MOVQ p_len+16(FP), DX
+	MOVQ k+32(FP), AX
+	SHRQ $6, DX
+	JZ   done
+
+	// PSHUFB mask that byte swaps every 32 bit word
+	MOVQ   $0x0405060700010203, BX
+	MOVQ   BX, X8
+	MOVQ   $0x0c0d0e0f08090a0b, BX
+	PINSRQ $1, BX, X8
+
+	MOVOU   (DI), X1
+	MOVOU   16(DI), X2
+	PSHUFD  $0xb1, X1, X1
+	PSHUFD  $0x1b, X2, X2
+	MOVO    X1, X7
+	PALIGNR $8, X2, X1
+	PBLENDW $0xf0, X7, X2
+
+loop:
+	MOVO X1, X10
+	MOVO X2, X11
+
+	// Rounds 0-3
+	MOVOU 0(SI), X3
+	PSHUFB X8, X3
+	MOVO X3, X0
+	MOVOU 0(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+
+	// Rounds 4-7
+	MOVOU 16(SI), X4
+	PSHUFB X8, X4
+	MOVO X4, X0
+	MOVOU 16(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+	SHA256MSG1 X4, X3
+
+	// Rounds 8-11
+	MOVOU 32(SI), X5
+	PSHUFB X8, X5
+	MOVO X5, X0
+	MOVOU 32(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+	SHA256MSG1 X5, X4
+
+	// Rounds 12-15
+	MOVOU 48(SI), X6
+	PSHUFB X8, X6
+	MOVO X6, X0
+	MOVOU 48(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	MOVO X6, X7
+	PALIGNR $4, X5, X7
+	PADDD X7, X3
+	SHA256MSG2 X6, X3
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+	SHA256MSG1 X6, X5
+
+	// Rounds 16-19
+	MOVO X3, X0
+	MOVOU 64(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	MOVO X3, X7
+	PALIGNR $4, X6, X7
+	PADDD X7, X4
+	SHA256MSG2 X3, X4
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+	SHA256MSG1 X3, X6
+
+	// Rounds 20-23
+	MOVO X4, X0
+	MOVOU 80(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	MOVO X4, X7
+	PALIGNR $4, X3, X7
+	PADDD X7, X5
+	SHA256MSG2 X4, X5
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+	SHA256MSG1 X4, X3
+
+	// Rounds 24-27
+	MOVO X5, X0
+	MOVOU 96(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	MOVO X5, X7
+	PALIGNR $4, X4, X7
+	PADDD X7, X6
+	SHA256MSG2 X5, X6
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+	SHA256MSG1 X5, X4
+
+	// Rounds 28-31
+	MOVO X6, X0
+	MOVOU 112(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	MOVO X6, X7
+	PALIGNR $4, X5, X7
+	PADDD X7, X3
+	SHA256MSG2 X6, X3
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+	SHA256MSG1 X6, X5
+
+	// Rounds 32-35
+	MOVO X3, X0
+	MOVOU 128(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	MOVO X3, X7
+	PALIGNR $4, X6, X7
+	PADDD X7, X4
+	SHA256MSG2 X3, X4
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+	SHA256MSG1 X3, X6
+
+	// Rounds 36-39
+	MOVO X4, X0
+	MOVOU 144(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	MOVO X4, X7
+	PALIGNR $4, X3, X7
+	PADDD X7, X5
+	SHA256MSG2 X4, X5
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+	SHA256MSG1 X4, X3
+
+	// Rounds 40-43
+	MOVO X5, X0
+	MOVOU 160(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	MOVO X5, X7
+	PALIGNR $4, X4, X7
+	PADDD X7, X6
+	SHA256MSG2 X5, X6
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+	SHA256MSG1 X5, X4
+
+	// Rounds 44-47
+	MOVO X6, X0
+	MOVOU 176(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	MOVO X6, X7
+	PALIGNR $4, X5, X7
+	PADDD X7, X3
+	SHA256MSG2 X6, X3
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+	SHA256MSG1 X6, X5
+
+	// Rounds 48-51
+	MOVO X3, X0
+	MOVOU 192(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	MOVO X3, X7
+	PALIGNR $4, X6, X7
+	PADDD X7, X4
+	SHA256MSG2 X3, X4
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+	SHA256MSG1 X3, X6
+
+	// Rounds 52-55
+	MOVO X4, X0
+	MOVOU 208(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	MOVO X4, X7
+	PALIGNR $4, X3, X7
+	PADDD X7, X5
+	SHA256MSG2 X4, X5
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+
+	// Rounds 56-59
+	MOVO X5, X0
+	MOVOU 224(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	MOVO X5, X7
+	PALIGNR $4, X4, X7
+	PADDD X7, X6
+	SHA256MSG2 X5, X6
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+
+	// Rounds 60-63
+	MOVO X6, X0
+	MOVOU 240(AX), X9
+	PADDD X9, X0
+	SHA256RNDS2 X0, X1, X2
+	PSHUFD $0x0e, X0, X0
+	SHA256RNDS2 X0, X2, X1
+	PADDD X10, X1
+	PADDD X11, X2
+
+	ADDQ $64, SI
+	DECQ DX
+	JNZ  loop
+
+	PSHUFD  $0x1b, X1, X1
+	PSHUFD  $0xb1, X2, X2
+	MOVO    X1, X7
+	PBLENDW $0xf0, X2, X1
+	PALIGNR $8, X7, X2
+	MOVOU   X1, (DI)
+	MOVOU   X2, 16(DI)
+
+done:
+	RET
@@ -0,0 +1,171 @@
+// Package logging is the leveled logger used by noso-go. Messages are
+// written as text or as one JSON object per line, and can be sent to a
+// size and age rotated file (see RotatingFile) as well as the console.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses "debug", "info", "warn" (or "warning") and "error"
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "warning" {
+		s = "warn"
+	}
+	for i, name := range levelNames {
+		if s == name {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", s)
+}
+
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// ParseFormat checks that s is FormatText or FormatJSON
+func ParseFormat(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case FormatText, FormatJSON:
+		return s, nil
+	}
+	return FormatText, fmt.Errorf("unknown log format %q, expected text or json", s)
+}
+
+// Logger writes leveled messages to one or more writers. It is safe for
+// concurrent use
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  Level
+	format string
+	now    func() time.Time
+}
+
+// New returns a logger that writes messages at level and above to out
+func New(out io.Writer, level Level, format string) *Logger {
+	return &Logger{
+		out:    out,
+		level:  level,
+		format: format,
+		now:    time.Now,
+	}
+}
+
+// Enabled reports whether messages at level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Format returns FormatText or FormatJSON
+func (l *Logger) Format() string {
+	return l.format
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) { l.logf(LevelDebug, format, args...) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.logf(LevelInfo, format, args...) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.logf(LevelWarn, format, args...) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.logf(LevelError, format, args...) }
+
+func (l *Logger) logf(level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.write(level, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) write(level Level, msg string) {
+	msg = strings.TrimRight(msg, "\n")
+	now := l.now()
+
+	var b bytes.Buffer
+	if l.format == FormatJSON {
+		json.NewEncoder(&b).Encode(struct {
+			Time  string `json:"time"`
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}{now.Format(time.RFC3339Nano), level.String(), msg})
+	} else {
+		fmt.Fprintf(&b, "%s %-5s %s\n", now.Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), msg)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(b.Bytes())
+}
+
+// Writer returns an io.Writer that logs every line written to it at level.
+// Use it to capture the standard library logger with log.SetOutput
+func (l *Logger) Writer(level Level) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		if l.Enabled(level) {
+			for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+				l.write(level, line)
+			}
+		}
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+var (
+	stdMu sync.RWMutex
+	std   = New(os.Stderr, LevelInfo, FormatText)
+)
+
+// SetDefault replaces the logger used by the package level functions
+func SetDefault(l *Logger) {
+	stdMu.Lock()
+	defer stdMu.Unlock()
+	std = l
+}
+
+// Default returns the logger used by the package level functions
+func Default() *Logger {
+	stdMu.RLock()
+	defer stdMu.RUnlock()
+	return std
+}
+
+func Debugf(format string, args ...interface{}) { Default().logf(LevelDebug, format, args...) }
+func Infof(format string, args ...interface{})  { Default().logf(LevelInfo, format, args...) }
+func Warnf(format string, args ...interface{})  { Default().logf(LevelWarn, format, args...) }
+func Errorf(format string, args ...interface{}) { Default().logf(LevelError, format, args...) }
+
+// Fatalf logs at error level and exits with status 1
+func Fatalf(format string, args ...interface{}) {
+	Default().logf(LevelError, format, args...)
+	os.Exit(1)
+}
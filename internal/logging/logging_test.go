@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fixedTime() time.Time {
+	return time.Date(2021, 11, 18, 14, 59, 52, 0, time.Local)
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Level
+		err  bool
+	}{
+		{"debug", LevelDebug, false},
+		{"INFO", LevelInfo, false},
+		{" warning ", LevelWarn, false},
+		{"warn", LevelWarn, false},
+		{"error", LevelError, false},
+		{"verbose", LevelInfo, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, LevelWarn, FormatText)
+	l.now = fixedTime
+
+	l.Debugf("-> PING %d", 1)
+	l.Infof("connected")
+	l.Warnf("retrying in %ds\n", 5)
+	l.Errorf("failed")
+
+	want := "2021/11/18 14:59:52 WARN  retrying in 5s\n" +
+		"2021/11/18 14:59:52 ERROR failed\n"
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, LevelDebug, FormatJSON)
+	l.now = fixedTime
+
+	l.Debugf("<- %s\n", "PONG")
+
+	var entry map[string]string
+	if err := json.Unmarshal(b.Bytes(), &entry); err != nil {
+		t.Fatalf("not JSON: %q: %v", b.String(), err)
+	}
+	if entry["level"] != "debug" || entry["msg"] != "<- PONG" || entry["time"] != fixedTime().Format(time.RFC3339Nano) {
+		t.Errorf("unexpected entry %v", entry)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, LevelInfo, FormatText)
+	l.now = fixedTime
+
+	std := log.New(l.Writer(LevelInfo), "", 0)
+	std.Print("one\ntwo")
+	log.New(l.Writer(LevelDebug), "", 0).Print("hidden")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "INFO  one") || !strings.HasSuffix(lines[1], "INFO  two") {
+		t.Errorf("unexpected output %q", b.String())
+	}
+}
@@ -0,0 +1,181 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is appended to the log file name when it is rotated
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is an io.WriteCloser that appends to Path and moves it
+// aside to "Path.<time>" once it grows past MaxSize bytes or was started
+// more than MaxAge ago. Only the newest MaxBackups rotated files no older
+// than Retention are kept. When the file was started is kept next to it
+// (see createdPath), so the age survives restarts
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64         // 0 disables size based rotation
+	MaxAge     time.Duration // 0 disables age based rotation
+	MaxBackups int           // 0 keeps every rotated file
+	Retention  time.Duration // 0 keeps rotated files forever
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	opened  time.Time
+	nowFunc func() time.Time
+}
+
+// Open opens (or creates) the log file. Writes open it automatically, but
+// calling Open first surfaces errors early
+func (r *RotatingFile) Open() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.open()
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.shouldRotate(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RotatingFile) now() time.Time {
+	if r.nowFunc != nil {
+		return r.nowFunc()
+	}
+	return time.Now()
+}
+
+func (r *RotatingFile) open() error {
+	if dir := filepath.Dir(r.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = info.Size()
+	r.opened = r.created(info)
+	return nil
+}
+
+// createdPath is the hidden file holding when the log file was started,
+// since the file system only keeps when it was last written
+func (r *RotatingFile) createdPath() string {
+	return filepath.Join(filepath.Dir(r.Path), "."+filepath.Base(r.Path)+".created")
+}
+
+// created returns when the log file described by info was started, and
+// records it for a new file. A file started before that was recorded is
+// taken to be as old as its last write
+func (r *RotatingFile) created(info os.FileInfo) time.Time {
+	if info.Size() > 0 {
+		if data, err := ioutil.ReadFile(r.createdPath()); err == nil {
+			if t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data))); err == nil {
+				return t
+			}
+		}
+	}
+
+	t := r.now()
+	if info.Size() > 0 {
+		t = info.ModTime()
+	}
+	// Failing to record it only makes the next run count the age from the
+	// last write, which isn't worth failing to log over
+	ioutil.WriteFile(r.createdPath(), []byte(t.Format(time.RFC3339Nano)+"\n"), 0644)
+	return t
+}
+
+func (r *RotatingFile) shouldRotate(n int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.MaxSize > 0 && r.size+n > r.MaxSize {
+		return true
+	}
+	return r.MaxAge > 0 && r.now().Sub(r.opened) > r.MaxAge
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	backup := r.Path + "." + r.now().Format(backupTimeFormat)
+	if err := os.Rename(r.Path, backup); err != nil {
+		return err
+	}
+
+	r.prune()
+	return r.open()
+}
+
+// prune removes the rotated files that exceed MaxBackups or Retention
+func (r *RotatingFile) prune() {
+	backups, _ := filepath.Glob(r.Path + ".*")
+
+	// The time stamp sorts in time order, newest first after reversing
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	now := r.now()
+	kept := 0
+	for _, name := range backups {
+		t, err := time.ParseInLocation(backupTimeFormat, strings.TrimPrefix(name, r.Path+"."), time.Local)
+		if err != nil {
+			// Not one of ours
+			continue
+		}
+
+		if (r.MaxBackups > 0 && kept >= r.MaxBackups) || (r.Retention > 0 && now.Sub(t) > r.Retention) {
+			os.Remove(name)
+			continue
+		}
+		kept++
+	}
+}
@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func backups(t *testing.T, path string) []string {
+	t.Helper()
+	names, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "noso-go-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2021, 11, 18, 0, 0, 0, 0, time.Local)
+	r := &RotatingFile{
+		Path:       filepath.Join(dir, "logs", "noso-go.log"),
+		MaxSize:    10,
+		MaxBackups: 2,
+		nowFunc:    func() time.Time { return now },
+	}
+	defer r.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := r.Write([]byte("12345678\n")); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Second)
+	}
+
+	got := backups(t, r.Path)
+	if len(got) != 2 {
+		t.Fatalf("got backups %v, want the newest 2", got)
+	}
+	if !strings.HasSuffix(got[1], now.Add(-time.Second).Format(backupTimeFormat)) {
+		t.Errorf("newest backup %s, want one from %s", got[1], now.Add(-time.Second))
+	}
+
+	data, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "12345678\n" {
+		t.Errorf("current log holds %q", data)
+	}
+}
+
+func TestRotateByAgeAndRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "noso-go-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2021, 11, 18, 0, 0, 0, 0, time.Local)
+	r := &RotatingFile{
+		Path:      filepath.Join(dir, "noso-go.log"),
+		MaxAge:    time.Hour,
+		Retention: 3 * time.Hour,
+		nowFunc:   func() time.Time { return now },
+	}
+	defer r.Close()
+
+	// Someone else's file must survive pruning
+	other := r.Path + ".old"
+	if err := ioutil.WriteFile(other, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 6; i++ {
+		if _, err := r.Write([]byte("line\n")); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(90 * time.Minute)
+	}
+
+	// Rotations happened at 1.5h, 3h, 4.5h, 6h and 7.5h. At the last one
+	// the backups from 4.5h on are within the retention
+	if got := backups(t, r.Path); len(got) != 4 {
+		t.Errorf("got backups %v, want 3 plus %s", got, other)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("pruned a file that isn't a backup: %v", err)
+	}
+}
+
+func TestRotateByAgeAcrossRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "noso-go-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2021, 11, 18, 0, 0, 0, 0, time.Local)
+	path := filepath.Join(dir, "noso-go.log")
+	write := func() {
+		t.Helper()
+		r := &RotatingFile{Path: path, MaxAge: time.Hour, nowFunc: func() time.Time { return now }}
+		defer r.Close()
+		if _, err := r.Write([]byte("line\n")); err != nil {
+			t.Fatal(err)
+		}
+		// Written just now, which says nothing about when it was started
+		if err := os.Chtimes(path, now, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write()
+	now = now.Add(40 * time.Minute)
+	write()
+	if got := backups(t, path); len(got) != 0 {
+		t.Fatalf("rotated a 40 minute old log: %v", got)
+	}
+
+	now = now.Add(40 * time.Minute)
+	write()
+	if got := backups(t, path); len(got) != 1 {
+		t.Errorf("got backups %v after restarting with an 80 minute old log, want 1", got)
+	}
+}
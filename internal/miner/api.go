@@ -0,0 +1,66 @@
+package miner
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
+)
+
+// StartAPI serves the miner status as JSON on addr (e.g. ":8080"):
+//
+//   GET /status  - MinerStatus
+//   GET /workers - []WorkerStatus
+//   GET /events  - []PoolEvent
+//
+// It returns once the listener is open so bad addresses are caught
+// before mining starts
+func StartAPI(addr string, stats *Stats) error {
+	return serveHTTP("Status API", addr, newAPIHandler(stats))
+}
+
+// serveHTTP opens a listener on addr and serves handler on it in the
+// background
+func serveHTTP(name, addr string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	logging.Infof("%s listening on http://%s\n", name, ln.Addr())
+
+	go func() {
+		if err := http.Serve(ln, handler); err != nil {
+			logging.Errorf("%s stopped: %v\n", name, err)
+		}
+	}()
+
+	return nil
+}
+
+func newAPIHandler(stats *Stats) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, stats.Status())
+	})
+	mux.HandleFunc("/workers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, stats.Workers())
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, stats.PoolEvents())
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Warnf("Error writing status API response: %v\n", err)
+	}
+}
@@ -0,0 +1,51 @@
+package miner
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIHandler(t *testing.T) {
+	stats := NewStats()
+	stats.Update(func(s *MinerStatus) {
+		s.Block = 1234
+		s.Wallet = "leviable"
+		s.StepsSent = 3
+	})
+	stats.AddReport(Report{WorkerNum: "10", Hashes: 2000, Duration: time.Second})
+	stats.AddReport(Report{WorkerNum: "2", Hashes: 1000, Duration: time.Second})
+
+	handler := newAPIHandler(stats)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status code %d want %d", rec.Code, http.StatusOK)
+	}
+	var status MinerStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Block != 1234 || status.Wallet != "leviable" || status.StepsSent != 3 || status.HashRate != 3000 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/workers", nil))
+	var workers []WorkerStatus
+	if err := json.NewDecoder(rec.Body).Decode(&workers); err != nil {
+		t.Fatal(err)
+	}
+	if len(workers) != 2 || workers[0].Worker != "2" || workers[1].HashRate != 2000 {
+		t.Errorf("unexpected workers: %+v", workers)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/status", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status code %d want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,98 @@
+package miner
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
+)
+
+// Rules a pool may credit accepted steps by
+const (
+	// ShareRuleFlat expects the same shares for every accepted step
+	ShareRuleFlat = "flat"
+
+	// ShareRuleLength expects the shares to grow with the target length
+	// of the step, see ExpectedShares
+	ShareRuleLength = "length"
+)
+
+// ShareRules are the rules ShareRule supports
+var ShareRules = []string{ShareRuleFlat, ShareRuleLength}
+
+// DefaultStepShares is what a pool credits every accepted step under
+// ShareRuleFlat, and what the mock pool credits by default
+const DefaultStepShares = 1
+
+// ShareRule is how the pool is expected to credit accepted steps. The
+// protocol only says a STEPOK carries the shares credited, so the rule
+// depends on the pool. The zero value is ShareRuleFlat with
+// DefaultStepShares
+type ShareRule struct {
+	Name string
+
+	// Shares is credited for every step under ShareRuleFlat
+	Shares int
+}
+
+// Validate checks r names a known rule
+func (r ShareRule) Validate() error {
+	switch r.Name {
+	case ShareRuleFlat, ShareRuleLength, "":
+	default:
+		return fmt.Errorf("unknown share rule %q, use %s", r.Name, strings.Join(ShareRules, " or "))
+	}
+	if r.Shares < 0 {
+		return fmt.Errorf("shares per step can't be negative")
+	}
+	return nil
+}
+
+// expected returns the shares sol should earn
+func (r ShareRule) expected(sol Solution) int {
+	if r.Name == ShareRuleLength {
+		return ExpectedShares(sol.TargetLen, sol.Diff, sol.PoolDepth)
+	}
+	if r.Shares == 0 {
+		return DefaultStepShares
+	}
+	return r.Shares
+}
+
+// ExpectedShares returns the shares a step of targetLen earns on a pool
+// with diff and depth under ShareRuleLength: one for a step of the
+// shortest target the pool accepts, and 16 times as many for every
+// character more, since such a step is 16 times rarer
+func ExpectedShares(targetLen, diff, depth int) int {
+	extra := targetLen - minTargetLen(diff, depth)
+	if extra < 0 || extra > 8 {
+		return 0
+	}
+	return int(math.Pow(16, float64(extra)))
+}
+
+// stepAnswered records the pool's answer to the oldest step sent: a
+// STEPOK crediting shares when accepted is true, a STEPFAIL otherwise.
+// The answer is checked against the step it is for
+func stepAnswered(queue *stepQueue, stats *Stats, rule ShareRule, accepted bool, shares int) {
+	sol, ok := queue.answered()
+	if !accepted {
+		stats.FailStep()
+		if ok {
+			logging.Debugf("Pool rejected the step for block %d with seed %s, hashed string %s and target length %d\n", sol.Block, sol.Seed, sol.HashStr, sol.TargetLen)
+		}
+		return
+	}
+
+	stats.AcceptStep(shares)
+	if !ok {
+		logging.Debugf("Pool credited %d share(s) for a step that was not waiting for an answer\n", shares)
+		return
+	}
+	expected := rule.expected(sol)
+	if shares != expected {
+		logging.Debugf("Pool credited %d share(s) for the step for block %d with target length %d, %d expected\n", shares, sol.Block, sol.TargetLen, expected)
+	}
+	stats.AuditStep(expected, shares)
+}
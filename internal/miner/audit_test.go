@@ -0,0 +1,132 @@
+package miner
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExpectedShares(t *testing.T) {
+	tests := []struct {
+		targetLen, diff, depth, want int
+	}{
+		{3, 55, 3, 1},
+		{4, 55, 3, 16},
+		{5, 55, 3, 256},
+		{2, 55, 3, 0},
+		{4, 40, 1, 1},
+		{5, 40, 1, 16},
+		{8, 89, 1, 1},
+		{1, 20, 5, 1},
+		{2, 20, 5, 16},
+	}
+	for _, tt := range tests {
+		if got := ExpectedShares(tt.targetLen, tt.diff, tt.depth); got != tt.want {
+			t.Errorf("ExpectedShares(%d, %d, %d) = %d want %d", tt.targetLen, tt.diff, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestShareRule(t *testing.T) {
+	sol := Solution{TargetLen: 5, Diff: 55, PoolDepth: 3}
+	tests := []struct {
+		rule ShareRule
+		want int
+	}{
+		{ShareRule{}, DefaultStepShares},
+		{ShareRule{Name: ShareRuleFlat, Shares: 3}, 3},
+		{ShareRule{Name: ShareRuleLength, Shares: 3}, 256},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.Validate(); err != nil {
+			t.Errorf("%+v: %v", tt.rule, err)
+		}
+		if got := tt.rule.expected(sol); got != tt.want {
+			t.Errorf("%+v expects %d share(s) want %d", tt.rule, got, tt.want)
+		}
+	}
+
+	if err := (ShareRule{Name: "difficulty"}).Validate(); err == nil {
+		t.Error("no error for an unknown rule")
+	}
+}
+
+func TestStepAnswered(t *testing.T) {
+	db := tempStatsDB(t)
+	stats := NewStats()
+	blocks := newBlockRecorder(db, stats)
+	queue := newStepQueue(make(chan string, 10), stats)
+	state := &PoolState{PoolAddr: "pool", MinerSeed: "1abc!!!", Block: 100, Session: 1}
+
+	sol := func(targetLen int) Solution {
+		return Solution{PoolAddr: "pool", Seed: "1abc000", Block: 100, TargetLen: targetLen, Diff: 55, PoolDepth: 3}
+	}
+
+	blocks.next(100, "pool", "wallet")
+	queue.send(sol(3), state)
+	queue.send(sol(4), state)
+	queue.send(sol(3), state)
+	queue.send(sol(5), state)
+
+	rule := ShareRule{Name: ShareRuleLength}
+	stepAnswered(queue, stats, rule, true, 1)  // as expected
+	stepAnswered(queue, stats, rule, true, 1)  // 16 expected
+	stepAnswered(queue, stats, rule, false, 0) // rejected
+	stepAnswered(queue, stats, rule, true, 256)
+	// An answer for a step that was never sent isn't audited
+	stepAnswered(queue, stats, rule, true, 7)
+	blocks.finish()
+
+	status := stats.Status()
+	if status.SharesEarned != 265 || status.SharesExpected != 273 || status.ShareDiscrepancies != 1 || status.StepsFailed != 1 {
+		t.Errorf("got shares %d, expected %d, discrepancies %d, failed %d",
+			status.SharesEarned, status.SharesExpected, status.ShareDiscrepancies, status.StepsFailed)
+	}
+
+	records, err := db.Records(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Session != instanceId || records[0].SharesExpected != 273 || records[0].ShareDiscrepancies != 1 {
+		t.Errorf("unexpected records: %+v", records)
+	}
+}
+
+func TestAuditAgainstMockPool(t *testing.T) {
+	inTempDir(t)
+	pool, opts := miningPool(t)
+	opts.StatsFile = DefaultStatsFile
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Mine(ctx, opts) }()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for pool.Stats().StepsAccepted < 20 {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d steps accepted", pool.Stats().StepsAccepted)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Mine returned %v", err)
+	}
+
+	db, err := OpenStatsDB(DefaultStatsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := db.Records(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	totals, err := SumBlockRecords(records, GroupTotal)
+	if err != nil || len(totals) != 1 {
+		t.Fatalf("got %v, %v", totals, err)
+	}
+	total := totals[0]
+	if total.SharesExpected < 20 || total.SharesEarned != total.SharesExpected || total.ShareDiscrepancies != 0 {
+		t.Errorf("got %d share(s) for %d expected, %d discrepancies", total.SharesEarned, total.SharesExpected, total.ShareDiscrepancies)
+	}
+}
@@ -0,0 +1,153 @@
+package miner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/hasher"
+)
+
+const (
+	// Synthetic pool values used when benchmarking. The target is long
+	// enough that solutions are rare, just like on a real pool
+	benchPoolAddr     = "N4ZR3fKhTUod34evnEcDQX3i6XufBDU"
+	benchMinerSeed    = "1bd1a0ee9!!!"
+	benchTargetString = "5afadec0006675e408e5c06aa09c0120"
+	benchTargetChars  = 10
+	benchDiff         = 99
+	benchPoolDepth    = 3
+)
+
+type BenchmarkResult struct {
+	Threads  int           `json:"threads"`
+	Hashes   int           `json:"hashes"`
+	Duration time.Duration `json:"duration_ns"`
+	HashRate int           `json:"hash_rate"`
+}
+
+type BenchmarkReport struct {
+	Version     string            `json:"version"`
+	OS          string            `json:"os"`
+	Arch        string            `json:"arch"`
+	NumCPU      int               `json:"num_cpu"`
+	Hasher      string            `json:"hasher"`
+	StepTime    time.Duration     `json:"step_duration_ns"`
+	Results     []BenchmarkResult `json:"results"`
+	Recommended int               `json:"recommended_cpu"`
+}
+
+// Benchmark runs the mining hash loop against a synthetic job once for every
+// thread count from 1 to maxThreads, hashing for at least stepTime at each
+// step, with the named hasher backend. If progress is not nil it is called
+// after each step completes.
+func Benchmark(maxThreads int, stepTime time.Duration, hasherName string, progress func(BenchmarkResult)) (BenchmarkReport, error) {
+	h, err := hasher.New(hasherName)
+	if err != nil {
+		return BenchmarkReport{}, err
+	}
+
+	report := BenchmarkReport{
+		Version:  Version,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		NumCPU:   runtime.NumCPU(),
+		Hasher:   h.Name(),
+		StepTime: stepTime,
+		Results:  make([]BenchmarkResult, 0, maxThreads),
+	}
+
+	best := 0
+	for threads := 1; threads <= maxThreads; threads++ {
+		result := benchmarkThreads(threads, stepTime, h.Name())
+		report.Results = append(report.Results, result)
+		if result.HashRate > best {
+			best = result.HashRate
+			report.Recommended = threads
+		}
+		if progress != nil {
+			progress(result)
+		}
+	}
+
+	return report, nil
+}
+
+func benchmarkThreads(threads int, stepTime time.Duration, hasherName string) BenchmarkResult {
+	var (
+		wg     sync.WaitGroup
+		m      sync.Mutex
+		hashes int
+	)
+
+	start := time.Now()
+	for x := 0; x < threads; x++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			// The name was checked by Benchmark
+			h, _ := hasher.New(hasherName)
+			count := 0
+			// Always hash at least one full job
+			for num := 1; num == 1 || time.Since(start) < stepTime; num++ {
+				count += hashJob(context.Background(), newBenchmarkJob(worker, num), h, nil)
+			}
+			m.Lock()
+			hashes += count
+			m.Unlock()
+		}(x)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	return BenchmarkResult{
+		Threads:  threads,
+		Hashes:   hashes,
+		Duration: elapsed,
+		HashRate: int(float64(hashes) / elapsed.Seconds()),
+	}
+}
+
+// newBenchmarkJob builds a Job the same way JobFeeder does, giving each
+// worker its own seed so no two workers hash the same strings
+func newBenchmarkJob(worker, num int) Job {
+	seed := benchMinerSeed[:len(benchMinerSeed)-3] + string(hashableSeedChars[worker%len(hashableSeedChars)]) + "!!"
+	postfix := fmt.Sprintf("00%03d", num%1000)
+	fullSeed := seed + benchPoolAddr + postfix
+
+	return Job{
+		PoolAddr:      benchPoolAddr,
+		SeedMiner:     seed,
+		SeedPostfix:   postfix,
+		SeedFull:      fullSeed,
+		SeedFullBytes: []byte(fullSeed),
+		TargetString:  benchTargetString,
+		TargetChars:   benchTargetChars,
+		Diff:          benchDiff,
+		Block:         1,
+		Step:          0,
+		PoolDepth:     benchPoolDepth,
+	}
+}
+
+func (b *BenchmarkReport) PrettyPrint() {
+	fmt.Printf("\nBenchmark results (%s/%s, %d CPUs, %s hasher, %s per step)\n\n", b.OS, b.Arch, b.NumCPU, b.Hasher, b.StepTime)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Threads\tHash Rate\tPer Thread\t")
+	for _, r := range b.Results {
+		fmt.Fprintf(w, "%d\t%s\t%s\t\n",
+			r.Threads,
+			formatHashRate(strconv.Itoa(r.HashRate)),
+			formatHashRate(strconv.Itoa(r.HashRate/r.Threads)),
+		)
+	}
+	w.Flush()
+
+	fmt.Printf("\nRecommended setting: --cpu %d\n", b.Recommended)
+}
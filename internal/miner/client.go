@@ -2,11 +2,14 @@ package miner
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
-	"log"
 	"net"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
 )
 
 const (
@@ -15,19 +18,48 @@ const (
 	reconnectSleep    = 5 * time.Second
 )
 
-func NewTcpClient(opts *Opts, comms *Comms, showLogs, join bool) *TcpClient {
+// Errors of a client that stopped because Opts.ExitOnRetry is set
+var (
+	ErrConnectionLost = errors.New("connection to the pool lost")
+	ErrAuthFailed     = errors.New("the pool rejected the password")
+)
+
+// A pool that rejects the password is retried after authRetryMin, which
+// doubles with every rejection up to authRetryMax
+const (
+	authRetryMin = 30 * time.Second
+	authRetryMax = 30 * time.Minute
+)
+
+func NewTcpClient(opts *Opts, comms *Comms, stats *Stats, showLogs, join bool) *TcpClient {
+	pools := opts.Pools
+	if len(pools) == 0 {
+		pools = []PoolEndpoint{{Address: opts.IpAddr, Port: opts.IpPort, Password: opts.PoolPw}}
+	}
+
+	failoverAfter := opts.FailoverAfter
+	if failoverAfter < 1 {
+		failoverAfter = DefaultFailoverAfter
+	}
+
 	client := &TcpClient{
-		minerVer:    MinerName,
-		comms:       comms,
-		opts:        opts,
-		addr:        fmt.Sprintf("%s:%d", opts.IpAddr, opts.IpPort),
-		SendChan:    make(chan string, 100),
-		RecvChan:    make(chan string, 100),
-		connected:   make(chan interface{}, 0),
-		mutex:       &sync.Mutex{},
-		showLogs:    showLogs,
-		join:        join,
-		exitOnRetry: opts.ExitOnRetry,
+		minerVer:         MinerName,
+		comms:            comms,
+		stats:            stats,
+		opts:             opts,
+		pools:            pools,
+		addr:             pools[0].String(),
+		SendChan:         make(chan string, 100),
+		RecvChan:         make(chan string, 100),
+		connected:        make(chan interface{}, 0),
+		closed:           make(chan struct{}, 0),
+		done:             make(chan struct{}, 0),
+		mutex:            &sync.Mutex{},
+		showLogs:         showLogs,
+		join:             join,
+		exitOnRetry:      opts.ExitOnRetry,
+		failoverAfter:    failoverAfter,
+		failbackInterval: opts.FailbackInterval,
 	}
 
 	go client.manager()
@@ -38,9 +70,12 @@ func NewTcpClient(opts *Opts, comms *Comms, showLogs, join bool) *TcpClient {
 type TcpClient struct {
 	minerVer    string
 	comms       *Comms
+	stats       *Stats
 	opts        *Opts
-	addr        string // "poolIP:poolPort"
-	auth        string // "poolPw wallet"
+	pools       []PoolEndpoint // in order of preference
+	active      int            // index of the pool in use
+	addr        string         // "poolIP:poolPort"
+	auth        string         // "poolPw wallet"
 	SendChan    chan string
 	RecvChan    chan string
 	conn        net.Conn
@@ -49,12 +84,25 @@ type TcpClient struct {
 	showLogs    bool
 	join        bool
 	exitOnRetry bool
+
+	// Failover state, only touched by the manager goroutine
+	failures         int
+	authFailures     int // PASSFAILED answers in a row, from any pool
+	failoverAfter    int
+	failbackInterval time.Duration
+
+	// Set when the client stops for good, guarded by mutex
+	closing bool
+	closed  chan struct{}
+	err     error
+	done    chan struct{}
 }
 
 type managerComms struct {
 	connected    chan struct{}
 	disconnected chan struct{}
 	joined       chan struct{}
+	cause        string // why disconnected was closed, guarded by TcpClient.mutex
 }
 
 func NewManagerComms() *managerComms {
@@ -68,30 +116,65 @@ func NewManagerComms() *managerComms {
 func (t *TcpClient) SetAuth() {
 	t.opts.CurrentWallet = t.opts.Wallets[0]
 	t.opts.Wallets = append(t.opts.Wallets[1:], t.opts.CurrentWallet)
-	t.auth = fmt.Sprintf("%s %s", t.opts.PoolPw, t.opts.CurrentWallet)
+	t.mutex.Lock()
+	t.auth = fmt.Sprintf("%s %s", t.pools[t.active].Password, t.opts.CurrentWallet)
+	t.mutex.Unlock()
+	t.stats.Update(func(s *MinerStatus) { s.Wallet = t.opts.CurrentWallet })
 
-	log.Printf("Using wallet address: %s\n", t.opts.CurrentWallet)
+	logging.Infof("Using wallet address: %s\n", t.opts.CurrentWallet)
+}
+
+// poolAddress returns the address of the pool in use
+func (t *TcpClient) poolAddress() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.pools[t.active].Address
 }
 
 // Manages the TCP connection and send/recv/ping goroutines
 func (t *TcpClient) manager() {
+	connectedBefore := false
 	for {
 		manComms := NewManagerComms()
-		t.comms.Disconnected = manComms.disconnected
+		t.mutex.Lock()
+		closing := t.closing
+		t.mutex.Unlock()
+
+		if closing {
+			t.stop(nil)
+			return
+		}
+
+		t.stats.Update(func(s *MinerStatus) {
+			s.Pool = t.addr
+			s.Connection = ConnConnecting
+		})
+
+		// A JOINOK parsed after the last connection ended isn't for this one
+		select {
+		case <-t.comms.Joined:
+		default:
+		}
 
-		conn, err := net.DialTimeout("tcp", t.addr, dialTimeout)
+		joined := false
+		conn, err := dialPool(t.pools[t.active], dialTimeout)
 		if err != nil {
-			log.Printf("Error connecting to pool: %v\n", err)
-			if t.exitOnRetry {
-				t.close(manComms.disconnected)
-			}
+			logging.Errorf("Error connecting to pool: %v\n", err)
+			t.stats.SetConnection(ConnDisconnected)
 		} else {
+			if connectedBefore {
+				t.stats.Update(func(s *MinerStatus) { s.Reconnects++ })
+			}
+			connectedBefore = true
+			t.stats.SetConnection(ConnConnected)
 			conn.SetReadDeadline(time.Now().Add(connectionTimeout))
 
 			go t.send(conn, manComms)
 			go t.recv(conn, manComms)
 			go t.ping(manComms)
 			go t.watchDog(manComms)
+			go t.probeFailback(manComms, t.active)
 
 		manager:
 			for {
@@ -99,46 +182,197 @@ func (t *TcpClient) manager() {
 				case <-manComms.disconnected:
 					break manager
 				case <-t.comms.Joined:
+					joined = true
+					t.failures = 0
+					t.authFailures = 0
+					t.stats.SetConnection(ConnJoined)
 					t.close(manComms.joined)
 				}
 			}
 
 			conn.Close()
+			t.stats.SetConnection(ConnDisconnected)
+
+			// Jobs and solutions stop until the next JOINOK, which may be
+			// from another pool
+			t.comms.State.Reset()
 		}
 
-		if t.join && !t.exitOnRetry {
+		t.mutex.Lock()
+		cause := manComms.cause
+		closing = t.closing
+		t.mutex.Unlock()
+
+		if closing {
+			t.stop(nil)
+			return
+		}
+
+		authFailed := cause == causeAuthFailed
+		if authFailed {
+			t.authFailures++
+			t.stats.Update(func(s *MinerStatus) {
+				s.Connection = ConnAuthFailed
+				s.AuthFailures++
+			})
+		}
+
+		if t.exitOnRetry {
+			if authFailed {
+				t.stop(ErrAuthFailed)
+			} else {
+				t.stop(ErrConnectionLost)
+			}
+			return
+		}
+
+		if next, reason, ok := t.nextPool(joined, cause); ok {
+			t.switchPool(next, reason)
+			// Try every pool once before backing off
+			if !authFailed || t.authFailures < len(t.pools) {
+				continue
+			}
+		}
+
+		if authFailed {
+			wait := authRetryDelay(t.authFailures - len(t.pools) + 1)
+			logging.Errorf("The pool rejected the password, will retry connection in %s\n", wait)
+			t.sleep(wait)
+		} else if t.join {
 			// Wait 5 seconds between connection attempts
-			log.Printf("Disconnected from pool, will retry connection in %d seconds\n", reconnectSleep/time.Second)
-			time.Sleep(reconnectSleep)
+			logging.Warnf("Disconnected from pool, will retry connection in %d seconds\n", reconnectSleep/time.Second)
+			t.sleep(reconnectSleep)
 		}
 	}
 }
 
+// Done returns a channel that is closed once the client has stopped
+// connecting to the pool, see Err
+func (t *TcpClient) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns why the client stopped: nil after Close, or
+// ErrConnectionLost
+func (t *TcpClient) Err() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.err
+}
+
+// Close sends the messages waiting in SendChan and closes the
+// connection, giving up after timeout. The client doesn't reconnect
+// afterwards
+func (t *TcpClient) Close(timeout time.Duration) {
+	t.mutex.Lock()
+	t.closing = true
+	t.mutex.Unlock()
+	t.close(t.closed)
+
+	select {
+	case <-t.done:
+	case <-time.After(timeout):
+		logging.Warnf("Timed out closing the pool connection\n")
+	}
+}
+
+// sleep waits for d, or until the client is closed
+func (t *TcpClient) sleep(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-t.closed:
+	}
+}
+
+// authRetryDelay is how long to wait after the nth rejected password in a
+// row
+func authRetryDelay(n int) time.Duration {
+	d := authRetryMin
+	for i := 1; i < n && d < authRetryMax; i++ {
+		d *= 2
+	}
+	if d > authRetryMax {
+		d = authRetryMax
+	}
+	return d
+}
+
+// stop records why the client stopped and closes done
+func (t *TcpClient) stop(err error) {
+	t.mutex.Lock()
+	t.err = err
+	t.mutex.Unlock()
+
+	t.close(t.done)
+}
+
 func (t *TcpClient) send(conn net.Conn, manComms *managerComms) {
 	if t.join {
-		go func() { t.SendChan <- fmt.Sprintf("JOIN %s %s", t.minerVer, instanceId) }()
+		t.dropSteps()
+		go func() { t.SendChan <- JoinCmd{Version: t.minerVer, InstanceId: instanceId}.String() }()
 	}
 
 send:
 	for {
 		select {
 		case msg := <-t.SendChan:
-			if t.auth == "" || msg[:4] == "JOIN" {
-				t.SetAuth()
+			t.write(conn, msg)
+		case <-t.closed:
+			// Send what is left, then hang up
+			for len(t.SendChan) > 0 {
+				t.write(conn, <-t.SendChan)
 			}
+			t.disconnect(manComms, causeClosed)
+			break send
+		case <-manComms.disconnected:
+			break send
+		}
+	}
+}
 
-			if t.showLogs {
-				log.Printf("-> %s\n", msg)
+// dropSteps drops the steps still waiting in SendChan from the last
+// connection. The pool would answer them before the JOIN, and their
+// answers would be taken for those of the steps sent after it. Steps the
+// pool didn't answer are sent again by the step queue once rejoined
+func (t *TcpClient) dropSteps() {
+	keep := []string{}
+drain:
+	for n := len(t.SendChan); n > 0; n-- {
+		select {
+		case msg := <-t.SendChan:
+			if !strings.HasPrefix(msg, "STEP ") {
+				keep = append(keep, msg)
 			}
+		default:
+			break drain
+		}
+	}
 
-			msg = fmt.Sprintf("%s %s\n", t.auth, msg)
-			fmt.Fprintf(conn, msg)
-		case <-manComms.disconnected:
-			break send
+	// Nothing reads SendChan until this connection's send loop starts
+	for _, msg := range keep {
+		select {
+		case t.SendChan <- msg:
+		default:
+			go func(msg string) { t.SendChan <- msg }(msg)
 		}
 	}
 }
 
+// write sends msg to the pool, prefixed with the password and wallet
+func (t *TcpClient) write(conn net.Conn, msg string) {
+	if t.auth == "" || msg[:4] == "JOIN" {
+		t.SetAuth()
+	}
+
+	if t.showLogs {
+		logging.Debugf("-> %s\n", msg)
+	}
+
+	msg = fmt.Sprintf("%s %s\n", t.auth, msg)
+	fmt.Fprintf(conn, msg)
+}
+
 func (t *TcpClient) recv(conn net.Conn, manComms *managerComms) {
 	scanner := bufio.NewScanner(conn)
 recv:
@@ -148,10 +382,15 @@ recv:
 			break recv
 		default:
 			if ok := scanner.Scan(); !ok {
-				if t.showLogs {
-					log.Println("Error in connection: ", scanner.Err())
+				select {
+				case <-manComms.disconnected:
+					// Closed on purpose, e.g. by Close or the watchdog
+				default:
+					if t.showLogs {
+						logging.Warnf("Error in connection: %v", scanner.Err())
+					}
+					t.close(manComms.disconnected)
 				}
-				t.close(manComms.disconnected)
 				break
 			}
 			resp := scanner.Text()
@@ -159,9 +398,16 @@ recv:
 				continue
 			}
 			if t.showLogs {
-				log.Print("<- " + resp + "\n")
+				logging.Debugf("<- %s", resp)
 			}
 			t.RecvChan <- resp
+
+			// Retrying with the same password won't help, so don't wait
+			// for the pool to hang up
+			if strings.HasPrefix(resp, PASSFAILED) {
+				t.disconnect(manComms, causeAuthFailed)
+				break recv
+			}
 			// Since we got something, reset the deadline
 			conn.SetReadDeadline(time.Now().Add(connectionTimeout))
 		}
@@ -204,7 +450,7 @@ ping:
 			m.RLock()
 			hr := hashRate
 			m.RUnlock()
-			t.SendChan <- fmt.Sprintf("PING %d %s", hr/1000, instanceId)
+			t.SendChan <- PingCmd{HashRate: hr / 1000, InstanceId: instanceId}.String()
 		}
 	}
 }
@@ -219,13 +465,27 @@ watchdog:
 		case <-manComms.disconnected:
 			break watchdog
 		case <-time.After(connectionTimeout):
-			log.Printf("###################\nWatchdog Triggered\n###################\n")
-			t.close(manComms.disconnected)
+			logging.Warnf("###################\nWatchdog Triggered\n###################\n")
+			t.stats.Update(func(s *MinerStatus) { s.WatchdogTriggers++ })
+			t.disconnect(manComms, causeWatchdog)
 			break watchdog
 		}
 	}
 }
 
+// disconnect closes the connection described by manComms, recording why
+func (t *TcpClient) disconnect(manComms *managerComms, cause string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	select {
+	case <-manComms.disconnected:
+	default:
+		manComms.cause = cause
+		close(manComms.disconnected)
+	}
+}
+
 func (t *TcpClient) close(c chan struct{}) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
@@ -0,0 +1,94 @@
+package miner
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/hasher"
+	"github.com/Noso-Project/noso-go/internal/mockpool"
+)
+
+func recvLine(t *testing.T, client *TcpClient, code string) string {
+	t.Helper()
+	select {
+	case resp := <-client.RecvChan:
+		if !strings.HasPrefix(resp, code+" ") && resp != code {
+			t.Fatalf("expected %s, got %q", code, resp)
+		}
+		return resp
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for %s", code)
+	}
+	return ""
+}
+
+func TestClientAgainstMockPool(t *testing.T) {
+	cfg := mockpool.DefaultConfig()
+	cfg.TargetChars = 4
+	cfg.Diff = 30
+	cfg.PoolDepth = 1
+
+	pool := mockpool.New(cfg)
+	if err := pool.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	host, port, err := splitHostPort(pool.Addr(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &Opts{IpAddr: host, IpPort: port, PoolPw: "pw", Wallets: []string{"wallet"}}
+
+	comms := NewComms()
+	stats := NewStats()
+	client := NewTcpClient(opts, comms, stats, false, true)
+
+	resp := recvLine(t, client, JOINOK)
+	Parse(comms, host, resp, nil)
+	go JobFeeder(comms)
+
+	h, err := hasher.New(hasher.Auto)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sol *Solution
+	for sol == nil {
+		hashJob(context.Background(), <-comms.Jobs, h, func(s Solution) {
+			if sol == nil {
+				sol = &s
+			}
+		})
+	}
+
+	state, _ := comms.State.Current()
+	if state.PoolAddr != cfg.PoolAddr || state.Block != sol.Block || state.Diff != cfg.Diff {
+		t.Fatalf("unexpected pool state: %+v", state)
+	}
+
+	go SolutionManager(comms, newStepQueue(client.SendChan, stats), newSolutionChecker(stats, 0), stats, false)
+
+	submit := func(code string) {
+		comms.Solutions <- *sol
+		go Parse(comms, host, recvLine(t, client, code), nil)
+	}
+
+	submit(STEPOK)
+	if shares := <-comms.StepSolved; shares != cfg.Shares {
+		t.Errorf("got %d shares want %d", shares, cfg.Shares)
+	}
+
+	pool.FailNextSteps(1)
+	submit(STEPFAIL)
+	<-comms.StepFailed
+
+	if sent := stats.Status().StepsSent; sent != 2 {
+		t.Errorf("got %d steps sent want 2", sent)
+	}
+	if stats := pool.Stats(); stats.Joins != 1 || stats.StepsAccepted != 1 || stats.StepsFailed != 1 {
+		t.Errorf("unexpected pool stats: %+v", stats)
+	}
+}
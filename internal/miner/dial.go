@@ -0,0 +1,348 @@
+package miner
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DialOpts controls how the connection to a pool is made
+type DialOpts struct {
+	// Proxy is an optional socks5://[user:pass@]host:port or
+	// http://[user:pass@]host:port (CONNECT) proxy to dial the pool through
+	Proxy string
+	// TLS wraps the pool connection in TLS
+	TLS bool
+	// TLSCA is a PEM file with the CA certificate(s) to trust instead of
+	// the system roots, for pools with a self-signed certificate
+	TLSCA string
+	// TLSSkipVerify accepts any certificate the pool presents
+	TLSSkipVerify bool
+}
+
+// Merge returns d with any setting it leaves empty taken from other
+func (d DialOpts) Merge(other DialOpts) DialOpts {
+	if d.Proxy == "" {
+		d.Proxy = other.Proxy
+	}
+	if d.TLSCA == "" {
+		d.TLSCA = other.TLSCA
+	}
+	d.TLS = d.TLS || other.TLS
+	d.TLSSkipVerify = d.TLSSkipVerify || other.TLSSkipVerify
+	return d
+}
+
+// Direct is true when the pool is dialed without a proxy or TLS, which is
+// the only case where its host name may be replaced by an IP address
+func (d DialOpts) Direct() bool {
+	return d.Proxy == "" && !d.TLS
+}
+
+// Summary describes the settings, e.g. "TLS, via socks5 proxy host:1080"
+func (d DialOpts) Summary() string {
+	notes := []string{}
+	if d.TLS {
+		notes = append(notes, "TLS")
+	}
+	if d.Proxy != "" {
+		if u, err := parseProxyURL(d.Proxy); err == nil {
+			notes = append(notes, "via "+u.Scheme+" proxy "+u.Host)
+		}
+	}
+	return strings.Join(notes, ", ")
+}
+
+// Validate checks the proxy URL. The CA file is only read when the pool
+// is dialed
+func (d DialOpts) Validate() error {
+	if d.Proxy == "" {
+		return nil
+	}
+	_, err := parseProxyURL(d.Proxy)
+	return err
+}
+
+// dialPool connects to pool, through its proxy and TLS if configured
+func dialPool(pool PoolEndpoint, timeout time.Duration) (net.Conn, error) {
+	addr := pool.String()
+	deadline := time.Now().Add(timeout)
+
+	var (
+		conn net.Conn
+		err  error
+	)
+
+	if pool.Dial.Proxy == "" {
+		conn, err = net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		conn, err = dialProxy(pool.Dial.Proxy, addr, deadline)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !pool.Dial.TLS {
+		return conn, nil
+	}
+
+	cfg := &tls.Config{
+		ServerName:         pool.Address,
+		InsecureSkipVerify: pool.Dial.TLSSkipVerify,
+	}
+	if pool.Dial.TLSCA != "" {
+		if cfg.RootCAs, err = loadCAPool(pool.Dial.TLSCA); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	tlsConn := tls.Client(conn, cfg)
+	tlsConn.SetDeadline(deadline)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake with %s failed: %v", addr, err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	return tlsConn, nil
+}
+
+func loadCAPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read TLS CA file: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in TLS CA file %s", path)
+	}
+	return pool, nil
+}
+
+func parseProxyURL(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %v", proxy, err)
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: scheme must be socks5 or http", proxy)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("invalid proxy %q: expected %s://host:port", proxy, u.Scheme)
+	}
+
+	return u, nil
+}
+
+// dialProxy connects to addr through the proxy at proxyURL
+func dialProxy(proxyURL, addr string, deadline time.Time) (net.Conn, error) {
+	u, err := parseProxyURL(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", u.Host, time.Until(deadline))
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to proxy: %v", err)
+	}
+	conn.SetDeadline(deadline)
+
+	tunnel := conn
+	if u.Scheme == "http" {
+		tunnel, err = httpConnect(conn, u, addr)
+	} else {
+		err = socks5Connect(conn, u, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tunnel.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// httpConnect asks an HTTP proxy to open a tunnel to addr
+func httpConnect(conn net.Conn, u *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		pw, _ := u.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + pw))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("proxy CONNECT failed: %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("proxy CONNECT failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy CONNECT failed: %s", resp.Status)
+	}
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn keeps bytes the proxy sent right after its CONNECT reply
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+var socks5Errors = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect performs the RFC 1928 handshake asking the proxy to connect
+// to addr. Host names are resolved by the proxy
+func socks5Connect(conn net.Conn, u *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	methods := []byte{0x00}
+	if u.User != nil {
+		methods = append(methods, 0x02)
+	}
+	if _, err := conn.Write(append([]byte{5, byte(len(methods))}, methods...)); err != nil {
+		return fmt.Errorf("socks5 handshake failed: %v", err)
+	}
+
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("socks5 handshake failed: %v", err)
+	}
+	if resp[0] != 5 {
+		return errors.New("socks5 handshake failed: not a SOCKS5 proxy")
+	}
+
+	switch resp[1] {
+	case 0x00:
+	case 0x02:
+		// RFC 1929 username/password authentication
+		user := u.User.Username()
+		pw, _ := u.User.Password()
+		if len(user) > 255 || len(pw) > 255 {
+			return errors.New("socks5 username or password too long")
+		}
+		auth := []byte{1, byte(len(user))}
+		auth = append(auth, user...)
+		auth = append(auth, byte(len(pw)))
+		auth = append(auth, pw...)
+		if _, err := conn.Write(auth); err != nil {
+			return fmt.Errorf("socks5 authentication failed: %v", err)
+		}
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return fmt.Errorf("socks5 authentication failed: %v", err)
+		}
+		if resp[1] != 0 {
+			return errors.New("socks5 authentication failed: bad username or password")
+		}
+	default:
+		return errors.New("socks5 handshake failed: no acceptable authentication method")
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(req, 1)
+		req = append(req, ip.To4()...)
+	} else if ip != nil {
+		req = append(req, 4)
+		req = append(req, ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return errors.New("socks5 host name too long")
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, 0, 0)
+	binary.BigEndian.PutUint16(req[len(req)-2:], uint16(port))
+
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("socks5 connect failed: %v", err)
+	}
+
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("socks5 connect failed: %v", err)
+	}
+	if reply[1] != 0 {
+		msg, ok := socks5Errors[reply[1]]
+		if !ok {
+			msg = fmt.Sprintf("error %d", reply[1])
+		}
+		return fmt.Errorf("socks5 connect to %s failed: %s", addr, msg)
+	}
+
+	// Skip the bound address, it isn't needed
+	var skip int
+	switch reply[3] {
+	case 1:
+		skip = net.IPv4len + 2
+	case 4:
+		skip = net.IPv6len + 2
+	case 3:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return fmt.Errorf("socks5 connect failed: %v", err)
+		}
+		skip = int(l[0]) + 2
+	default:
+		return errors.New("socks5 connect failed: bad address type in reply")
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip)); err != nil {
+		return fmt.Errorf("socks5 connect failed: %v", err)
+	}
+
+	return nil
+}
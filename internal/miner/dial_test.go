@@ -0,0 +1,237 @@
+package miner
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/mockpool"
+)
+
+func startMockPool(t *testing.T, cfg mockpool.Config) (*mockpool.Server, PoolEndpoint) {
+	pool := mockpool.New(cfg)
+	if err := pool.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	host, port, err := splitHostPort(pool.Addr(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool, PoolEndpoint{Address: host, Port: port, Password: "pw"}
+}
+
+// joinPool dials pool and checks that it answers a JOIN
+func joinPool(t *testing.T, pool PoolEndpoint) {
+	t.Helper()
+
+	conn, err := dialPool(pool, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "pw wallet JOIN test abc\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp, JOINOK+" ") {
+		t.Fatalf("expected JOINOK, got %q", resp)
+	}
+}
+
+// startProxy runs handshake on every accepted connection, which returns the
+// address to relay the connection to
+func startProxy(t *testing.T, handshake func(net.Conn) (string, error)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				target, err := handshake(conn)
+				if err != nil {
+					return
+				}
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestDialSocks5(t *testing.T) {
+	_, pool := startMockPool(t, mockpool.DefaultConfig())
+
+	proxy := startProxy(t, func(c net.Conn) (string, error) {
+		buf := make([]byte, 512)
+		// Greeting: offer of no auth and username/password
+		if _, err := io.ReadFull(c, buf[:4]); err != nil || buf[1] != 2 || buf[3] != 2 {
+			return "", fmt.Errorf("bad greeting %v", buf[:4])
+		}
+		c.Write([]byte{5, 2})
+
+		// Username/password
+		io.ReadFull(c, buf[:2])
+		user := make([]byte, buf[1])
+		io.ReadFull(c, user)
+		io.ReadFull(c, buf[:1])
+		pw := make([]byte, buf[0])
+		io.ReadFull(c, pw)
+		if string(user) != "miner" || string(pw) != "secret" {
+			c.Write([]byte{1, 1})
+			return "", fmt.Errorf("bad credentials")
+		}
+		c.Write([]byte{1, 0})
+
+		// CONNECT to an IPv4 address
+		io.ReadFull(c, buf[:4])
+		if buf[1] != 1 || buf[3] != 1 {
+			return "", fmt.Errorf("bad request %v", buf[:4])
+		}
+		io.ReadFull(c, buf[:6])
+		target := net.JoinHostPort(net.IP(buf[:4]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(buf[4:6]))))
+		c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+		return target, nil
+	})
+
+	pool.Dial.Proxy = "socks5://miner:secret@" + proxy
+	joinPool(t, pool)
+
+	pool.Dial.Proxy = "socks5://miner:wrong@" + proxy
+	if _, err := dialPool(pool, 5*time.Second); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+}
+
+func TestDialHTTPConnect(t *testing.T) {
+	_, pool := startMockPool(t, mockpool.DefaultConfig())
+
+	proxy := startProxy(t, func(c net.Conn) (string, error) {
+		req, err := http.ReadRequest(bufio.NewReader(c))
+		if err != nil {
+			return "", err
+		}
+		if req.Method != http.MethodConnect || req.Header.Get("Proxy-Authorization") != "Basic bWluZXI6c2VjcmV0" {
+			fmt.Fprintf(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return "", fmt.Errorf("bad request")
+		}
+		fmt.Fprintf(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+		return req.Host, nil
+	})
+
+	pool.Dial.Proxy = "http://miner:secret@" + proxy
+	joinPool(t, pool)
+
+	pool.Dial.Proxy = "http://" + proxy
+	if _, err := dialPool(pool, 5*time.Second); err == nil || !strings.Contains(err.Error(), "407") {
+		t.Errorf("expected a 407 error, got %v", err)
+	}
+}
+
+func TestDialTLS(t *testing.T) {
+	cert, caFile := selfSignedCert(t)
+
+	cfg := mockpool.DefaultConfig()
+	cfg.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	_, pool := startMockPool(t, cfg)
+
+	pool.Dial = DialOpts{TLS: true}
+	if _, err := dialPool(pool, 5*time.Second); err == nil {
+		t.Error("expected a self-signed certificate to be rejected")
+	}
+
+	pool.Dial = DialOpts{TLS: true, TLSCA: caFile}
+	joinPool(t, pool)
+
+	pool.Dial = DialOpts{TLS: true, TLSSkipVerify: true}
+	joinPool(t, pool)
+}
+
+func TestParseProxyURL(t *testing.T) {
+	for _, good := range []string{"socks5://127.0.0.1:1080", "socks5h://u:p@proxy:1080", "http://proxy.example.com:3128"} {
+		if _, err := parseProxyURL(good); err != nil {
+			t.Errorf("%s: %v", good, err)
+		}
+	}
+	for _, bad := range []string{"127.0.0.1:1080", "https://proxy:443", "socks5://proxy", "ftp://proxy:21"} {
+		if _, err := parseProxyURL(bad); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+// selfSignedCert returns a certificate for 127.0.0.1 and the path of a PEM
+// file holding it
+func selfSignedCert(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "noso-go test pool"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "noso-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	caFile := filepath.Join(dir, "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(caFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
@@ -0,0 +1,170 @@
+package miner
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
+)
+
+const (
+	// DefaultFailoverAfter is how many failed connection attempts or
+	// watchdog triggers in a row make the client move to the next pool
+	DefaultFailoverAfter = 3
+	// DefaultFailbackInterval is how often the primary pool is probed
+	// while mining on a fallback pool
+	DefaultFailbackInterval = 10 * time.Minute
+
+	maxPoolEvents = 50
+)
+
+// PoolEndpoint is a single pool the client can connect to
+type PoolEndpoint struct {
+	Address  string   `json:"address"`
+	Port     int      `json:"port"`
+	Password string   `json:"-"`
+	Dial     DialOpts `json:"-"`
+}
+
+func (p PoolEndpoint) String() string {
+	return net.JoinHostPort(p.Address, strconv.Itoa(p.Port))
+}
+
+// Describe returns the pool address followed by how it is dialed
+func (p PoolEndpoint) Describe() string {
+	if d := p.Dial.Summary(); d != "" {
+		return p.String() + " (" + d + ")"
+	}
+	return p.String()
+}
+
+// PoolEvent records a change of the active pool
+type PoolEvent struct {
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+}
+
+// ParsePoolList parses a comma separated list of "host[:port]" pools, in
+// order of preference. Pools without a port use defaultPort, and every
+// pool uses password and dial
+func ParsePoolList(list string, defaultPort int, password string, dial DialOpts) ([]PoolEndpoint, error) {
+	if err := dial.Validate(); err != nil {
+		return nil, err
+	}
+
+	pools := []PoolEndpoint{}
+	for _, addr := range strings.Split(list, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+
+		host, port, err := splitHostPort(addr, defaultPort)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pool address %q: %v", addr, err)
+		}
+		pools = append(pools, PoolEndpoint{Address: host, Port: port, Password: password, Dial: dial})
+	}
+
+	if len(pools) == 0 {
+		return nil, fmt.Errorf("no pool address given")
+	}
+
+	return pools, nil
+}
+
+// Reasons the connection to a pool was closed
+const (
+	causeWatchdog   = "watchdog"
+	causeFailback   = "failback"
+	causeClosed     = "closed"
+	causeAuthFailed = "auth failed"
+)
+
+// nextPool decides which pool to connect to after a connection ended
+// with cause. It returns false if the client should stay on the current
+// pool
+func (t *TcpClient) nextPool(joined bool, cause string) (int, string, bool) {
+	if cause == causeFailback {
+		return 0, "primary pool is reachable again", true
+	}
+
+	if cause == causeAuthFailed {
+		if len(t.pools) < 2 {
+			return 0, "", false
+		}
+		return (t.active + 1) % len(t.pools), "pool rejected the password", true
+	}
+
+	if !joined || cause == causeWatchdog {
+		t.failures++
+	}
+
+	if len(t.pools) < 2 || t.failures < t.failoverAfter {
+		return 0, "", false
+	}
+
+	return (t.active + 1) % len(t.pools), fmt.Sprintf("%d failed connection attempts", t.failures), true
+}
+
+// switchPool makes pools[next] the active pool and tells everyone about it
+func (t *TcpClient) switchPool(next int, reason string) {
+	t.mutex.Lock()
+	from := t.pools[t.active]
+	t.active = next
+	to := t.pools[next]
+	t.addr = to.String()
+	t.auth = ""
+	t.mutex.Unlock()
+
+	t.failures = 0
+
+	logging.Warnf("Switching pool from %s to %s: %s\n", from, to, reason)
+	t.stats.AddPoolEvent(PoolEvent{
+		Time:   time.Now(),
+		From:   from.String(),
+		To:     to.String(),
+		Reason: reason,
+	})
+
+	// Mine resets its jobs and solutions when it sees this. Other users of
+	// the client don't read it, so never block on it
+	select {
+	case t.comms.PoolChanged <- to:
+	default:
+	}
+}
+
+// probeFailback periodically checks whether the primary pool accepts
+// connections again while the client is connected to a fallback pool.
+// If it does, the current connection is closed so the manager can switch
+// back to it. active is the index of the pool in use, passed in since
+// the manager changes t.active once the connection is closed
+func (t *TcpClient) probeFailback(manComms *managerComms, active int) {
+	if active == 0 || t.failbackInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(t.failbackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-manComms.disconnected:
+			return
+		case <-ticker.C:
+			conn, err := dialPool(t.pools[0], dialTimeout)
+			if err != nil {
+				continue
+			}
+			conn.Close()
+			t.disconnect(manComms, causeFailback)
+			return
+		}
+	}
+}
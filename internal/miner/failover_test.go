@@ -0,0 +1,130 @@
+package miner
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/mockpool"
+)
+
+func TestParsePoolList(t *testing.T) {
+	pools, err := ParsePoolList("pool.noso.dev, 10.0.0.1:9000,[::1]:8083", 8082, "pw", DialOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PoolEndpoint{
+		{Address: "pool.noso.dev", Port: 8082, Password: "pw"},
+		{Address: "10.0.0.1", Port: 9000, Password: "pw"},
+		{Address: "::1", Port: 8083, Password: "pw"},
+	}
+	if !reflect.DeepEqual(pools, want) {
+		t.Errorf("got %+v want %+v", pools, want)
+	}
+
+	for _, bad := range []string{"", " , ", "host:port", "host:0"} {
+		if _, err := ParsePoolList(bad, 8082, "pw", DialOpts{}); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestClientFailover(t *testing.T) {
+	// Reserve an address for the primary pool, which starts out down
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	primaryAddr := ln.Addr().String()
+	ln.Close()
+
+	fallback := mockpool.New(mockpool.DefaultConfig())
+	if err := fallback.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer fallback.Close()
+
+	pools, err := ParsePoolList(primaryAddr+","+fallback.Addr(), 0, "pw", DialOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &Opts{
+		Wallets:          []string{"wallet"},
+		Pools:            pools,
+		FailoverAfter:    1,
+		FailbackInterval: 100 * time.Millisecond,
+	}
+
+	comms := NewComms()
+	stats := NewStats()
+	client := NewTcpClient(opts, comms, stats, false, true)
+
+	recvLine(t, client, JOINOK)
+	if got := (<-comms.PoolChanged).String(); got != fallback.Addr() {
+		t.Fatalf("switched to %s want %s", got, fallback.Addr())
+	}
+
+	primary := mockpool.New(mockpool.DefaultConfig())
+	if err := primary.Start(primaryAddr); err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	recvLine(t, client, JOINOK)
+	if got := (<-comms.PoolChanged).String(); got != primaryAddr {
+		t.Fatalf("failed back to %s want %s", got, primaryAddr)
+	}
+	if joins := primary.Stats().Joins; joins != 1 {
+		t.Errorf("got %d joins on the primary pool want 1", joins)
+	}
+
+	events := stats.PoolEvents()
+	if len(events) != 2 || events[0].To != fallback.Addr() || events[1].To != primaryAddr {
+		t.Errorf("unexpected pool events: %+v", events)
+	}
+	// The failed dial to the primary pool isn't a reconnect
+	if status := stats.Status(); status.PoolSwitches != 2 || status.Reconnects != 1 || status.Pool != primaryAddr {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestAuthFailover(t *testing.T) {
+	cfg := mockpool.DefaultConfig()
+	cfg.PassFailed = true
+	rejecting, first := startMockPool(t, cfg)
+	_, second := startMockPool(t, mockpool.DefaultConfig())
+
+	opts := &Opts{Wallets: []string{"wallet"}, Pools: []PoolEndpoint{first, second}, FailoverAfter: 3}
+	comms := NewComms()
+	stats := NewStats()
+	client := NewTcpClient(opts, comms, stats, false, true)
+	defer client.Close(time.Second)
+
+	recvLine(t, client, PASSFAILED)
+	if got := (<-comms.PoolChanged).String(); got != second.String() {
+		t.Fatalf("switched to %s want %s", got, second)
+	}
+	recvLine(t, client, JOINOK)
+
+	if joins := rejecting.Stats().PassFailed; joins != 1 {
+		t.Errorf("rejecting pool got %d joins want 1", joins)
+	}
+	if status := stats.Status(); status.AuthFailures != 1 || status.PoolSwitches != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestAuthRetryDelay(t *testing.T) {
+	for n, want := range map[int]time.Duration{
+		1:   authRetryMin,
+		2:   2 * authRetryMin,
+		4:   8 * authRetryMin,
+		7:   authRetryMax,
+		100: authRetryMax,
+	} {
+		if got := authRetryDelay(n); got != want {
+			t.Errorf("authRetryDelay(%d) = %s want %s", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package miner
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// targetMatcher finds a hex target string in a raw SHA-256 digest without
+// hex encoding it. The target is kept as one nibble per byte, and the
+// first nibbles are packed the way they appear in the digest when the
+// target starts on a byte boundary (even) or in the middle of a byte (odd),
+// so candidate positions can be found with a byte search
+type targetMatcher struct {
+	nibbles []byte
+	min     int
+	valid   bool
+
+	even0 byte // nibbles 0 and 1 packed into a byte
+	odd0  byte // nibbles 1 and 2 packed into a byte
+	first byte // nibble 0
+}
+
+// newTargetMatcher matches target[:min] and reports how many characters
+// of target, up to its full length, were matched. Targets with characters
+// that aren't hex digits never match
+func newTargetMatcher(target string, min int) *targetMatcher {
+	m := &targetMatcher{
+		nibbles: make([]byte, len(target)),
+		min:     min,
+		valid:   min > 0 && min <= len(target),
+	}
+
+	for i := 0; i < len(target); i++ {
+		n, ok := hexNibble(target[i])
+		if !ok {
+			if i < min {
+				m.valid = false
+			}
+			// Nothing past an invalid character can match
+			m.nibbles = m.nibbles[:i]
+			break
+		}
+		m.nibbles[i] = n
+	}
+
+	if m.valid && min >= 3 {
+		m.first = m.nibbles[0]
+		m.even0 = m.nibbles[0]<<4 | m.nibbles[1]
+		m.odd0 = m.nibbles[1]<<4 | m.nibbles[2]
+	}
+
+	return m
+}
+
+func hexNibble(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// match returns the length of the longest prefix of the target found in
+// the hex encoding of sum, or 0 if not even the minimum length is found
+func (m *targetMatcher) match(sum *[sha256.Size]byte) int {
+	if !m.valid {
+		return 0
+	}
+
+	best := 0
+	if m.min < 3 {
+		// Too short for the packed compare, try every position
+		for p := 0; p < 2*sha256.Size; p++ {
+			if l := m.extend(sum, p); l > best {
+				best = l
+			}
+		}
+		return best
+	}
+
+	// IndexByte is vectorized, so let it find the candidate positions
+	for i := 0; i < sha256.Size; i++ {
+		j := bytes.IndexByte(sum[i:], m.even0)
+		if j < 0 {
+			break
+		}
+		i += j
+		if l := m.extend(sum, 2*i); l > best {
+			best = l
+		}
+	}
+	for i := 1; i < sha256.Size; i++ {
+		j := bytes.IndexByte(sum[i:], m.odd0)
+		if j < 0 {
+			break
+		}
+		i += j
+		if sum[i-1]&0x0f != m.first {
+			continue
+		}
+		if l := m.extend(sum, 2*i-1); l > best {
+			best = l
+		}
+	}
+	return best
+}
+
+// extend counts the target nibbles found starting at nibble p of sum
+func (m *targetMatcher) extend(sum *[sha256.Size]byte, p int) int {
+	n := 0
+	for ; n < len(m.nibbles) && p+n < 2*sha256.Size; n++ {
+		q := p + n
+		nib := sum[q/2]
+		if q%2 == 0 {
+			nib >>= 4
+		} else {
+			nib &= 0x0f
+		}
+		if nib != m.nibbles[n] {
+			break
+		}
+	}
+
+	if n < m.min {
+		return 0
+	}
+	return n
+}
@@ -1,11 +1,12 @@
 package miner
 
 import (
-	"log"
 	"math/rand"
 	"time"
 
 	"github.com/denisbrodbeck/machineid"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
 )
 
 var deviceId = getMachineId()
@@ -14,7 +15,7 @@ var instanceId = getInstanceId()
 func getMachineId() string {
 	id, err := machineid.ProtectedID("noso-go")
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatalf("%v", err)
 	}
 
 	return id[:6]
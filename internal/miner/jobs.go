@@ -5,7 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"math/rand"
-	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,31 +19,8 @@ const (
 	hashableSeedChars = "!\"#$&')*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^`abcdefghijklmnopqrstuvwxyz{|"
 )
 
-func NewJobComms() *JobComms {
-	return &JobComms{
-		PoolAddr:     make(chan string, 0),
-		MinerSeed:    make(chan string, 0),
-		Block:        make(chan int, 0),
-		Step:         make(chan int, 0),
-		Diff:         make(chan int, 0),
-		TargetChars:  make(chan int, 0),
-		TargetString: make(chan string, 0),
-		PoolDepth:    make(chan int, 0),
-	}
-}
-
-type JobComms struct {
-	PoolAddr     chan string
-	MinerSeed    chan string
-	Block        chan int
-	Step         chan int
-	Diff         chan int
-	TargetChars  chan int
-	TargetString chan string
-	PoolDepth    chan int
-}
-
 type Job struct {
+	Generation    uint64
 	PoolAddr      string
 	SeedMiner     string
 	SeedPostfix   string
@@ -55,134 +32,136 @@ type Job struct {
 	Block         int
 	Step          int
 	PoolDepth     int
+
+	generations *JobGeneration
 }
 
-func JobFeeder(comms *Comms, jobComms *JobComms) {
-	var (
-		poolAddr     string
-		minerSeed    string
-		block        int
-		step         int
-		diff         int
-		targetChars  int
-		targetString string
-		job          Job
-		postfix      string
-		poolDepth    int
-	)
+func newJob(state *PoolState, gen *JobGeneration, seed, postfix, fullSeed string) Job {
+	return Job{
+		Generation:    gen.Current(),
+		generations:   gen,
+		PoolAddr:      state.PoolAddr,
+		SeedMiner:     seed,
+		SeedPostfix:   postfix,
+		SeedFull:      fullSeed,
+		SeedFullBytes: []byte(fullSeed),
+		TargetString:  state.TargetString,
+		TargetChars:   state.TargetChars,
+		Diff:          state.Diff,
+		Block:         state.Block,
+		Step:          state.Step,
+		PoolDepth:     state.PoolDepth,
+	}
+}
+
+// stale reports whether the job was overtaken by a newer generation. It is
+// cheap enough to call while hashing
+func (j *Job) stale() bool {
+	return j.generations != nil && j.generations.Current() != j.Generation
+}
 
+// staleFor returns how long a job started at start has been stale
+func (j *Job) staleFor(start time.Time) time.Duration {
+	if !j.stale() {
+		return 0
+	}
+	since := j.generations.changedAt()
+	if since.Before(start) {
+		since = start
+	}
+	return time.Since(since)
+}
+
+// JobGeneration numbers the jobs handed to the miners. It moves on
+// whenever the pool state changes in a way that makes the jobs handed out
+// so far useless, such as a new block or step, so the miners drop them
+// right away instead of hashing them to the end
+type JobGeneration struct {
+	n       uint64 // accessed atomically
+	changed int64  // when n last moved on, in Unix nanoseconds
+}
+
+// Current returns the generation of the jobs being handed out
+func (g *JobGeneration) Current() uint64 {
+	return atomic.LoadUint64(&g.n)
+}
+
+// next makes every job handed out so far stale
+func (g *JobGeneration) next() {
+	atomic.StoreInt64(&g.changed, time.Now().UnixNano())
+	atomic.AddUint64(&g.n, 1)
+}
+
+func (g *JobGeneration) changedAt() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&g.changed))
+}
+
+// sameWork reports whether jobs built from s and o hash the same thing
+func sameWork(s, o *PoolState) bool {
+	return s.PoolAddr == o.PoolAddr && s.Block == o.Block && s.Step == o.Step &&
+		s.TargetString == o.TargetString && s.TargetChars == o.TargetChars &&
+		s.Diff == o.Diff && s.PoolDepth == o.PoolDepth
+}
+
+// JobFeeder sends jobs to the miners on comms.Jobs whenever the pool
+// state is complete. Every job is built from a single PoolState, so it
+// never mixes the block of one update with the target of another
+func JobFeeder(comms *Comms) {
 	verSha := sha256.Sum256([]byte(MinerName))
 	verShaHex := hex.EncodeToString(verSha[:])
 	ver := verShaHex[:2]
 
-waitready:
 	for {
-		// When this channel is closed, it indicates a disconnected state
-		disconnected := comms.Disconnected
-
-		// Step is the only int that can actually be 0
-		step = -1
-
-		ready := make(chan struct{}, 0)
-
-		// This is pretty ugly, there has to be a better way
-		go func() {
-			for {
-				select {
-				case poolAddr = <-jobComms.PoolAddr:
-				case minerSeed = <-jobComms.MinerSeed:
-				case targetChars = <-jobComms.TargetChars:
-				case targetString = <-jobComms.TargetString:
-				case diff = <-jobComms.Diff:
-				case block = <-jobComms.Block:
-				case step = <-jobComms.Step:
-				case poolDepth = <-jobComms.PoolDepth:
-				}
+		state, changed := comms.State.Current()
+		if !state.Ready() {
+			<-changed
+			continue
+		}
+		feedSession(comms, ver, state, changed)
+	}
+}
 
-				if poolAddr == "" {
-					continue
-				} else if minerSeed == "" {
-					continue
-				} else if block == 0 {
-					continue
-				} else if step == -1 {
-					continue
-				} else if diff == 0 {
-					continue
-				} else if targetChars == 0 {
-					continue
-				} else if targetString == "" {
-					continue
-				} else if poolDepth == 0 {
-					continue
-				} else if minerSeed == "" {
-					continue
-				} else {
-					close(ready)
-					return
-				}
-			}
-		}()
-
-		<-ready
-
-		// Randomize seed chars so that if a miner restarts in the middle of a block,
-		// it isn't rehashing already hashed values
-		seedChars := []rune(hashableSeedChars)
-		rand.Seed(time.Now().UnixNano())
-		rand.Shuffle(len(seedChars), func(i, j int) { seedChars[i], seedChars[j] = seedChars[j], seedChars[i] })
-
-		for {
-			for _, x := range seedChars {
-				for _, y := range seedChars {
-					for _, z := range seedChars {
-
-						seedBase := minerSeed[:len(minerSeed)-3]
-						seedX := string(x)
-						seedY := string(y)
-						seedZ := string(z)
-						seed := seedBase + seedX + seedY + seedZ
-
-					loop:
-						for num := 1; num < 999; num++ {
-							postfix = ver + fmt.Sprintf("%03d", num)
-							fullSeed := seed + poolAddr + postfix
-							fullSeedBytes := []byte(fullSeed)
-							for {
-								job = Job{
-									TargetString:  strings.ToLower(targetString),
-									TargetChars:   targetChars,
-									PoolDepth:     poolDepth,
-									Diff:          diff,
-									Block:         block,
-									SeedMiner:     seed,
-									SeedPostfix:   postfix,
-									SeedFull:      fullSeed,
-									SeedFullBytes: fullSeedBytes,
-									PoolAddr:      poolAddr,
-									Step:          step,
+// feedSession sends jobs for the pool session state belongs to, picking
+// up new blocks and targets as they are published. It returns once the
+// session has ended, e.g. because the connection was lost. Either way,
+// the jobs already handed out are made stale
+func feedSession(comms *Comms, ver string, state *PoolState, changed <-chan struct{}) {
+	defer comms.Generation.next()
+
+	// Randomize seed chars so that if a miner restarts in the middle of a block,
+	// it isn't rehashing already hashed values
+	seedChars := []rune(hashableSeedChars)
+	slotChars := seedCharsForSlot(state.SeedSlot)
+	rand.Seed(time.Now().UnixNano())
+	rand.Shuffle(len(seedChars), func(i, j int) { seedChars[i], seedChars[j] = seedChars[j], seedChars[i] })
+	rand.Shuffle(len(slotChars), func(i, j int) { slotChars[i], slotChars[j] = slotChars[j], slotChars[i] })
+
+	seedBase := state.MinerSeed[:len(state.MinerSeed)-3]
+
+	for {
+		for _, x := range slotChars {
+			for _, y := range seedChars {
+				for _, z := range seedChars {
+					seed := seedBase + string(x) + string(y) + string(z)
+
+					for num := 1; num < 999; num++ {
+						postfix := ver + fmt.Sprintf("%03d", num)
+						fullSeed := seed + state.PoolAddr + postfix
+
+					send:
+						for {
+							select {
+							case comms.Jobs <- newJob(state, comms.Generation, seed, postfix, fullSeed):
+								break send
+							case <-changed:
+								next, nextChanged := comms.State.Current()
+								if !next.Ready() || !next.SameSession(state) {
+									return
 								}
-								select {
-								case poolAddr = <-jobComms.PoolAddr:
-								case minerSeed = <-jobComms.MinerSeed:
-								case targetChars = <-jobComms.TargetChars:
-									job.TargetChars = targetChars
-								case targetString = <-jobComms.TargetString:
-									job.TargetString = targetString
-								case poolDepth = <-jobComms.PoolDepth:
-									job.PoolDepth = poolDepth
-								case diff = <-jobComms.Diff:
-									job.Diff = diff
-								case block = <-jobComms.Block:
-									job.Block = block
-								case step = <-jobComms.Step:
-									job.Step = step
-								case comms.Jobs <- job:
-									continue loop
-								case <-disconnected:
-									time.Sleep(10 * time.Second)
-									continue waitready
+								if !sameWork(next, state) {
+									comms.Generation.next()
 								}
+								state, changed = next, nextChanged
 							}
 						}
 					}
@@ -191,3 +170,35 @@ waitready:
 		}
 	}
 }
+
+// seedCharsForSlot returns the seed chars this miner may use for the first
+// of the three seed chars it varies. A proxy (see Proxy) hands each of its
+// miners a slot in the form "n/total" so no two miners hash the same seeds.
+// An empty or invalid slot yields every seed char
+func seedCharsForSlot(slot string) []rune {
+	chars := []rune(hashableSeedChars)
+
+	n, total, ok := parseSeedSlot(slot)
+	if !ok {
+		return chars
+	}
+
+	slotChars := make([]rune, 0, len(chars)/total+1)
+	for i, c := range chars {
+		if i%total == n {
+			slotChars = append(slotChars, c)
+		}
+	}
+	return slotChars
+}
+
+func parseSeedSlot(slot string) (int, int, bool) {
+	var n, total int
+	if _, err := fmt.Sscanf(slot, "%d/%d", &n, &total); err != nil {
+		return 0, 0, false
+	}
+	if total < 1 || total > len(hashableSeedChars) || n < 0 || n >= total {
+		return 0, 0, false
+	}
+	return n, total, true
+}
@@ -0,0 +1,95 @@
+package miner
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSeedCharsForSlot(t *testing.T) {
+	for _, total := range []int{1, 2, 16, len(hashableSeedChars)} {
+		seen := make(map[rune]int)
+		for n := 0; n < total; n++ {
+			chars := seedCharsForSlot(fmt.Sprintf("%d/%d", n, total))
+			if len(chars) == 0 {
+				t.Fatalf("slot %d/%d has no seed chars", n, total)
+			}
+			for _, c := range chars {
+				seen[c]++
+			}
+		}
+		if len(seen) != len(hashableSeedChars) {
+			t.Errorf("%d slots cover %d seed chars want %d", total, len(seen), len(hashableSeedChars))
+		}
+		for c, count := range seen {
+			if count != 1 {
+				t.Errorf("%d slots: seed char %q is in %d slots", total, c, count)
+			}
+		}
+	}
+
+	for _, slot := range []string{"", "garbage", "3/2", "-1/4", "0/0", "0/1000"} {
+		if got := len(seedCharsForSlot(slot)); got != len(hashableSeedChars) {
+			t.Errorf("slot %q: got %d seed chars want all %d", slot, got, len(hashableSeedChars))
+		}
+	}
+}
+
+func TestJobGenerations(t *testing.T) {
+	comms := NewComms()
+	comms.State.Update(func(s *PoolState) {
+		*s = PoolState{
+			PoolAddr: "pool", MinerSeed: "1abc!!!", Block: 100, Diff: 40,
+			TargetChars: 5, TargetString: "abcdef0123", PoolDepth: 1, Session: 1,
+		}
+	})
+	go JobFeeder(comms)
+
+	recv := func() Job {
+		t.Helper()
+		select {
+		case job := <-comms.Jobs:
+			return job
+		case <-time.After(5 * time.Second):
+			t.Fatal("no job")
+		}
+		return Job{}
+	}
+	// The feeder may have built one job before each update
+	recvAfter := func(gen uint64) Job {
+		t.Helper()
+		for i := 0; i < 2; i++ {
+			if job := recv(); job.Block != 100 || job.Generation != gen {
+				return job
+			}
+		}
+		t.Fatal("the feeder kept sending old jobs")
+		return Job{}
+	}
+
+	first := recv()
+	if first.stale() {
+		t.Fatal("new job is stale")
+	}
+
+	comms.State.Update(func(s *PoolState) { s.Balance = "100" })
+	if job := recv(); job.Generation != first.Generation {
+		t.Errorf("a new balance changed the generation from %d to %d", first.Generation, job.Generation)
+	}
+
+	comms.State.Update(func(s *PoolState) { s.Block = 101 })
+	next := recvAfter(first.Generation)
+	if next.Block != 101 || next.Generation == first.Generation {
+		t.Errorf("after a new block got block %d generation %d", next.Block, next.Generation)
+	}
+	if !first.stale() || next.stale() {
+		t.Errorf("stale: first %v, next %v", first.stale(), next.stale())
+	}
+
+	comms.State.Reset()
+	for deadline := time.Now().Add(5 * time.Second); !next.stale(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("jobs are not stale after the connection was lost")
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package miner
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
+)
+
+// StartMetrics serves miner and pool statistics on addr at /metrics in the
+// Prometheus text exposition format
+func StartMetrics(addr string, stats *Stats) error {
+	return serveHTTP("Metrics", addr, newMetricsHandler(stats))
+}
+
+func newMetricsHandler(stats *Stats) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := w.Write(writeMetrics(stats.Status(), stats.Workers())); err != nil {
+			logging.Warnf("Error writing metrics response: %v\n", err)
+		}
+	})
+	return mux
+}
+
+func writeMetrics(status MinerStatus, workers []WorkerStatus) []byte {
+	var b bytes.Buffer
+
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+
+	fmt.Fprintf(&b, "# HELP noso_miner_info Static information about the miner\n# TYPE noso_miner_info gauge\n")
+	fmt.Fprintf(&b, "noso_miner_info{version=%s,wallet=%s,pool=%s} 1\n",
+		quoteLabel(Version), quoteLabel(status.Wallet), quoteLabel(status.Pool))
+
+	connected := 0
+	if status.Connection == ConnJoined {
+		connected = 1
+	}
+
+	metric("noso_miner_connected", "gauge", "Whether the miner has joined the pool", connected)
+	metric("noso_miner_hashes_total", "counter", "Total number of hashes computed", status.TotalHashes)
+	metric("noso_miner_hash_rate", "gauge", "Miner hash rate in hashes per second", status.HashRate)
+
+	fmt.Fprintf(&b, "# HELP noso_miner_worker_hash_rate Hash rate of each mining thread in hashes per second\n# TYPE noso_miner_worker_hash_rate gauge\n")
+	for _, w := range workers {
+		fmt.Fprintf(&b, "noso_miner_worker_hash_rate{worker=%s} %d\n", quoteLabel(w.Worker), w.HashRate)
+	}
+
+	fmt.Fprintf(&b, "# HELP noso_miner_hash_rate_avg Miner hash rate averaged over a window in hashes per second\n# TYPE noso_miner_hash_rate_avg gauge\n")
+	for _, avg := range status.HashRateAvg {
+		fmt.Fprintf(&b, "noso_miner_hash_rate_avg{window=%s} %d\n", quoteLabel(avg.Window), avg.HashRate)
+	}
+	fmt.Fprintf(&b, "# HELP noso_miner_worker_hash_rate_avg Hash rate of each mining thread averaged over a window in hashes per second\n# TYPE noso_miner_worker_hash_rate_avg gauge\n")
+	for _, w := range workers {
+		for _, avg := range w.HashRateAvg {
+			fmt.Fprintf(&b, "noso_miner_worker_hash_rate_avg{worker=%s,window=%s} %d\n", quoteLabel(w.Worker), quoteLabel(avg.Window), avg.HashRate)
+		}
+	}
+	fmt.Fprintf(&b, "# HELP noso_miner_effective_hash_rate_avg Hash rate proven by accepted steps averaged over a window in hashes per second\n# TYPE noso_miner_effective_hash_rate_avg gauge\n")
+	for _, avg := range status.EffectiveHashRateAvg {
+		fmt.Fprintf(&b, "noso_miner_effective_hash_rate_avg{window=%s} %d\n", quoteLabel(avg.Window), avg.HashRate)
+	}
+	metric("noso_miner_stale_jobs_total", "counter", "Jobs the miner was still hashing when a new block or step made them stale", status.StaleJobs)
+	metric("noso_miner_stale_seconds_total", "counter", "Hashing time spent on jobs after a new block or step made them stale", status.StaleTime.Seconds())
+	metric("noso_miner_bad_solutions_total", "counter", "Solutions that failed verification and were not sent to the pool", status.BadSolutions)
+	metric("noso_miner_workers_disabled", "gauge", "Mining threads stopped for finding too many bad solutions", status.WorkersDisabled)
+	metric("noso_miner_effective_hash_rate", "gauge", "Hash rate proven by accepted steps since the miner started, in hashes per second", status.EffectiveHashRate)
+	metric("noso_miner_accept_ratio", "gauge", "Part of the answered steps (PoP) the pool accepted", status.AcceptRatio)
+
+	metric("noso_miner_steps_sent_total", "counter", "Steps (PoP) sent to the pool", status.StepsSent)
+	metric("noso_miner_steps_accepted_total", "counter", "Steps (PoP) accepted by the pool", status.StepsAccepted)
+	metric("noso_miner_steps_failed_total", "counter", "Steps (PoP) rejected by the pool", status.StepsFailed)
+	metric("noso_miner_steps_pending", "gauge", "Steps (PoP) the pool hasn't answered yet", status.StepsPending)
+	metric("noso_miner_steps_resent_total", "counter", "Steps (PoP) sent again after the pool connection was lost", status.StepsResent)
+	metric("noso_miner_steps_discarded_total", "counter", "Steps (PoP) lost with the pool connection because their block was over by the time the miner rejoined", status.StepsDiscarded)
+	metric("noso_miner_shares_earned_total", "counter", "Shares credited by the pool", status.SharesEarned)
+	metric("noso_miner_shares_expected_total", "counter", "Shares the accepted steps should have earned", status.SharesExpected)
+	metric("noso_miner_share_discrepancies_total", "counter", "Accepted steps the pool credited more or fewer shares than expected", status.ShareDiscrepancies)
+	metric("noso_miner_reconnects_total", "counter", "Number of times the pool connection was re-established", status.Reconnects)
+	metric("noso_miner_watchdog_triggers_total", "counter", "Number of times the connection watchdog fired", status.WatchdogTriggers)
+	metric("noso_miner_auth_failures_total", "counter", "Number of times the pool rejected the password", status.AuthFailures)
+	metric("noso_miner_pool_switches_total", "counter", "Number of times the miner failed over to another pool", status.PoolSwitches)
+	metric("noso_pool_block", "gauge", "Block the pool is currently mining", status.Block)
+	metric("noso_pool_hash_rate", "gauge", "Pool hash rate in hashes per second", status.PoolHashRate)
+	metric("noso_pool_balance_noso", "gauge", "Miner balance held by the pool, in Noso", balanceValue(status.Balance))
+	metric("noso_pool_blocks_till_payment", "gauge", "Blocks until the pool balance is paid out", status.BlocksTillPayment)
+
+	return b.Bytes()
+}
+
+// labelEscaper escapes what the text exposition format requires in a label
+// value and nothing else
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(strings.ToValidUTF8(v, "")) + `"`
+}
+
+func balanceValue(balance string) float64 {
+	f, err := strconv.ParseFloat(parseAmount(balance), 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
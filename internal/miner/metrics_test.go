@@ -0,0 +1,67 @@
+package miner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	status := MinerStatus{
+		Wallet:        "leviable",
+		Pool:          "127.0.0.1:8082",
+		Connection:    ConnJoined,
+		TotalHashes:   5000,
+		StepsSent:     4,
+		StepsAccepted: 3,
+		StepsFailed:   1,
+		PoolHashRate:  336517000,
+		Balance:       "953841173",
+
+		HashRateAvg:          []RateAverage{{"1m", 1100}, {"1h", 1000}},
+		EffectiveHashRate:    900,
+		EffectiveHashRateAvg: []RateAverage{{"1m", 950}, {"1h", 900}},
+		AcceptRatio:          0.75,
+	}
+	workers := []WorkerStatus{{Worker: "1", HashRate: 1200, HashRateAvg: []RateAverage{{"1m", 1100}}}}
+
+	got := string(writeMetrics(status, workers))
+
+	want := []string{
+		`noso_miner_info{version="` + Version + `",wallet="leviable",pool="127.0.0.1:8082"} 1`,
+		"noso_miner_connected 1",
+		"# TYPE noso_miner_hashes_total counter",
+		"noso_miner_hashes_total 5000",
+		`noso_miner_worker_hash_rate{worker="1"} 1200`,
+		`noso_miner_hash_rate_avg{window="1h"} 1000`,
+		`noso_miner_worker_hash_rate_avg{worker="1",window="1m"} 1100`,
+		`noso_miner_effective_hash_rate_avg{window="1m"} 950`,
+		"noso_miner_effective_hash_rate 900",
+		"noso_miner_accept_ratio 0.75",
+		"noso_miner_steps_sent_total 4",
+		"noso_miner_steps_accepted_total 3",
+		"noso_miner_steps_failed_total 1",
+		"noso_pool_hash_rate 336517000",
+		"noso_pool_balance_noso 9.53841173",
+	}
+	for _, line := range want {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("metrics missing line %q\n%s", line, got)
+		}
+	}
+}
+
+func TestQuoteLabel(t *testing.T) {
+	for v, want := range map[string]string{
+		"rig-1":       `"rig-1"`,
+		`C:\miner`:    `"C:\\miner"`,
+		`say "hi"`:    `"say \"hi\""`,
+		"two\nlines":  `"two\nlines"`,
+		"tab\there":   "\"tab\there\"",
+		"minería ⛏":   `"minería ⛏"`,
+		"bad\xffutf8": `"badutf8"`,
+	} {
+		if got := quoteLabel(v); got != want {
+			t.Errorf("quoteLabel(%q) = %s want %s", v, got, want)
+		}
+	}
+}
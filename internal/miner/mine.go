@@ -22,15 +22,15 @@ THE SOFTWARE.
 package miner
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"log"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/Noso-Project/noso-go/internal/hasher"
+	"github.com/Noso-Project/noso-go/internal/logging"
 )
 
 const (
@@ -45,216 +45,303 @@ const (
 `
 )
 
-func Mine(opts *Opts) {
-	var (
+// printHeader logs the version banner, or a single line in JSON logs
+func printHeader() {
+	if logging.Default().Format() == logging.FormatJSON {
+		logging.Infof("noso-go %s (commit %s)", Version, Commit)
+		return
+	}
+	logging.Infof(HEADER, Version, Commit)
+}
 
-		// last response from pool
-		resp string
+// Mine mines on the pools in opts until ctx is done, or the client gives
+// up on the pool connection. Either way it sends the solutions that were
+// already found before returning, and logs a summary of the session. It
+// returns ErrConnectionLost if the connection was lost, and nil if ctx
+// was cancelled
+func Mine(ctx context.Context, opts *Opts) error {
+	var (
 
 		// state vars
-		workerReports     map[string]Report
-		poolAddr          string
-		minerSeed         string
-		targetBlock       int
-		targetString      string
-		targetChars       int
-		currentStep       int
-		currentDiff       int
-		poolDepth         int
-		stepsSent         int
-		stepsAccepted     int
-		sharesEarned      int
-		sharesEarnedBlk   int
-		blocksTillPayment int
-		balance           string
-		paymentRequested  time.Time
-		btpNote           string
-
-		// hash rate info
-		totalHashes  int
-		hashRate     int
-		poolHashRate string
+		poolIp      string
+		btpNote     string
+		paymentNote string
 
 		// syncing
 		m sync.RWMutex
 	)
 
-	ex, _ := os.Executable()
-	exPath := filepath.Dir(ex)
+	printHeader()
 
-	fileName := filepath.Join(exPath, "noso-go.log")
-	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Println("Error writing to log file: ", err)
+	if len(opts.Pools) > 1 {
+		for i, pool := range opts.Pools {
+			logging.Infof("Pool %d                     : %s with password %s\n", i+1, pool.Describe(), pool.Password)
+		}
+		poolIp = opts.Pools[0].Address
+	} else if len(opts.Pools) == 1 {
+		logging.Infof("Connecting to %s with password %s\n", opts.Pools[0].Describe(), opts.PoolPw)
+		poolIp = opts.IpAddr
 	} else {
-		defer file.Close()
-		mw := io.MultiWriter(os.Stdout, file)
-		log.SetOutput(mw)
-		log.Printf("Writing logs to: %s", fileName)
+		logging.Infof("Connecting to %s:%d with password %s\n", opts.IpAddr, opts.IpPort, opts.PoolPw)
+		poolIp = opts.IpAddr
 	}
-	log.Printf(HEADER, Version, Commit)
+	logging.Infof("Using wallet address(es)   : %s\n", strings.Join(opts.Wallets, " "))
+	logging.Infof("Number of CPU cores to use : %d\n", opts.Cpu)
 
-	workerReports = make(map[string]Report)
+	// Every worker needs its own hasher. Check the backend hashes
+	// correctly before mining with it
+	h, err := hasher.New(opts.Hasher)
+	if err != nil {
+		logging.Fatalf("%v\n", err)
+	}
+	if err := hasher.SelfTest(h); err != nil {
+		logging.Fatalf("%v\n", err)
+	}
+	logging.Infof("Hasher                     : %s (%s)\n", h.Name(), hasher.Describe(h.Name()))
+	logging.Infof("Device ID                  : %s\n", deviceId)
+	logging.Infof("Instance ID                : %s\n", instanceId)
 
-	// Set a date in the past so we can request payment immediately if we
-	// have a vested balance
-	balance = "0"
-	paymentRequested = time.Now().Add(-3 * time.Hour)
+	stats := NewStats()
+	if len(opts.RateWindows) > 0 {
+		stats.SetRateWindows(opts.RateWindows)
+	}
+	if opts.ApiListen != "" {
+		if err := StartAPI(opts.ApiListen, stats); err != nil {
+			logging.Fatalf("Could not start status API on %s: %v\n", opts.ApiListen, err)
+		}
+	}
+	if opts.MetricsListen != "" {
+		if err := StartMetrics(opts.MetricsListen, stats); err != nil {
+			logging.Fatalf("Could not start metrics endpoint on %s: %v\n", opts.MetricsListen, err)
+		}
+	}
+
+	if err := useDataDir(opts); err != nil {
+		logging.Fatalf("Could not create data directory %s: %v\n", opts.DataDir, err)
+	}
+
+	var db *StatsDB
+	if opts.StatsFile != "" {
+		if db, err = OpenStatsDB(DataPath(opts.DataDir, opts.StatsFile)); err != nil {
+			logging.Warnf("Not saving per-block stats: %v\n", err)
+			db = nil
+		}
+	}
+	blocks := newBlockRecorder(db, stats)
+
+	// When payment was last requested for each wallet
+	paymentRequested := lastPaymentRequests()
 
-	log.Printf("Connecting to %s:%d with password %s\n", opts.IpAddr, opts.IpPort, opts.PoolPw)
-	log.Printf("Using wallet address(es)   : %s\n", strings.Join(opts.Wallets, " "))
-	log.Printf("Number of CPU cores to use : %d\n", opts.Cpu)
-	log.Printf("Device ID                  : %s\n", deviceId)
-	log.Printf("Instance ID                : %s\n", instanceId)
 	comms := NewComms()
-	client := NewTcpClient(opts, comms, true, true)
+	client := NewTcpClient(opts, comms, stats, true, true)
+
+	// Start the goroutine parsing the pool's messages
+	stopParsing := make(chan struct{})
+	go parseLines(comms, client, stopParsing)
 
 	// Start the job feeder goroutine
-	jobComms := NewJobComms()
-	go JobFeeder(comms, jobComms)
+	go JobFeeder(comms)
 
 	// Start the Solutions Manager goroutine
-	solComms := NewSolutionComms(client.SendChan)
-	go SolutionManager(solComms, opts.ShowPop)
+	queue := newStepQueue(client.SendChan, stats)
+	checker := newSolutionChecker(stats, opts.MaxSolutionErrors)
+	solutionsSent := make(chan struct{}, 0)
+	go func() {
+		SolutionManager(comms, queue, checker, stats, opts.ShowPop)
+		close(solutionsSent)
+	}()
 
 	// Start the miner goroutines
-	ready := make(chan bool, 0)
+	minerCtx, stopMiners := context.WithCancel(ctx)
+	defer stopMiners()
+	miners := &sync.WaitGroup{}
 	for x := 1; x <= opts.Cpu; x++ {
-		go Miner(strconv.Itoa(x), comms, ready)
+		h, _ := hasher.New(h.Name())
+		workerNum := strconv.Itoa(x)
+		workerCtx, stopWorker := context.WithCancel(minerCtx)
+		checker.addWorker(workerNum, stopWorker)
+		miners.Add(1)
+		go func() {
+			defer miners.Done()
+			Miner(workerCtx, workerNum, comms, h)
+		}()
 	}
 
-	// TODO: Need to do a sync broadcast for ready
-	go func() {
-
-		for running := true; running; {
-			m.RLock()
-			if targetChars == 0 || targetBlock == 0 || targetString == "" {
-				time.Sleep(100 * time.Millisecond)
-			} else {
-				running = false
-			}
-			m.RUnlock()
-		}
-		close(ready)
-	}()
-
 	// Create the payments.csv file if it doesn't already exist
 	CreateLogPaymentsFile()
 
-	// Print a reward status every StatusInterval seconds (default 60)
+	// Print a reward status every StatusInterval seconds (default 60) until
+	// mining stops
 	go func() {
+		ticker := time.NewTicker(time.Duration(opts.StatusInterval) * time.Second)
+		defer ticker.Stop()
 		for {
 			select {
-			case <-time.After(time.Duration(opts.StatusInterval) * time.Second):
+			case <-minerCtx.Done():
+				return
+			case <-ticker.C:
+				status := stats.Status()
 				m.RLock()
-				hr := hashRate
-				bal := balance
+				note := btpNote
 				m.RUnlock()
-				log.Printf(
+				logging.Infof(
 					statusMsg,
-					opts.CurrentWallet,
-					targetBlock,
-					formatHashRate(strconv.Itoa(hr)),
-					formatHashRate(poolHashRate),
-					formatBalance(bal),
-					blocksTillPayment,
-					btpNote,
-					stepsSent,
-					stepsAccepted,
+					status.Wallet,
+					connectionNote(status),
+					status.Block,
+					formatHashRate(strconv.Itoa(status.HashRate)),
+					status.StaleTime.Round(time.Millisecond),
+					status.StaleJobs,
+					status.BadSolutions,
+					status.WorkersDisabled,
+					formatHashRate(strconv.FormatInt(status.PoolHashRate, 10)),
+					rateTable(status, stats.Workers()),
+					formatBalance(status.Balance),
+					status.BlocksTillPayment,
+					note,
+					status.StepsSent,
+					status.StepsAccepted,
+					status.StepsFailed,
+					status.StepsPending,
+					status.StepsResent,
+					status.StepsDiscarded,
+					status.AcceptRatio*100,
+					status.SharesEarned,
+					status.SharesExpected,
+					status.ShareDiscrepancies,
 				)
 			}
 		}
 	}()
 
-	// TODO: Sending individual info (block, chars, string, etc
-	//       will probably lead to a race condition. Send a
-	//       BlockUpdate struct instead with all info?
+	// Every message with pool data replaces the whole pool state at once
+	state, changed := comms.State.Current()
 main:
 	for {
 		select {
-		case poolAddr = <-comms.PoolAddr:
-			jobComms.PoolAddr <- poolAddr
-		case minerSeed = <-comms.MinerSeed:
-			jobComms.MinerSeed <- minerSeed
-		case ts := <-comms.TargetString:
-			m.Lock()
-			targetString = ts
-			m.Unlock()
-			jobComms.TargetString <- ts
-		case tc := <-comms.TargetChars:
-			m.Lock()
-			targetChars = tc
-			m.Unlock()
-			jobComms.TargetChars <- tc
-		case newBlock := <-comms.Block:
-			m.Lock()
-			targetBlock = newBlock
-			m.Unlock()
-			jobComms.Block <- newBlock
-			solComms.Block <- newBlock
-		case currentStep = <-comms.Step:
-			jobComms.Step <- currentStep
-			solComms.Step <- currentStep
-		case currentDiff = <-comms.Diff:
-			jobComms.Diff <- currentDiff
-			solComms.Diff <- currentDiff
-		case poolDepth = <-comms.PoolDepth:
-			jobComms.PoolDepth <- poolDepth
-		case bal := <-comms.Balance:
-			m.Lock()
-			balance = bal
-			m.Unlock()
-		case poolHashRate = <-comms.PoolHashRate:
-		case blocksTillPayment = <-comms.BlocksTillPayment:
-			// If we have a non-zero balance
-			// And our balance is fully vested
-			// And we haven't requested payment in at least 10 minutes
-			if balance != "0" && blocksTillPayment > 0 && time.Since(paymentRequested) > 10*time.Minute {
-				client.SendChan <- "PAYMENT"
-				LogPaymentReq(opts.IpAddr, opts.CurrentWallet, targetBlock, balance)
-				paymentRequested = time.Now()
-			} else if blocksTillPayment > 0 {
+		case pool := <-comms.PoolChanged:
+			// The client has already cleared the pool state, so solutions
+			// in flight for the old pool are dropped
+			poolIp = pool.Address
+			blocks.finish()
+		case <-changed:
+			prev := state
+			state, changed = comms.State.Current()
+			st := state
+			queue.update(st)
+			if !st.Ready() {
+				// Keep showing the last block while reconnecting
+				continue
+			}
+			stats.Update(func(s *MinerStatus) {
+				s.Block = st.Block
+				s.Step = st.Step
+				s.Diff = st.Diff
+				s.Balance = parseAmount(st.Balance)
+				s.BlocksTillPayment = st.BlocksTillPayment
+				s.PoolHashRate = st.PoolHashRate
+			})
+			stats.SetStepDifficulty(st.Diff, st.PoolDepth)
+
+			// Only look at payments when the pool sends the data for a
+			// block, not on every pool hash rate update
+			if st.Block == prev.Block {
+				continue
+			}
+			blocks.next(st.Block, poolIp, opts.CurrentWallet)
+
+			// Ask for a vested balance when the payment policy allows it,
+			// and say once why not when it doesn't
+			balance, _ := ParseNosoAmount(st.Balance)
+			due, reason := opts.Payments.due(time.Now(), balance, st.BlocksTillPayment, paymentRequested[opts.CurrentWallet])
+			if reason != paymentNote && reason != "" {
+				logging.Infof("Not requesting payment: %s\n", reason)
+			}
+			paymentNote = reason
+			if due {
+				client.SendChan <- PaymentCmd{}.String()
+				LogPaymentReq(poolIp, opts.CurrentWallet, st.Block, st.Balance)
+				paymentRequested[opts.CurrentWallet] = time.Now()
+			} else if st.BlocksTillPayment > 0 && reason == "" {
+				m.Lock()
 				btpNote = fmt.Sprint(`(* Note: A positive number here means you will
                             receive a payment as soon as the pool finds a block)`)
+				m.Unlock()
 			} else {
-				btpNote = ""
+				m.Lock()
+				btpNote = reason
+				m.Unlock()
 			}
-		case <-solComms.StepSent:
-			stepsSent++
+		case payment := <-comms.Payment:
+			logging.Infof("Pool paid %s Noso to %s in block %d (order %s)\n", parseAmount(payment.Amount), payment.Wallet, payment.Block, payment.OrderId)
 		case shares := <-comms.StepSolved:
-			stepsAccepted++
-			sharesEarned += shares
-			sharesEarnedBlk += shares
+			stepAnswered(queue, stats, opts.ShareRule, true, shares)
 		case <-comms.StepFailed:
-		case sol := <-comms.Solutions:
-			solComms.Solution <- sol
+			stepAnswered(queue, stats, opts.ShareRule, false, 0)
 		case report := <-comms.Reports:
-			// TODO: do rolling average instead of all time
-			workerReports[report.WorkerNum] = report
-
-			hr := 0
-			for _, rep := range workerReports {
-				dur := float64(rep.Duration) / float64(time.Second)
-				hr += int(float64(rep.Hashes) / dur)
+			// The PING goroutine only reads while connected, don't wait
+			// for it during a reconnect
+			select {
+			case comms.HashRate <- stats.AddReport(report):
+			default:
 			}
-			totalHashes += report.Hashes
-			m.Lock()
-			hashRate = hr
-			m.Unlock()
-			comms.HashRate <- hr
-		case resp = <-client.RecvChan:
-			go Parse(comms, opts.IpAddr, opts.CurrentWallet, targetBlock, resp)
-		case <-comms.Disconnected:
-			if opts.ExitOnRetry {
-				break main
+		case <-ctx.Done():
+			logging.Infof("Shutting down, sending the solutions already found to the pool\n")
+			break main
+		case <-client.Done():
+			err = client.Err()
+			logging.Errorf("Exiting because %v and --exit-on-retry is set\n", err)
+			break main
+		}
+	}
+
+	stopMiners()
+	drain(comms, client, stats, queue, opts.ShareRule, miners, solutionsSent)
+	close(stopParsing)
+	blocks.finish()
+	client.Close(time.Second)
+	printSummary(stats.Status())
+
+	return err
+}
+
+// connectionNote describes the pool connection for the status message
+func connectionNote(status MinerStatus) string {
+	if status.Connection == ConnAuthFailed {
+		return fmt.Sprintf("%s (the pool rejected the password %d time(s), check --password)", status.Connection, status.AuthFailures)
+	}
+	return status.Connection
+}
+
+// rateTable lays out the hash rate averages of the miner and its workers,
+// one column per window
+func rateTable(status MinerStatus, workers []WorkerStatus) string {
+	var b strings.Builder
+
+	row := func(name string, avgs []RateAverage, label bool) {
+		fmt.Fprintf(&b, "%-20s:", name)
+		for _, avg := range avgs {
+			if label {
+				fmt.Fprintf(&b, " %16s", avg.Window)
+			} else {
+				fmt.Fprintf(&b, " %16s", formatHashRate(strconv.Itoa(avg.HashRate)))
 			}
 		}
+		b.WriteString("\n")
 	}
 
-	if opts.ExitOnRetry {
-		// TODO: This will exit with code 0. Should it be non-zero?
-		fmt.Println("Connection lost and --exit-on-retry flag is True. Exiting")
+	row("Hash Rate Averages", status.HashRateAvg, true)
+	row("  Total", status.HashRateAvg, false)
+	row("  Effective", status.EffectiveHashRateAvg, false)
+	for _, w := range workers {
+		name := "  Worker " + w.Worker
+		if w.Disabled {
+			name += " (stopped)"
+		}
+		row(name, w.HashRateAvg, false)
 	}
+
+	return b.String()
 }
 
 const statusMsg = `
@@ -263,12 +350,16 @@ const statusMsg = `
 Miner Status
 
 Miner's Wallet Addr : %s
+Pool Connection     : %s
 
 Current Block       : %d
 
 Miner Hash Rate     : %s
+Stale Hashing       : %s in %d job(s)
+Bad Solutions       : %d (%d worker(s) stopped)
 Pool Hash Rate      : %s
 
+%s
 Pool Balance        : %s
 Blocks Till Payment : %d %s
 
@@ -277,6 +368,12 @@ Proof of Participation
 ----------------------
 PoP Sent            : %d
 PoP Accepted        : %d
+PoP Failed          : %d
+PoP Pending         : %d
+PoP Resent          : %d
+PoP Lost            : %d
+PoP Accept Ratio    : %.1f%%
+Shares Earned       : %d (%d expected, %d step(s) credited differently)
 
 ************************************
 
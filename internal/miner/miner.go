@@ -1,118 +1,154 @@
 package miner
 
 import (
-	"bytes"
-	"crypto/sha256"
+	"context"
 	"encoding/hex"
 	"reflect"
-	"strings"
+	"sync"
 	"time"
 	"unsafe"
+
+	"github.com/Noso-Project/noso-go/internal/hasher"
+	"github.com/Noso-Project/noso-go/internal/logging"
 )
 
 const (
 	hashChars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 )
 
-func Miner(workerNum string, comms *Comms, ready chan bool) {
+// shortTargetWarning says once that the pool's difficulty and depth left
+// no target, see hashJob
+var shortTargetWarning sync.Once
+
+// Miner hashes the jobs it gets from the job feeder, which only hands out
+// jobs once the pool has been joined. It returns when ctx is done, giving
+// up on the job at hand
+func Miner(ctx context.Context, workerNum string, comms *Comms, h hasher.Hasher) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-comms.Jobs:
+			// Both may have been ready, a stopped worker takes no more jobs
+			if ctx.Err() != nil {
+				return
+			}
+			jobStart := time.Now()
+			hashCount := hashJob(ctx, job, h, func(sol Solution) {
+				sol.Worker = workerNum
+				comms.Solutions <- sol
+			})
+			comms.Reports <- Report{
+				WorkerNum: workerNum,
+				Hashes:    hashCount,
+				Duration:  time.Since(jobStart),
+				Stale:     job.staleFor(jobStart),
+			}
+		}
+	}
+}
+
+// minTargetLen returns the length of the shortest target the pool accepts
+// for diff and depth. A depth as long as the target would leave nothing to
+// match, so it is never less than 1
+func minTargetLen(diff, depth int) int {
+	if n := diff/10 + 1 - depth; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// hashJob iterates through every hash string candidate for a job, calling
+// found for each hash that meets the minimum target the pool will accept.
+// Candidates are hashed h.Lanes() at a time. It gives up as soon as the
+// job is stale or ctx is done. It returns the number of hashes computed.
+func hashJob(ctx context.Context, job Job, h hasher.Hasher, found func(Solution)) int {
 	var (
-		jobStart    time.Time
-		jobDuration time.Duration
-		buff        *bytes.Buffer
-		hashStr     string
-		targets     []string
-		targetLen   int
-		targetMin   int
-
-		// From hash_22
-		seedLen   int
-		w         rune
-		x         rune
-		y         rune
-		z         rune
-		tmp       [32]byte
-		val       string
+		targetLen int
+		targetMin int
 		hashCount int
+		lane      int
 	)
 
-	encoded := make([]byte, 64)
-
-	// Wait until ready
-	<-ready
+	maxLen := (job.Diff / 10) + 1
+	targetMin = minTargetLen(job.Diff, job.PoolDepth)
+	if targetMin > maxLen-job.PoolDepth {
+		shortTargetWarning.Do(func() {
+			logging.Warnf("Pool depth %d leaves no target for difficulty %d, matching at least 1 character\n", job.PoolDepth, job.Diff)
+		})
+	}
+	if maxLen > len(job.TargetString) {
+		maxLen = len(job.TargetString)
+	}
 
-	for job := range comms.Jobs {
-		jobStart = time.Now()
-		targetMin = (job.Diff / 10) + 1 - job.PoolDepth
-		buff = bytes.NewBuffer(job.SeedFullBytes)
-		seedLen = buff.Len()
-		hashCount = 0
+	// targets[0] is the absolute minimum that a pool will accept, and each
+	// longer target starts with it, so a single matcher finds them all
+	matcher := newTargetMatcher(job.TargetString[:maxLen], targetMin)
 
-		targets = make([]string, job.PoolDepth+1)
+	h.SetPrefix(job.SeedFullBytes, 4)
+	lanes := h.Lanes()
+	hashStrs := make([][]byte, lanes)
+	for i := range hashStrs {
+		hashStrs[i] = h.Suffix(i)
+	}
+	sums := make([][hasher.Size]byte, lanes)
 
-		for i := 0; i < job.PoolDepth+1; i++ {
-			targets[i] = job.TargetString[:targetMin+i]
-		}
+	check := func(n int) {
+		h.Sum(sums)
+		hashCount += n
 
-		// 5 was chosen so that it would take roughly 1 second to iterate
-		// through all the hashes on one modern-ish cpu thread
-		for _, w = range hashChars[:5] {
-			for _, x = range hashChars {
-				for _, y = range hashChars {
-					for _, z = range hashChars {
-						hashCount++
-						buff.Truncate(seedLen)
+		for i := 0; i < n; i++ {
+			if targetLen = matcher.match(&sums[i]); targetLen == 0 || found == nil {
+				continue
+			}
 
-						buff.WriteRune(w)
-						buff.WriteRune(x)
-						buff.WriteRune(y)
-						buff.WriteRune(z)
+			found(Solution{
+				PoolAddr:    job.PoolAddr,
+				Seed:        job.SeedMiner,
+				HashStr:     job.SeedPostfix + string(hashStrs[i]),
+				Block:       job.Block,
+				Chars:       job.TargetChars,
+				Step:        job.Step,
+				SolvedHash:  hex.EncodeToString(sums[i][:]),
+				TargetLen:   targetLen,
+				Target:      job.TargetString[:targetLen],
+				FullTarget:  job.TargetString[:job.TargetChars],
+				MatchTarget: job.TargetString[:maxLen],
+				Diff:        job.Diff,
+				PoolDepth:   job.PoolDepth,
+			})
+		}
+	}
 
+	// 5 was chosen so that it would take roughly 1 second to iterate
+	// through all the hashes on one modern-ish cpu thread
+	for w := 0; w < 5; w++ {
+		for x := 0; x < len(hashChars); x++ {
+			for y := 0; y < len(hashChars); y++ {
+				if job.stale() || ctx.Err() != nil {
+					return hashCount
+				}
+				for z := 0; z < len(hashChars); z++ {
+					hashStr := hashStrs[lane]
+					hashStr[0] = hashChars[w]
+					hashStr[1] = hashChars[x]
+					hashStr[2] = hashChars[y]
+					hashStr[3] = hashChars[z]
+
+					if lane++; lane == lanes {
 						// This is the meat of the hashing
-						tmp = sha256.Sum256(buff.Bytes())
-						hex.Encode(encoded, tmp[:])
-						val = BytesToString(encoded)
-
-						// TODO: We could almost certainly increase hashrate if we
-						//       could search the sha sum bytes rather than converting
-						//       to a string first and then doing a string search
-						// TODO: Benchmark doing a small substring search
-						if !strings.Contains(val, targets[0]) {
-							// targets[0] is that absolute minimum that a pool will accept
-							// if we dont match that minimum, we can drop this solution
-							// and continue with the hashing
-							continue
-						}
-
-						targetLen = targetMin
-						for _, t := range targets[1:] {
-							if !strings.Contains(val, t) {
-								break
-							}
-							targetLen++
-						}
-
-						hashStr = string(w) + string(x) + string(y) + string(z)
-						solution := make([]byte, len(val))
-						copy(solution, val)
-
-						comms.Solutions <- Solution{
-							Seed:       job.SeedMiner,
-							HashStr:    job.SeedPostfix + hashStr,
-							Block:      job.Block,
-							Chars:      job.TargetChars,
-							Step:       job.Step,
-							SolvedHash: *(*string)(unsafe.Pointer(&solution)),
-							TargetLen:  targetLen,
-							Target:     job.TargetString[:targetLen],
-							FullTarget: job.TargetString[:job.TargetChars],
-						}
+						check(lanes)
+						lane = 0
 					}
 				}
 			}
 		}
-		jobDuration = time.Since(jobStart)
-		comms.Reports <- Report{WorkerNum: workerNum, Hashes: hashCount, Duration: jobDuration}
 	}
+	if lane > 0 {
+		check(lane)
+	}
+
+	return hashCount
 }
 
 func BytesToString(bytes []byte) string {
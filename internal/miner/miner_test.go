@@ -0,0 +1,410 @@
+package miner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/Noso-Project/noso-go/internal/hasher"
+)
+
+// hashJobReference is the string based hashJob the matcher replaced. It
+// is kept to check the results are the same and to benchmark against
+func hashJobReference(job Job, found func(Solution)) int {
+	var (
+		buff      *bytes.Buffer
+		hashStr   string
+		targets   []string
+		targetLen int
+		targetMin int
+
+		// From hash_22
+		seedLen   int
+		w         rune
+		x         rune
+		y         rune
+		z         rune
+		tmp       [32]byte
+		val       string
+		hashCount int
+	)
+
+	encoded := make([]byte, 64)
+
+	targetMin = (job.Diff / 10) + 1 - job.PoolDepth
+	buff = bytes.NewBuffer(job.SeedFullBytes)
+	seedLen = buff.Len()
+
+	targets = make([]string, job.PoolDepth+1)
+
+	for i := 0; i < job.PoolDepth+1; i++ {
+		targets[i] = job.TargetString[:targetMin+i]
+	}
+
+	// 5 was chosen so that it would take roughly 1 second to iterate
+	// through all the hashes on one modern-ish cpu thread
+	for _, w = range hashChars[:5] {
+		for _, x = range hashChars {
+			for _, y = range hashChars {
+				for _, z = range hashChars {
+					hashCount++
+					buff.Truncate(seedLen)
+
+					buff.WriteRune(w)
+					buff.WriteRune(x)
+					buff.WriteRune(y)
+					buff.WriteRune(z)
+
+					// This is the meat of the hashing
+					tmp = sha256.Sum256(buff.Bytes())
+					hex.Encode(encoded, tmp[:])
+					val = BytesToString(encoded)
+
+					if !strings.Contains(val, targets[0]) {
+						// targets[0] is that absolute minimum that a pool will accept
+						// if we dont match that minimum, we can drop this solution
+						// and continue with the hashing
+						continue
+					}
+
+					targetLen = targetMin
+					for _, t := range targets[1:] {
+						if !strings.Contains(val, t) {
+							break
+						}
+						targetLen++
+					}
+
+					if found == nil {
+						continue
+					}
+
+					hashStr = string(w) + string(x) + string(y) + string(z)
+					solution := make([]byte, len(val))
+					copy(solution, val)
+
+					found(Solution{
+						PoolAddr:    job.PoolAddr,
+						Seed:        job.SeedMiner,
+						HashStr:     job.SeedPostfix + hashStr,
+						Block:       job.Block,
+						Chars:       job.TargetChars,
+						Step:        job.Step,
+						SolvedHash:  *(*string)(unsafe.Pointer(&solution)),
+						TargetLen:   targetLen,
+						Target:      job.TargetString[:targetLen],
+						FullTarget:  job.TargetString[:job.TargetChars],
+						MatchTarget: targets[job.PoolDepth],
+						Diff:        job.Diff,
+						PoolDepth:   job.PoolDepth,
+					})
+				}
+			}
+		}
+	}
+
+	return hashCount
+}
+
+// longPrefixJob is a benchmark job whose seed spans a full SHA-256 block,
+// so the prefix midstate is reused
+func longPrefixJob() Job {
+	job := newBenchmarkJob(0, 1)
+	job.PoolAddr = strings.Repeat(benchPoolAddr, 2)
+	job.SeedFull = job.SeedMiner + job.PoolAddr + job.SeedPostfix
+	job.SeedFullBytes = []byte(job.SeedFull)
+	return job
+}
+
+func collectSolutions(job Job, hash func(Job, func(Solution)) int) []Solution {
+	sols := []Solution{}
+	hash(job, func(sol Solution) { sols = append(sols, sol) })
+	return sols
+}
+
+func newHasher(t testing.TB, name string) hasher.Hasher {
+	t.Helper()
+	h, err := hasher.New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHashJobMatchesReference(t *testing.T) {
+	easy := newBenchmarkJob(2, 7)
+	easy.Diff = 30
+	easy.PoolDepth = 1
+
+	short := newBenchmarkJob(3, 8)
+	short.Diff = 20
+	short.PoolDepth = 1
+
+	long := longPrefixJob()
+	long.Diff = 40
+	long.PoolDepth = 2
+
+	for name, job := range map[string]Job{"easy": easy, "short target": short, "long prefix": long} {
+		want := collectSolutions(job, hashJobReference)
+		if len(want) == 0 {
+			t.Fatalf("%s: reference found no solutions", name)
+		}
+
+		for _, backend := range hasher.Available() {
+			h := newHasher(t, backend)
+			got := collectSolutions(job, func(job Job, found func(Solution)) int {
+				return hashJob(context.Background(), job, h, found)
+			})
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s with %s: got %d solutions want %d", name, backend, len(got), len(want))
+			}
+		}
+	}
+}
+
+func TestTargetMatcher(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	target := "5afadec0006675e408e5c06aa09c0120"
+
+	for i := 0; i < 20000; i++ {
+		var sum [32]byte
+		r.Read(sum[:])
+		min := 1 + r.Intn(4)
+		// Plant the target somewhere in most digests
+		if i%4 != 0 {
+			s := []byte(hex.EncodeToString(sum[:]))
+			n := min + r.Intn(4)
+			copy(s[r.Intn(len(s)-n):], target[:n])
+			hex.Decode(sum[:], s)
+		}
+
+		hexSum := hex.EncodeToString(sum[:])
+		want := 0
+		for l := min; l <= 8; l++ {
+			if !strings.Contains(hexSum, target[:l]) {
+				break
+			}
+			want = l
+		}
+
+		if got := newTargetMatcher(target[:8], min).match(&sum); got != want {
+			t.Fatalf("%s with target %s min %d: got %d want %d", hexSum, target[:8], min, got, want)
+		}
+	}
+
+	var sum [32]byte
+	copy(sum[:], []byte{0x5a, 0xfa, 0xde})
+	if got := newTargetMatcher("5AFADE", 4).match(&sum); got != 6 {
+		t.Errorf("upper case target: got %d want 6", got)
+	}
+	if got := newTargetMatcher("5afxde", 3).match(&sum); got != 3 {
+		t.Errorf("target with a non hex character: got %d want 3", got)
+	}
+	if got := newTargetMatcher("5afxde", 4).match(&sum); got != 0 {
+		t.Errorf("non hex character within the minimum: got %d want 0", got)
+	}
+}
+
+func TestHashJobSolutions(t *testing.T) {
+	job := newBenchmarkJob(0, 1)
+	// Lower the difficulty so the job yields plenty of solutions
+	job.Diff = 30
+	job.PoolDepth = 1
+
+	found := 0
+	hashes := hashJob(context.Background(), job, newHasher(t, hasher.Auto), func(sol Solution) {
+		found++
+
+		sum := sha256.Sum256([]byte(sol.Seed + job.PoolAddr + sol.HashStr))
+		want := hex.EncodeToString(sum[:])
+		if sol.SolvedHash != want {
+			t.Fatalf("got hash %s want %s", sol.SolvedHash, want)
+		}
+		if !strings.Contains(sol.SolvedHash, sol.Target) {
+			t.Fatalf("hash %s does not contain target %s", sol.SolvedHash, sol.Target)
+		}
+		if sol.TargetLen < 3 || sol.TargetLen > 4 {
+			t.Fatalf("got target len %d want 3 or 4", sol.TargetLen)
+		}
+	})
+
+	if want := 5 * len(hashChars) * len(hashChars) * len(hashChars); hashes != want {
+		t.Errorf("got %d hashes want %d", hashes, want)
+	}
+	if found == 0 {
+		t.Error("expected at least one solution")
+	}
+}
+
+func TestHashJobStale(t *testing.T) {
+	gen := &JobGeneration{}
+	job := newBenchmarkJob(0, 1)
+	job.Diff = 30
+	job.PoolDepth = 1
+	job.Generation, job.generations = gen.Current(), gen
+
+	h := newHasher(t, hasher.Auto)
+	found := 0
+	hashes := hashJob(context.Background(), job, h, func(Solution) {
+		if found++; found == 1 {
+			gen.next()
+		}
+	})
+	if max := 5 * len(hashChars) * len(hashChars) * len(hashChars) / 10; hashes == 0 || hashes > max {
+		t.Errorf("got %d hashes after the job went stale, want it dropped early", hashes)
+	}
+
+	start := time.Now()
+	if stale := job.staleFor(start); stale < 0 || stale > time.Since(start) {
+		t.Errorf("got stale for %s", stale)
+	}
+	if hashes := hashJob(context.Background(), job, h, nil); hashes != 0 {
+		t.Errorf("stale job: got %d hashes want 0", hashes)
+	}
+}
+
+func TestHashJobShortTarget(t *testing.T) {
+	// The pool depth is longer than the target, so any one character
+	// counts
+	gen := &JobGeneration{}
+	job := newBenchmarkJob(0, 1)
+	job.Diff = 20
+	job.PoolDepth = 5
+	job.Generation, job.generations = gen.Current(), gen
+
+	found := 0
+	hashJob(context.Background(), job, newHasher(t, hasher.Auto), func(sol Solution) {
+		if sol.TargetLen < 1 || sol.TargetLen > 3 || verifySolution(sol) != nil {
+			t.Fatalf("bad solution %+v", sol)
+		}
+		// Nearly every hash is one, a few are enough
+		if found++; found == 100 {
+			gen.next()
+		}
+	})
+	if found == 0 {
+		t.Error("no solutions found")
+	}
+}
+
+func TestHashJobCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if hashes := hashJob(ctx, newBenchmarkJob(0, 1), newHasher(t, hasher.Auto), nil); hashes != 0 {
+		t.Errorf("cancelled job: got %d hashes want 0", hashes)
+	}
+}
+
+func TestMinerStopped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Jobs are always on offer, a stopped worker must not take them
+	comms := NewComms()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case comms.Jobs <- newBenchmarkJob(0, 1):
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	h := newHasher(t, hasher.Auto)
+	for i := 0; i < 100; i++ {
+		done := make(chan struct{})
+		go func() {
+			Miner(ctx, "1", comms, h)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case report := <-comms.Reports:
+			t.Fatalf("stopped worker hashed a job: %+v", report)
+		}
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	steps := 0
+	report, err := Benchmark(1, 1, hasher.Auto, func(BenchmarkResult) { steps++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if steps != 1 || len(report.Results) != 1 {
+		t.Fatalf("got %d steps and %d results want 1", steps, len(report.Results))
+	}
+	if report.Results[0].Hashes == 0 || report.Results[0].HashRate == 0 {
+		t.Errorf("expected non-zero hashes, got %+v", report.Results[0])
+	}
+	if report.Recommended != 1 {
+		t.Errorf("got recommended %d want 1", report.Recommended)
+	}
+}
+
+func BenchmarkHashJob(b *testing.B) {
+	job := newBenchmarkJob(0, 1)
+	for _, backend := range hasher.Available() {
+		b.Run(backend, func(b *testing.B) {
+			h := newHasher(b, backend)
+			for i := 0; i < b.N; i++ {
+				hashJob(context.Background(), job, h, nil)
+			}
+		})
+	}
+}
+
+func BenchmarkHashJobReference(b *testing.B) {
+	job := newBenchmarkJob(0, 1)
+	for i := 0; i < b.N; i++ {
+		hashJobReference(job, nil)
+	}
+}
+
+func BenchmarkHashJobLongPrefix(b *testing.B) {
+	job := longPrefixJob()
+	for _, backend := range hasher.Available() {
+		b.Run(backend, func(b *testing.B) {
+			h := newHasher(b, backend)
+			for i := 0; i < b.N; i++ {
+				hashJob(context.Background(), job, h, nil)
+			}
+		})
+	}
+}
+
+func BenchmarkHashJobLongPrefixReference(b *testing.B) {
+	job := longPrefixJob()
+	for i := 0; i < b.N; i++ {
+		hashJobReference(job, nil)
+	}
+}
+
+func BenchmarkTargetMatcher(b *testing.B) {
+	sum := sha256.Sum256([]byte(benchMinerSeed))
+	m := newTargetMatcher(benchTargetString[:benchTargetChars], benchDiff/10+1-benchPoolDepth)
+	for i := 0; i < b.N; i++ {
+		m.match(&sum)
+	}
+}
+
+func BenchmarkTargetContains(b *testing.B) {
+	sum := sha256.Sum256([]byte(benchMinerSeed))
+	encoded := make([]byte, 64)
+	target := benchTargetString[:benchDiff/10+1-benchPoolDepth]
+	for i := 0; i < b.N; i++ {
+		hex.Encode(encoded, sum[:])
+		strings.Contains(BytesToString(encoded), target)
+	}
+}
@@ -1,5 +1,7 @@
 package miner
 
+import "time"
+
 type Opts struct {
 	Cpu            int
 	IpAddr         string
@@ -10,4 +12,43 @@ type Opts struct {
 	ShowPop        bool
 	StatusInterval int
 	ExitOnRetry    bool
+	ApiListen      string
+	MetricsListen  string
+
+	// RateWindows are the periods hash rates are averaged over. Empty
+	// means DefaultRateWindows
+	RateWindows []time.Duration
+
+	// Payments decides when a vested balance is requested
+	Payments PaymentPolicy
+
+	// DataDir is where the miner keeps payments.csv and, unless it is
+	// given as an absolute path, StatsFile. Empty means the current
+	// directory
+	DataDir string
+
+	// StatsFile is where a record of every block mined on is kept, see
+	// StatsDB. Empty disables it
+	StatsFile string
+
+	// MaxSolutionErrors is how many solutions of a worker may fail
+	// verification before the worker is stopped. Zero only warns
+	MaxSolutionErrors int
+
+	// ShareRule is how the pool is expected to credit accepted steps
+	ShareRule ShareRule
+
+	// Hasher is the SHA-256 backend to mine with, see hasher.Names. Empty
+	// or "auto" picks the fastest one
+	Hasher string
+
+	// Pools to fail over to, in order of preference. When empty, the pool
+	// given by IpAddr, IpPort and PoolPw is the only one used
+	Pools            []PoolEndpoint
+	FailoverAfter    int
+	FailbackInterval time.Duration
+
+	// Dial is how pools given by IpAddr or Pools are dialed, unless the
+	// pool registry says otherwise
+	Dial DialOpts
 }
@@ -1,9 +1,7 @@
 package miner
 
 import (
-	"log"
-	"strconv"
-	"strings"
+	"github.com/Noso-Project/noso-go/internal/logging"
 )
 
 const (
@@ -15,91 +13,91 @@ const (
 	STATUS     = "STATUS"
 	STEPOK     = "STEPOK"
 	STEPFAIL   = "STEPFAIL"
-)
 
-func Parse(comms *Comms, poolIp string, wallet string, block int, resp string) {
-	if resp == "" {
-		log.Println("Got an empty response")
-		return
-	}
-	r := strings.Split(resp, " ")
+	// SeedSlotPrefix marks the extra JOINOK field a noso-go proxy uses to
+	// tell each miner which share of the seed space to hash
+	SeedSlotPrefix = "NGSLOT="
+)
 
-	switch r[0] {
-	case JOINOK:
-		comms.PoolAddr <- r[1]
-		comms.MinerSeed <- r[2]
-		poolData(comms, r, 2)
-		comms.Joined <- struct{}{}
-	case PASSFAILED:
-		log.Println("Incorrect pool password")
-	case PAYMENTOK:
-		LogPaymentResp(r, poolIp)
-	case PONG:
-		comms.Pong <- struct{}{}
-		comms.PoolHashRate <- r[9] + "000"
-	case POOLSTEPS:
-		poolData(comms, r, 0)
-	case STEPOK:
-		shares, err := strconv.Atoi(r[1])
-		if err != nil {
-			log.Printf("Had trouble parsing the shares from STEPOK message: %v\n", err)
+// parseLines parses the lines the client receives one at a time, in the
+// order the pool sent them, until stop is closed. The pool answers steps
+// in the order they were sent and every message with pool data replaces
+// the last, so handling lines concurrently would match answers with the
+// wrong steps and let an older pool state win
+func parseLines(comms *Comms, client *TcpClient, stop <-chan struct{}) {
+	for {
+		select {
+		case resp := <-client.RecvChan:
+			Parse(comms, client.poolAddress(), resp, stop)
+		case <-stop:
+			return
 		}
-		comms.StepSolved <- shares
-	case STEPFAIL:
-		comms.StepFailed <- 1
-	case STATUS:
-		comms.PoolStatus <- NewPoolStatus(r[1:])
-	default:
-		log.Printf("Uknown response code: %s\n", r[0])
 	}
 }
 
-func poolData(comms *Comms, resp []string, offset int) {
-	block, err := strconv.Atoi(resp[2+offset])
-	if err != nil {
-		log.Printf("Error converting target block: %s\n", resp[2+offset])
-	} else {
-		comms.Block <- block
-	}
-
-	comms.TargetString <- resp[3+offset]
-
-	targetChars, err := strconv.Atoi(resp[4+offset])
+// Parse handles a line from the pool. Lines that can't be parsed are
+// logged and dropped. Answers to steps and payments wait for their reader
+// until stop is closed, everything else is dropped when nobody is waiting
+// for it
+func Parse(comms *Comms, poolIp string, resp string, stop <-chan struct{}) {
+	msg, err := ParseMessage(resp)
 	if err != nil {
-		log.Printf("Error converting target chars: %s\n", resp[4+offset])
-	} else {
-		comms.TargetChars <- targetChars
-	}
-
-	step, err := strconv.Atoi(resp[5+offset])
-	if err != nil {
-		log.Printf("Error converting target chars: %s\n", resp[5+offset])
-	} else {
-		comms.Step <- step
-	}
-
-	diff, err := strconv.Atoi(resp[6+offset])
-	if err != nil {
-		log.Printf("Error converting target chars: %s\n", resp[6+offset])
-	} else {
-		comms.Diff <- diff
+		logging.Warnf("Ignoring pool response %q: %v\n", resp, err)
+		return
 	}
 
-	comms.Balance <- resp[7+offset]
-
-	blocksTillPayment, err := strconv.Atoi(resp[8+offset])
-	if err != nil {
-		log.Printf("Error converting target chars: %s\n", resp[8+offset])
-	} else {
-		comms.BlocksTillPayment <- blocksTillPayment
+	switch m := msg.(type) {
+	case JoinOK:
+		// A new session, nothing from the previous one carries over
+		comms.State.Update(func(state *PoolState) {
+			session := state.Session
+			*state = emptyPoolState
+			state.Session = session + 1
+			state.PoolAddr = m.PoolAddr
+			state.MinerSeed = m.MinerSeed
+			state.SeedSlot = m.SeedSlot
+			m.PoolData.apply(state)
+		})
+		signal(comms.Joined)
+	case PassFailed:
+		// The client logs it, hangs up and decides when to try again
+	case PaymentOK:
+		LogPaymentResp(m, poolIp)
+		select {
+		case comms.Payment <- m:
+		case <-stop:
+		}
+	case Pong:
+		signal(comms.Pong)
+		if m.Data != nil {
+			comms.State.Update(func(state *PoolState) { state.PoolHashRate = m.Data.PoolHashRate * 1000 })
+		}
+	case PoolSteps:
+		comms.State.Update(func(state *PoolState) { m.PoolData.apply(state) })
+	case StepOK:
+		select {
+		case comms.StepSolved <- m.Shares:
+		case <-stop:
+		}
+	case StepFail:
+		select {
+		case comms.StepFailed <- 1:
+		case <-stop:
+		}
+	case PoolStatus:
+		// Only asked for outside of mining, the pool also sends it unasked
+		select {
+		case comms.PoolStatus <- m:
+		default:
+		}
 	}
+}
 
-	comms.PoolHashRate <- resp[9+offset] + "000"
-
-	poolDepth, err := strconv.Atoi(resp[10+offset])
-	if err != nil {
-		log.Printf("Error converting target chars: %s\n", resp[10+offset])
-	} else {
-		comms.PoolDepth <- poolDepth
+// signal wakes whoever waits on c without blocking. A signal that is
+// still pending covers this one
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
 	}
 }
@@ -1,15 +1,59 @@
 package miner
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
 )
 
 const CSVHEADER = "Transaction Time,Pool IP Address,Wallet Address,Request Or Response,Block,Payment Amount,Order Id\n"
 
+// PaymentsFile is the name of the file payment requests and responses
+// are logged to, in the data directory
+const PaymentsFile = "payments.csv"
+
+var (
+	paymentsMu   sync.Mutex
+	paymentsPath = PaymentsFile
+)
+
+// DataPath returns the path of the file name in the data directory dir.
+// Absolute names, and any name when dir is empty, are returned as is
+func DataPath(dir, name string) string {
+	if dir == "" || name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// useDataDir creates the data directory of opts, and logs payments there
+func useDataDir(opts *Opts) error {
+	if opts.DataDir != "" {
+		if err := os.MkdirAll(opts.DataDir, 0755); err != nil {
+			return err
+		}
+	}
+
+	// Older versions logged payments to the current directory
+	path := DataPath(opts.DataDir, PaymentsFile)
+	abs, _ := filepath.Abs(path)
+	old, _ := filepath.Abs(PaymentsFile)
+	if abs != old && !fileExists(abs) && fileExists(old) {
+		logging.Infof("Payments are now logged to %s, %s is no longer written to\n", abs, old)
+	}
+
+	paymentsMu.Lock()
+	paymentsPath = path
+	paymentsMu.Unlock()
+	return nil
+}
+
 func CreateLogPaymentsFile() {
 	write("")
 }
@@ -28,47 +72,21 @@ func LogPaymentReq(poolIp string, wallet string, block int, amount string) {
 	write(writeStr)
 }
 
-func LogPaymentResp(paymentMsg []string, poolIp string) {
-	var (
-		now      time.Time
-		amount   string
-		orderId  string
-		writeStr string
-		wallet   string
-	)
-
-	if len(paymentMsg) < 3 {
-		log.Println("Error: noso-go requires that the pool use Noso Wallet 0.2.0 N or greater")
-		return
-	}
-
-	// Example PAYMENTOK response
-	// <- PAYMENTOK 1618891646 POOLIP Nm6jiGfRg7DVHHMfbMJL9CT1DtkUCF 2 5833 1.48153045 OR60v3w4j25pkl7mp2aaxa6l7g7hxqsdlfu86fkueh11tfyqg03z
-	// <- PAYMENTOK [TIMESTAMP] POOLIP [MINERADDRESS] 2 [BLOCK] [AMOUNT] [ORDERID]
-	// Format payment into X.YYY format
-
-	wallet = paymentMsg[3]
-	block, err := strconv.Atoi(paymentMsg[5])
-	if err != nil {
-		log.Println("Error converting block string in PAYMENTOK response: ", err)
-	}
-	amount = paymentMsg[6]
-	orderId = paymentMsg[7]
-
-	now = time.Now()
-
-	amount = parseAmount(amount)
-
-	writeStr = fmt.Sprintf("%s,%s,%s,%s,%d,%s,%s\n", now.Format(time.RFC3339), poolIp, wallet, "Payment Response", block, amount, orderId)
+func LogPaymentResp(payment PaymentOK, poolIp string) {
+	amount := parseAmount(payment.Amount)
+	writeStr := fmt.Sprintf("%s,%s,%s,%s,%d,%s,%s\n", time.Now().Format(time.RFC3339), poolIp, payment.Wallet, "Payment Response", payment.Block, amount, payment.OrderId)
 
 	write(writeStr)
 }
 
 func write(writeStr string) {
-	f, err := os.OpenFile("payments.csv", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	paymentsMu.Lock()
+	defer paymentsMu.Unlock()
+
+	f, err := os.OpenFile(paymentsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 
 	if err != nil {
-		log.Printf("Trouble opening payments.csv: %s\n", err)
+		logging.Errorf("Trouble opening %s: %s\n", paymentsPath, err)
 		return
 	}
 
@@ -79,12 +97,12 @@ func write(writeStr string) {
 	s, err := f.Stat()
 
 	if err != nil {
-		log.Printf("Trouble getting file stats for payments.csv: %s\n", err)
+		logging.Errorf("Trouble getting file stats for %s: %s\n", paymentsPath, err)
 	} else {
 		size := s.Size()
 		if size == 0 {
 			if _, err := f.WriteString(CSVHEADER); err != nil {
-				log.Printf("Trouble header to payments.csv: %s\n", err)
+				logging.Errorf("Trouble header to %s: %s\n", paymentsPath, err)
 			}
 		}
 	}
@@ -95,6 +113,74 @@ func write(writeStr string) {
 	}
 
 	if _, err := f.WriteString(writeStr); err != nil {
-		log.Printf("Trouble writing to payments.csv: %s\n", err)
+		logging.Errorf("Trouble writing to %s: %s\n", paymentsPath, err)
+	}
+}
+
+// RequestPayment asks the pool for the balance of opts.Wallets[0] outside
+// of a mining session, and waits up to timeout for the payment. The pool's
+// STATUS is used to log the balance asked for. Both the request and the
+// payment are logged to the payments file in opts.DataDir
+func RequestPayment(opts *Opts, timeout time.Duration) (PaymentOK, error) {
+	if len(opts.Wallets) == 0 {
+		return PaymentOK{}, errors.New("no wallet to pay to")
+	}
+	if err := useDataDir(opts); err != nil {
+		return PaymentOK{}, err
+	}
+	wallet := opts.Wallets[0]
+
+	logging.Infof("Connecting to %s:%d\n", opts.IpAddr, opts.IpPort)
+	comms := NewComms()
+	client := NewTcpClient(opts, comms, NewStats(), false, false)
+	defer client.Close(time.Second)
+
+	requested := false
+	request := func(balance string) {
+		client.SendChan <- PaymentCmd{}.String()
+		LogPaymentReq(opts.IpAddr, wallet, 0, balance)
+		requested = true
 	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go parseLines(comms, client, stop)
+
+	deadline := time.After(timeout)
+	noStatus := time.After(5 * time.Second)
+	client.SendChan <- StatusCmd{}.String()
+	for {
+		select {
+		case status := <-comms.PoolStatus:
+			if requested {
+				continue
+			}
+			balance := "0"
+			for _, m := range status.Miners {
+				if m.Address != wallet {
+					continue
+				}
+				balance = m.Balance
+				logging.Infof("Balance of %s: %s (blocks till payment: %s)\n", wallet, m.BalanceHR, m.BlocksTillPayment)
+				if btp, _ := strconv.Atoi(m.BlocksTillPayment); btp <= 0 {
+					logging.Warnf("The balance is still vesting, the pool may not pay it yet\n")
+				}
+			}
+			request(balance)
+		case <-noStatus:
+			if !requested {
+				logging.Warnf("No status from the pool, requesting payment without knowing the balance\n")
+				request("0")
+			}
+		case payment := <-comms.Payment:
+			return payment, nil
+		case <-deadline:
+			return PaymentOK{}, fmt.Errorf("no payment from the pool within %s", timeout)
+		}
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
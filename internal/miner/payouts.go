@@ -0,0 +1,298 @@
+package miner
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
+)
+
+// Kinds of rows in the payments file
+const (
+	PaymentRequest  = "Payment Request"
+	PaymentResponse = "Payment Response"
+)
+
+// NosoAmount is an amount of Noso in its smallest unit, 1e-8 Noso
+type NosoAmount int64
+
+// ParseNosoAmount parses an amount like "1.48153045", or a raw number of
+// units like the pool sends
+func ParseNosoAmount(s string) (NosoAmount, error) {
+	whole, frac := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+		if len(frac) == 0 || len(frac) > 8 {
+			return 0, fmt.Errorf("%q is not a Noso amount", s)
+		}
+		frac += strings.Repeat("0", 8-len(frac))
+	} else {
+		frac = "00000000"
+		if n, err := strconv.ParseUint(s, 10, 63); err == nil {
+			return NosoAmount(n), nil
+		}
+	}
+	w, err := strconv.ParseUint(whole, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a Noso amount", s)
+	}
+	f, err := strconv.ParseUint(frac, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a Noso amount", s)
+	}
+	return NosoAmount(w*1e8 + f), nil
+}
+
+// String formats the amount like the payments file does, e.g. "1.48153045"
+func (a NosoAmount) String() string {
+	return fmt.Sprintf("%d.%08d", a/1e8, a%1e8)
+}
+
+// MarshalJSON writes the amount as a string, so no precision is lost
+func (a NosoAmount) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(a.String())), nil
+}
+
+// PaymentRecord is a row of the payments file
+type PaymentRecord struct {
+	Time    time.Time
+	Pool    string
+	Wallet  string
+	Kind    string
+	Block   int
+	Amount  NosoAmount
+	OrderId string
+}
+
+// ReadPayments reads the payments file at path. Rows that can't be
+// parsed are skipped with a warning
+func ReadPayments(path string) ([]PaymentRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	var (
+		records []PaymentRecord
+		n       int
+	)
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		n++
+		if err != nil {
+			logging.Warnf("Skipping line %d of %s: %v\n", n, path, err)
+			continue
+		}
+		if n == 1 && row[0] == "Transaction Time" {
+			continue
+		}
+		rec, err := parsePaymentRow(row)
+		if err != nil {
+			logging.Warnf("Skipping line %d of %s: %v\n", n, path, err)
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+func parsePaymentRow(row []string) (PaymentRecord, error) {
+	if len(row) != 7 {
+		return PaymentRecord{}, fmt.Errorf("got %d fields want 7", len(row))
+	}
+	t, err := time.Parse(time.RFC3339, row[0])
+	if err != nil {
+		return PaymentRecord{}, err
+	}
+	if row[3] != PaymentRequest && row[3] != PaymentResponse {
+		return PaymentRecord{}, fmt.Errorf("unknown row kind %q", row[3])
+	}
+	block, err := strconv.Atoi(row[4])
+	if err != nil {
+		return PaymentRecord{}, fmt.Errorf("bad block %q", row[4])
+	}
+	amount, err := ParseNosoAmount(row[5])
+	if err != nil {
+		return PaymentRecord{}, err
+	}
+	return PaymentRecord{
+		Time:    t,
+		Pool:    row[1],
+		Wallet:  row[2],
+		Kind:    row[3],
+		Block:   block,
+		Amount:  amount,
+		OrderId: row[6],
+	}, nil
+}
+
+// Payout statuses
+const (
+	PayoutPaid        = "paid"
+	PayoutUnanswered  = "unanswered"
+	PayoutUnrequested = "unrequested"
+)
+
+// Payout is a payment from a pool to a wallet, together with the requests
+// made for it. The miner asks again every 10 minutes until it is paid, so
+// one payout can answer several requests
+type Payout struct {
+	Status   string `json:"status"`
+	Pool     string `json:"pool"`
+	Wallet   string `json:"wallet"`
+	Requests int    `json:"requests"`
+
+	// The request the payment answered, or the first of the unanswered
+	// ones. Unset for payments that weren't requested
+	Requested      *time.Time `json:"requested,omitempty"`
+	RequestBlock   int        `json:"request_block,omitempty"`
+	RequestBalance NosoAmount `json:"request_balance"`
+
+	// The pool's response. Unset for unanswered requests
+	Paid    *time.Time `json:"paid,omitempty"`
+	Block   int        `json:"block,omitempty"`
+	Amount  NosoAmount `json:"amount"`
+	OrderId string     `json:"order_id,omitempty"`
+}
+
+// Time returns when the payout was made, or when it was first requested
+// if it never was
+func (p Payout) Time() time.Time {
+	if p.Paid != nil {
+		return *p.Paid
+	}
+	if p.Requested != nil {
+		return *p.Requested
+	}
+	return time.Time{}
+}
+
+// ReconcilePayments matches every payment response with the requests for
+// it. A response answers the open requests of its wallet at its pool,
+// preferring the one for the same block. Requests no response answered
+// are collected in one unanswered Payout per wallet and pool
+func ReconcilePayments(records []PaymentRecord) []Payout {
+	type key struct{ pool, wallet string }
+	open := map[key][]PaymentRecord{}
+	payouts := []Payout{}
+
+	for _, rec := range records {
+		k := key{rec.Pool, rec.Wallet}
+		if rec.Kind == PaymentRequest {
+			open[k] = append(open[k], rec)
+			continue
+		}
+
+		paid := rec.Time
+		p := Payout{
+			Status:  PayoutPaid,
+			Pool:    rec.Pool,
+			Wallet:  rec.Wallet,
+			Paid:    &paid,
+			Block:   rec.Block,
+			Amount:  rec.Amount,
+			OrderId: rec.OrderId,
+		}
+		reqs := open[k]
+		if len(reqs) == 0 {
+			p.Status = PayoutUnrequested
+			payouts = append(payouts, p)
+			continue
+		}
+		req := reqs[len(reqs)-1]
+		for _, r := range reqs {
+			if r.Block == rec.Block {
+				req = r
+				break
+			}
+		}
+		requested := req.Time
+		p.Requests = len(reqs)
+		p.Requested = &requested
+		p.RequestBlock = req.Block
+		p.RequestBalance = req.Amount
+		payouts = append(payouts, p)
+		delete(open, k)
+	}
+
+	for _, reqs := range open {
+		requested := reqs[0].Time
+		payouts = append(payouts, Payout{
+			Status:         PayoutUnanswered,
+			Pool:           reqs[0].Pool,
+			Wallet:         reqs[0].Wallet,
+			Requests:       len(reqs),
+			Requested:      &requested,
+			RequestBlock:   reqs[0].Block,
+			RequestBalance: reqs[len(reqs)-1].Amount,
+		})
+	}
+
+	sort.SliceStable(payouts, func(i, j int) bool { return payouts[i].Time().Before(payouts[j].Time()) })
+	return payouts
+}
+
+// PayoutTotals adds up a set of Payouts
+type PayoutTotals struct {
+	Key        string     `json:"key,omitempty"`
+	Payouts    int        `json:"payouts"`
+	Paid       NosoAmount `json:"paid"`
+	Requests   int        `json:"requests"`
+	Unanswered int        `json:"unanswered"`
+}
+
+// PayoutGroups are the groupings SumPayouts supports
+var PayoutGroups = []string{GroupTotal, GroupWallet, GroupPool, GroupMonth}
+
+// SumPayouts adds up payouts by group, in the order each group was first
+// seen. Months are in local time
+func SumPayouts(payouts []Payout, group string) ([]PayoutTotals, error) {
+	var key func(Payout) string
+	switch group {
+	case GroupTotal, "":
+		key = func(Payout) string { return "" }
+	case GroupMonth:
+		key = func(p Payout) string { return p.Time().Local().Format("2006-01") }
+	case GroupPool:
+		key = func(p Payout) string { return p.Pool }
+	case GroupWallet:
+		key = func(p Payout) string { return p.Wallet }
+	default:
+		return nil, fmt.Errorf("unknown grouping %q", group)
+	}
+
+	totals := []PayoutTotals{}
+	index := map[string]int{}
+	for _, p := range payouts {
+		k := key(p)
+		i, ok := index[k]
+		if !ok {
+			i = len(totals)
+			index[k] = i
+			totals = append(totals, PayoutTotals{Key: k})
+		}
+		t := &totals[i]
+		t.Requests += p.Requests
+		if p.Status == PayoutUnanswered {
+			t.Unanswered += p.Requests
+			continue
+		}
+		t.Payouts++
+		t.Paid += p.Amount
+	}
+	return totals, nil
+}
@@ -0,0 +1,152 @@
+package miner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPayments = CSVHEADER + `2021-05-01T10:00:00Z,1.2.3.4,N1,Payment Request,100,1.50000000,
+2021-05-10T10:10:00Z,1.2.3.4,N1,Payment Request,101,1.60000000,
+2021-05-10T10:12:00Z,1.2.3.4,N1,Payment Response,101,1.60000000,OR1abc
+2021-05-10T10:20:00Z,5.6.7.8,N2,Payment Request,102,0.25000000,
+not a row
+2021-06-02T09:00:00Z,1.2.3.4,N1,Payment Response,4000,125000000,OR2def
+2021-06-03T09:00:00Z,1.2.3.4,N1,Payment Request,4100,2.00000000,
+2021-06-03T09:05:00Z,1.2.3.4,N1,Payment Response,4101,1.99000000,OR3ghi
+`
+
+func tempPayments(t *testing.T, content string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "noso-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, PaymentsFile)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseNosoAmount(t *testing.T) {
+	tests := map[string]NosoAmount{
+		"1.48153045":  148153045,
+		"148153045":   148153045,
+		"0.5":         50000000,
+		"0":           0,
+		"12.00000001": 1200000001,
+	}
+	for s, want := range tests {
+		got, err := ParseNosoAmount(s)
+		if err != nil || got != want {
+			t.Errorf("%q: got %d, %v want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "1.", "1.123456789", "-1", "1.2.3", "abc"} {
+		if _, err := ParseNosoAmount(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+
+	if got := NosoAmount(148153045).String(); got != "1.48153045" {
+		t.Errorf("got %s", got)
+	}
+	if b, _ := json.Marshal(NosoAmount(5)); string(b) != `"0.00000005"` {
+		t.Errorf("got %s", b)
+	}
+}
+
+func TestReconcilePayments(t *testing.T) {
+	records, err := ReadPayments(tempPayments(t, testPayments))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 7 {
+		t.Fatalf("got %d records want 7", len(records))
+	}
+
+	payouts := ReconcilePayments(records)
+	if len(payouts) != 4 {
+		t.Fatalf("got %d payouts want 4: %+v", len(payouts), payouts)
+	}
+
+	// Two requests answered by one response, matched on the block
+	p := payouts[0]
+	if p.Status != PayoutPaid || p.Requests != 2 || p.RequestBlock != 101 || p.Block != 101 || p.Amount != 160000000 || p.OrderId != "OR1abc" {
+		t.Errorf("unexpected first payout: %+v", p)
+	}
+
+	// Never answered
+	p = payouts[1]
+	if p.Status != PayoutUnanswered || p.Wallet != "N2" || p.Pool != "5.6.7.8" || p.Requests != 1 || p.Paid != nil || p.RequestBalance != 25000000 {
+		t.Errorf("unexpected second payout: %+v", p)
+	}
+
+	// Paid without a request, e.g. by an older version
+	p = payouts[2]
+	if p.Status != PayoutUnrequested || p.Requested != nil || p.Amount != 125000000 {
+		t.Errorf("unexpected third payout: %+v", p)
+	}
+
+	// Paid in a later block than requested
+	p = payouts[3]
+	if p.Status != PayoutPaid || p.RequestBlock != 4100 || p.Block != 4101 || p.Amount != 199000000 {
+		t.Errorf("unexpected fourth payout: %+v", p)
+	}
+
+	totals, err := SumPayouts(payouts, GroupTotal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || totals[0].Payouts != 3 || totals[0].Paid != 484000000 || totals[0].Requests != 4 || totals[0].Unanswered != 1 {
+		t.Errorf("unexpected totals: %+v", totals)
+	}
+
+	for group, want := range map[string][]string{
+		GroupWallet: {"N1", "N2"},
+		GroupPool:   {"1.2.3.4", "5.6.7.8"},
+		GroupMonth:  {"2021-05", "2021-06"},
+	} {
+		totals, err := SumPayouts(payouts, group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(totals) != len(want) {
+			t.Errorf("%s: got %+v want %v", group, totals, want)
+			continue
+		}
+		for i := range want {
+			if totals[i].Key != want[i] {
+				t.Errorf("%s: got %+v want %v", group, totals, want)
+				break
+			}
+		}
+	}
+
+	if _, err := SumPayouts(payouts, GroupBlock); err == nil {
+		t.Error("no error for an unsupported grouping")
+	}
+}
+
+func TestDataPath(t *testing.T) {
+	abs := filepath.Join(os.TempDir(), "stats.jsonl")
+	tests := []struct {
+		dir, name, want string
+	}{
+		{"", PaymentsFile, PaymentsFile},
+		{"data", PaymentsFile, filepath.Join("data", PaymentsFile)},
+		{"data", abs, abs},
+		{"data", "", ""},
+	}
+	for _, tt := range tests {
+		if got := DataPath(tt.dir, tt.name); got != tt.want {
+			t.Errorf("DataPath(%q, %q): got %q want %q", tt.dir, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package miner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultPaymentInterval is how long the miner waits between payment
+// requests for the same wallet
+const DefaultPaymentInterval = 10 * time.Minute
+
+// PaymentWindow is a time of day, in local time, payments may be
+// requested in. A window that ends before it starts spans midnight
+type PaymentWindow struct {
+	Start, End time.Duration // since midnight
+}
+
+// ParsePaymentWindow parses a window like "02:00-06:00"
+func ParsePaymentWindow(s string) (PaymentWindow, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return PaymentWindow{}, fmt.Errorf("%q is not a window like 02:00-06:00", s)
+	}
+	var w PaymentWindow
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return PaymentWindow{}, fmt.Errorf("%q is not a window like 02:00-06:00", s)
+		}
+		d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		if i == 0 {
+			w.Start = d
+		} else {
+			w.End = d
+		}
+	}
+	if w.Start == w.End {
+		return PaymentWindow{}, fmt.Errorf("window %q is empty", s)
+	}
+	return w, nil
+}
+
+func (w PaymentWindow) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", d/time.Hour, d%time.Hour/time.Minute)
+	}
+	return format(w.Start) + "-" + format(w.End)
+}
+
+// contains reports whether t is in the window
+func (w PaymentWindow) contains(t time.Time) bool {
+	t = t.Local()
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start < w.End {
+		return d >= w.Start && d < w.End
+	}
+	return d >= w.Start || d < w.End
+}
+
+// PaymentPolicy decides when the miner asks the pool to pay out a vested
+// balance
+type PaymentPolicy struct {
+	// Disabled turns automatic payment requests off. 'noso-go payment
+	// request' still works
+	Disabled bool
+
+	// MinBalance is the smallest balance worth asking for
+	MinBalance NosoAmount
+
+	// Interval is the least time between requests for a wallet. Zero
+	// means DefaultPaymentInterval
+	Interval time.Duration
+
+	// Windows are the times of day requests may be made in. Empty means
+	// any time
+	Windows []PaymentWindow
+}
+
+// due reports whether to request payment of balance now, given the
+// pool's blocks till payment and when payment was last requested. When a
+// vested balance isn't requested because of the policy, reason says why
+func (p PaymentPolicy) due(now time.Time, balance NosoAmount, blocksTillPayment int, last time.Time) (ok bool, reason string) {
+	// Nothing to ask for, or still vesting
+	if balance == 0 || blocksTillPayment <= 0 {
+		return false, ""
+	}
+
+	if p.Disabled {
+		return false, "automatic payment requests are disabled"
+	}
+	if balance < p.MinBalance {
+		return false, fmt.Sprintf("balance %s is below the minimum of %s", balance, p.MinBalance)
+	}
+
+	interval := p.Interval
+	if interval <= 0 {
+		interval = DefaultPaymentInterval
+	}
+	if !last.IsZero() && now.Sub(last) < interval {
+		return false, ""
+	}
+
+	if len(p.Windows) == 0 {
+		return true, ""
+	}
+	windows := make([]string, len(p.Windows))
+	for i, w := range p.Windows {
+		if w.contains(now) {
+			return true, ""
+		}
+		windows[i] = w.String()
+	}
+	return false, fmt.Sprintf("outside the payment window(s) %s", strings.Join(windows, ", "))
+}
+
+// lastPaymentRequests returns when payment was last requested for every
+// wallet in the payments file, so the policy carries over restarts
+func lastPaymentRequests() map[string]time.Time {
+	paymentsMu.Lock()
+	path := paymentsPath
+	paymentsMu.Unlock()
+
+	last := map[string]time.Time{}
+	if !fileExists(path) {
+		return last
+	}
+	records, err := ReadPayments(path)
+	if err != nil {
+		return last
+	}
+	for _, rec := range records {
+		if rec.Kind == PaymentRequest && rec.Time.After(last[rec.Wallet]) {
+			last[rec.Wallet] = rec.Time
+		}
+	}
+	return last
+}
@@ -0,0 +1,100 @@
+package miner
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/mockpool"
+)
+
+func TestParsePaymentWindow(t *testing.T) {
+	w, err := ParsePaymentWindow("22:30-02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Start != 22*time.Hour+30*time.Minute || w.End != 2*time.Hour || w.String() != "22:30-02:00" {
+		t.Errorf("got %+v (%s)", w, w)
+	}
+
+	for _, s := range []string{"", "02:00", "02:00-", "2am-4am", "25:00-26:00", "03:00-03:00"} {
+		if _, err := ParsePaymentWindow(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestPaymentPolicy(t *testing.T) {
+	day := time.Date(2021, 5, 10, 0, 0, 0, 0, time.Local)
+	at := func(h, m int) time.Time { return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+	night, _ := ParsePaymentWindow("23:00-01:00")
+	morning, _ := ParsePaymentWindow("06:00-07:00")
+
+	tests := []struct {
+		name       string
+		policy     PaymentPolicy
+		now        time.Time
+		balance    NosoAmount
+		btp        int
+		last       time.Time
+		due        bool
+		withReason bool
+	}{
+		{"default", PaymentPolicy{}, at(12, 0), 100, 5, time.Time{}, true, false},
+		{"no balance", PaymentPolicy{}, at(12, 0), 0, 5, time.Time{}, false, false},
+		{"vesting", PaymentPolicy{}, at(12, 0), 100, -3, time.Time{}, false, false},
+		{"recently requested", PaymentPolicy{}, at(12, 0), 100, 5, at(11, 55), false, false},
+		{"requested a while ago", PaymentPolicy{}, at(12, 0), 100, 5, at(11, 50), true, false},
+		{"disabled", PaymentPolicy{Disabled: true}, at(12, 0), 100, 5, time.Time{}, false, true},
+		{"below minimum", PaymentPolicy{MinBalance: 1e8}, at(12, 0), 5e7, 5, time.Time{}, false, true},
+		{"at minimum", PaymentPolicy{MinBalance: 1e8}, at(12, 0), 1e8, 5, time.Time{}, true, false},
+		{"once a day", PaymentPolicy{Interval: 24 * time.Hour}, at(12, 0), 100, 5, at(0, 0).Add(-time.Hour), false, false},
+		{"a day later", PaymentPolicy{Interval: 24 * time.Hour}, at(12, 0), 100, 5, at(0, 0).Add(-12 * time.Hour), true, false},
+		{"outside windows", PaymentPolicy{Windows: []PaymentWindow{night, morning}}, at(12, 0), 100, 5, time.Time{}, false, true},
+		{"in window", PaymentPolicy{Windows: []PaymentWindow{night, morning}}, at(6, 30), 100, 5, time.Time{}, true, false},
+		{"in window past midnight", PaymentPolicy{Windows: []PaymentWindow{night, morning}}, at(0, 30), 100, 5, time.Time{}, true, false},
+		{"window end excluded", PaymentPolicy{Windows: []PaymentWindow{morning}}, at(7, 0), 100, 5, time.Time{}, false, true},
+	}
+	for _, tt := range tests {
+		due, reason := tt.policy.due(tt.now, tt.balance, tt.btp, tt.last)
+		if due != tt.due || (reason != "") != tt.withReason {
+			t.Errorf("%s: got %v, %q", tt.name, due, reason)
+		}
+	}
+}
+
+func TestRequestPayment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "noso-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, pool := startMockPool(t, mockpool.DefaultConfig())
+	opts := &Opts{IpAddr: pool.Address, IpPort: pool.Port, PoolPw: pool.Password, Wallets: []string{"wallet"}, DataDir: dir}
+	defer useDataDir(&Opts{})
+
+	payment, err := RequestPayment(opts, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Wallet != "wallet" || payment.OrderId == "" {
+		t.Errorf("unexpected payment: %+v", payment)
+	}
+
+	// The response is logged by another goroutine
+	var payouts []Payout
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		records, err := ReadPayments(DataPath(dir, PaymentsFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payouts = ReconcilePayments(records); len(payouts) == 1 && payouts[0].Status == PayoutPaid {
+			break
+		}
+	}
+	if len(payouts) != 1 || payouts[0].Status != PayoutPaid || payouts[0].Requests != 1 || payouts[0].OrderId != payment.OrderId {
+		t.Errorf("unexpected payouts: %+v", payouts)
+	}
+}
@@ -0,0 +1,412 @@
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrMalformed is wrapped by the errors ParseMessage returns for lines
+// with a known code but missing or invalid fields
+var ErrMalformed = errors.New("malformed message")
+
+// ErrUnknownCode is wrapped by the error ParseMessage returns for lines
+// that don't start with a known response code
+var ErrUnknownCode = errors.New("unknown response code")
+
+// Message is a parsed pool response. String returns the line the pool
+// sends for it
+type Message interface {
+	Code() string
+	String() string
+}
+
+// PoolData is the block and account data carried by JOINOK, POOLSTEPS and
+// PONG: "PoolData {block} {target} {chars} {step} {diff} {balance}
+// {blocksTillPayment} {poolHashRate} [{poolDepth}]". Older pools leave
+// out the depth
+type PoolData struct {
+	Block             int
+	TargetString      string
+	TargetChars       int
+	Step              int
+	Diff              int
+	Balance           string
+	BlocksTillPayment int
+	PoolHashRate      int64 // in kH/s, as sent by the pool
+	PoolDepth         int
+}
+
+// poolDataFields is the least number of fields in PoolData, including the
+// "PoolData" keyword
+const poolDataFields = 9
+
+func (d PoolData) String() string {
+	return fmt.Sprintf("PoolData %d %s %d %d %d %s %d %d %d",
+		d.Block, d.TargetString, d.TargetChars, d.Step, d.Diff, d.Balance,
+		d.BlocksTillPayment, d.PoolHashRate, d.PoolDepth)
+}
+
+// apply copies d into state
+func (d PoolData) apply(state *PoolState) {
+	state.Block = d.Block
+	state.TargetString = strings.ToLower(d.TargetString)
+	state.TargetChars = d.TargetChars
+	state.Step = d.Step
+	state.Diff = d.Diff
+	state.Balance = d.Balance
+	state.BlocksTillPayment = d.BlocksTillPayment
+	state.PoolHashRate = d.PoolHashRate * 1000
+	state.PoolDepth = d.PoolDepth
+}
+
+// JoinOK accepts a JOIN: "JOINOK {poolAddr} {minerSeed} PoolData ...
+// [NGSLOT={slot}/{slots}]". SeedSlot is only set by a noso-go proxy
+type JoinOK struct {
+	PoolAddr  string
+	MinerSeed string
+	PoolData
+	SeedSlot string
+}
+
+func (JoinOK) Code() string { return JOINOK }
+
+func (m JoinOK) String() string {
+	s := fmt.Sprintf("%s %s %s %s", JOINOK, m.PoolAddr, m.MinerSeed, m.PoolData)
+	if m.SeedSlot != "" {
+		s += " " + SeedSlotPrefix + m.SeedSlot
+	}
+	return s
+}
+
+// PoolSteps announces a new block or step: "POOLSTEPS PoolData ..."
+type PoolSteps struct {
+	PoolData
+}
+
+func (PoolSteps) Code() string { return POOLSTEPS }
+
+func (m PoolSteps) String() string { return POOLSTEPS + " " + m.PoolData.String() }
+
+// Pong answers a PING: "PONG [PoolData ...]". Data is nil when the pool
+// sent no pool data, like a proxy that hasn't joined its pool yet
+type Pong struct {
+	Data *PoolData
+}
+
+func (Pong) Code() string { return PONG }
+
+func (m Pong) String() string {
+	if m.Data == nil {
+		return PONG
+	}
+	return PONG + " " + m.Data.String()
+}
+
+// StepOK accepts a STEP: "STEPOK {shares}"
+type StepOK struct {
+	Shares int
+}
+
+func (StepOK) Code() string { return STEPOK }
+
+func (m StepOK) String() string { return fmt.Sprintf("%s %d", STEPOK, m.Shares) }
+
+// StepFail rejects a STEP
+type StepFail struct{}
+
+func (StepFail) Code() string { return STEPFAIL }
+
+func (StepFail) String() string { return STEPFAIL }
+
+// PassFailed rejects the pool password
+type PassFailed struct{}
+
+func (PassFailed) Code() string { return PASSFAILED }
+
+func (PassFailed) String() string { return PASSFAILED }
+
+// PaymentOK confirms a payment: "PAYMENTOK {timestamp} {pool} {wallet} 2
+// {block} {amount} {orderId}", for example
+// "PAYMENTOK 1618891646 POOLIP Nm6jiGfRg7DVHHMfbMJL9CT1DtkUCF 2 5833 1.48153045 OR60v3w4j25pkl7mp2aaxa6l7g7hxqsdlfu86fkueh11tfyqg03z"
+type PaymentOK struct {
+	Timestamp int64
+	Pool      string
+	Wallet    string
+	Kind      string
+	Block     int
+	Amount    string
+	OrderId   string
+}
+
+func (PaymentOK) Code() string { return PAYMENTOK }
+
+func (m PaymentOK) String() string {
+	return fmt.Sprintf("%s %d %s %s %s %d %s %s",
+		PAYMENTOK, m.Timestamp, m.Pool, m.Wallet, m.Kind, m.Block, m.Amount, m.OrderId)
+}
+
+func (PoolStatus) Code() string { return STATUS }
+
+func (p PoolStatus) String() string {
+	s := fmt.Sprintf("%s %s %d %d %s", STATUS, p.HashRateRaw, p.FeeRaw, p.ShareRaw, p.MinerCnt)
+	for _, m := range p.Miners {
+		s += fmt.Sprintf(" %s:%s:%s", m.Address, m.Balance, m.BlocksTillPayment)
+	}
+	return s
+}
+
+// ParseMessage parses a line from the pool. Lines that are too short or
+// have fields of the wrong type return an error wrapping ErrMalformed,
+// and nothing of them is used
+func ParseMessage(line string) (Message, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: empty line", ErrMalformed)
+	}
+
+	p := &fieldParser{code: fields[0], fields: fields}
+	var m Message
+	switch fields[0] {
+	case JOINOK:
+		m = p.joinOK()
+	case POOLSTEPS:
+		p.need(1 + poolDataFields)
+		m = PoolSteps{PoolData: p.poolData(1)}
+	case PONG:
+		m = p.pong()
+	case STEPOK:
+		p.need(2)
+		m = StepOK{Shares: p.int(1, "shares")}
+	case STEPFAIL:
+		m = StepFail{}
+	case PASSFAILED:
+		m = PassFailed{}
+	case PAYMENTOK:
+		m = p.paymentOK()
+	case STATUS:
+		m = p.poolStatus()
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCode, fields[0])
+	}
+
+	if p.err != nil {
+		return nil, p.err
+	}
+	return m, nil
+}
+
+// fieldParser reads the fields of a message, keeping the first error so
+// the parse functions can read every field and check once at the end
+type fieldParser struct {
+	code   string
+	fields []string
+	err    error
+}
+
+func (p *fieldParser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf("%w: %s %s", ErrMalformed, p.code, fmt.Sprintf(format, args...))
+	}
+}
+
+// need fails unless there are at least n fields, counting the code
+func (p *fieldParser) need(n int) {
+	if len(p.fields) < n {
+		p.fail("needs %d fields, got %d", n, len(p.fields))
+	}
+}
+
+func (p *fieldParser) str(i int, name string) string {
+	if i >= len(p.fields) {
+		p.fail("is missing %s", name)
+		return ""
+	}
+	return p.fields[i]
+}
+
+func (p *fieldParser) int64(i int, name string) int64 {
+	s := p.str(i, name)
+	if p.err != nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		p.fail("has a bad %s %q", name, s)
+	}
+	return n
+}
+
+func (p *fieldParser) int(i int, name string) int {
+	s := p.str(i, name)
+	if p.err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		p.fail("has a bad %s %q", name, s)
+	}
+	return n
+}
+
+// poolData reads the PoolData fields starting at field i
+func (p *fieldParser) poolData(i int) PoolData {
+	d := PoolData{
+		Block:             p.int(i+1, "block"),
+		TargetString:      p.str(i+2, "target"),
+		TargetChars:       p.int(i+3, "target chars"),
+		Step:              p.int(i+4, "step"),
+		Diff:              p.int(i+5, "diff"),
+		Balance:           p.str(i+6, "balance"),
+		BlocksTillPayment: p.int(i+7, "blocks till payment"),
+		PoolHashRate:      p.int64(i+8, "pool hash rate"),
+	}
+	if i+9 < len(p.fields) && !strings.HasPrefix(p.fields[i+9], SeedSlotPrefix) {
+		d.PoolDepth = p.int(i+9, "pool depth")
+	}
+	if p.err != nil {
+		return d
+	}
+
+	// The miner slices the target by these, so they must be checked here
+	if !isHex(d.TargetString) {
+		p.fail("has a bad target %q", d.TargetString)
+	}
+	if d.TargetChars < 1 || d.TargetChars > len(d.TargetString) {
+		p.fail("has %d target chars for a %d char target", d.TargetChars, len(d.TargetString))
+	}
+	// Blocks till payment is negative while the balance is vesting
+	if d.Block < 0 || d.Step < 0 || d.Diff < 0 || d.PoolDepth < 0 {
+		p.fail("has a negative block, step, diff or depth")
+	}
+	return d
+}
+
+func (p *fieldParser) joinOK() JoinOK {
+	p.need(3 + poolDataFields)
+	m := JoinOK{
+		PoolAddr:  p.str(1, "pool address"),
+		MinerSeed: p.str(2, "miner seed"),
+		PoolData:  p.poolData(3),
+	}
+	if p.err == nil && len(m.MinerSeed) < 3 {
+		p.fail("has a short miner seed %q", m.MinerSeed)
+	}
+	if extra := p.fields[len(p.fields)-1]; len(p.fields) > 3+poolDataFields && strings.HasPrefix(extra, SeedSlotPrefix) {
+		m.SeedSlot = extra[len(SeedSlotPrefix):]
+	}
+	return m
+}
+
+func (p *fieldParser) pong() Pong {
+	if len(p.fields) == 1 {
+		return Pong{}
+	}
+	p.need(1 + poolDataFields)
+	d := p.poolData(1)
+	return Pong{Data: &d}
+}
+
+func (p *fieldParser) paymentOK() PaymentOK {
+	if len(p.fields) < 8 {
+		p.fail("needs 8 fields, got %d. noso-go requires that the pool use Noso Wallet 0.2.0 N or greater", len(p.fields))
+		return PaymentOK{}
+	}
+	return PaymentOK{
+		Timestamp: p.int64(1, "timestamp"),
+		Pool:      p.str(2, "pool"),
+		Wallet:    p.str(3, "wallet"),
+		Kind:      p.str(4, "kind"),
+		Block:     p.int(5, "block"),
+		Amount:    p.str(6, "amount"),
+		OrderId:   p.str(7, "order id"),
+	}
+}
+
+// poolStatus reads "STATUS {hashrate} {fee} {share} {minerCount}
+// [{address}:{balance}:{blocksTillPayment} ...]"
+func (p *fieldParser) poolStatus() PoolStatus {
+	p.need(5)
+	hr := p.int64(1, "hash rate")
+	fee := p.int(2, "fee")
+	share := p.int(3, "share")
+	p.int(4, "miner count")
+	if p.err != nil {
+		return PoolStatus{}
+	}
+
+	miners := make([]MinerInfo, 0, len(p.fields)-5)
+	for _, f := range p.fields[5:] {
+		m, err := parseMinerInfo(f)
+		if err != nil {
+			p.fail("%v", err)
+			return PoolStatus{}
+		}
+		miners = append(miners, m)
+	}
+
+	return PoolStatus{
+		HashRateRaw: strconv.FormatInt(hr, 10),
+		HashRate:    formatHashRate(strconv.FormatInt(hr, 10) + "000"),
+		FeeRaw:      fee,
+		Fee:         fmt.Sprintf("%.2f%%", float64(fee)/100),
+		ShareRaw:    share,
+		Share:       fmt.Sprintf("%.2f%%", float64(share)/100),
+		MinerCnt:    p.fields[4],
+		Miners:      miners,
+	}
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// Commands sent to the pool. String returns the command without the
+// "{password} {wallet}" prefix the client adds
+
+// JoinCmd joins the pool: "JOIN {version} {instanceId}"
+type JoinCmd struct {
+	Version    string
+	InstanceId string
+}
+
+func (c JoinCmd) String() string { return fmt.Sprintf("JOIN %s %s", c.Version, c.InstanceId) }
+
+// PingCmd keeps the connection alive and reports the hash rate in kH/s:
+// "PING {hashRate} {instanceId}"
+type PingCmd struct {
+	HashRate   int
+	InstanceId string
+}
+
+func (c PingCmd) String() string { return fmt.Sprintf("PING %d %s", c.HashRate, c.InstanceId) }
+
+// StepCmd submits a solution: "STEP {block} {seed} {hash} {targetLen}
+// {instanceId}"
+type StepCmd struct {
+	Block      int
+	Seed       string
+	HashStr    string
+	TargetLen  int
+	InstanceId string
+}
+
+func (c StepCmd) String() string {
+	return fmt.Sprintf("STEP %d %s %s %d %s", c.Block, c.Seed, c.HashStr, c.TargetLen, c.InstanceId)
+}
+
+// PaymentCmd asks the pool to pay out the balance
+type PaymentCmd struct{}
+
+func (PaymentCmd) String() string { return "PAYMENT" }
+
+// StatusCmd asks the pool for its STATUS
+type StatusCmd struct{}
+
+func (StatusCmd) String() string { return "STATUS" }
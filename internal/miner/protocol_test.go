@@ -0,0 +1,309 @@
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+const (
+	testPong      = "PONG PoolData 100 ABCDEF0123 9 2 55 12345 0 1500 3"
+	testStatus    = "STATUS 1500 250 9000 2 N4ZR3fKhTUod34evnEcDQX3i6XufBDU:12345:3 Nm6jiGfRg7DVHHMfbMJL9CT1DtkUCF:0:10"
+	testPaymentOK = "PAYMENTOK 1618891646 POOLIP Nm6jiGfRg7DVHHMfbMJL9CT1DtkUCF 2 5833 1.48153045 OR60v3w4j25pkl7mp2aaxa6l7g7hxqsdlfu86fkueh11tfyqg03z"
+)
+
+var testPoolData = PoolData{
+	Block:             100,
+	TargetString:      "ABCDEF0123",
+	TargetChars:       9,
+	Step:              2,
+	Diff:              55,
+	Balance:           "12345",
+	BlocksTillPayment: 0,
+	PoolHashRate:      1500,
+	PoolDepth:         3,
+}
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		line string
+		want Message
+	}{
+		{testJoinOK, JoinOK{PoolAddr: "N4ZR3fKhTUod34evnEcDQX3i6XufBDU", MinerSeed: "1abc!!!", PoolData: testPoolData}},
+		{testJoinOK + " NGSLOT=2/8", JoinOK{PoolAddr: "N4ZR3fKhTUod34evnEcDQX3i6XufBDU", MinerSeed: "1abc!!!", PoolData: testPoolData, SeedSlot: "2/8"}},
+		{strings.TrimSuffix(testJoinOK, " 3") + " NGSLOT=2/8", JoinOK{PoolAddr: "N4ZR3fKhTUod34evnEcDQX3i6XufBDU", MinerSeed: "1abc!!!", PoolData: PoolData{100, "ABCDEF0123", 9, 2, 55, "12345", 0, 1500, 0}, SeedSlot: "2/8"}},
+		{testPoolSteps, PoolSteps{PoolData{101, "bbccdd4455", 10, 0, 66, "23456", 1, 1600, 3}}},
+		{"POOLSTEPS PoolData 101 bbccdd4455 10 0 66 23456 -30 1600 3", PoolSteps{PoolData{101, "bbccdd4455", 10, 0, 66, "23456", -30, 1600, 3}}},
+		{testPong, Pong{Data: &testPoolData}},
+		{"PONG PoolData 5351 5AFADEC0006675E408E5C06AA09C0120 10 6 99 953841173 -5 336517", Pong{Data: &PoolData{
+			Block:             5351,
+			TargetString:      "5AFADEC0006675E408E5C06AA09C0120",
+			TargetChars:       10,
+			Step:              6,
+			Diff:              99,
+			Balance:           "953841173",
+			BlocksTillPayment: -5,
+			PoolHashRate:      336517,
+		}}},
+		{"PONG", Pong{}},
+		{"PONG ", Pong{}},
+		{"STEPOK 3", StepOK{Shares: 3}},
+		{"STEPOK 3\r", StepOK{Shares: 3}},
+		{"STEPFAIL", StepFail{}},
+		{"PASSFAILED", PassFailed{}},
+		{testPaymentOK, PaymentOK{
+			Timestamp: 1618891646,
+			Pool:      "POOLIP",
+			Wallet:    "Nm6jiGfRg7DVHHMfbMJL9CT1DtkUCF",
+			Kind:      "2",
+			Block:     5833,
+			Amount:    "1.48153045",
+			OrderId:   "OR60v3w4j25pkl7mp2aaxa6l7g7hxqsdlfu86fkueh11tfyqg03z",
+		}},
+		{testStatus, PoolStatus{
+			HashRateRaw: "1500",
+			HashRate:    formatHashRate("1500000"),
+			FeeRaw:      250,
+			Fee:         "2.50%",
+			ShareRaw:    9000,
+			Share:       "90.00%",
+			MinerCnt:    "2",
+			Miners: []MinerInfo{
+				{"N4ZR3fKhTUod34evnEcDQX3i6XufBDU", "12345", formatBalance("12345"), "3"},
+				{"Nm6jiGfRg7DVHHMfbMJL9CT1DtkUCF", "0", formatBalance("0"), "10"},
+			},
+		}},
+		{"STATUS 1500 250 9000 0", PoolStatus{
+			HashRateRaw: "1500",
+			HashRate:    formatHashRate("1500000"),
+			FeeRaw:      250,
+			Fee:         "2.50%",
+			ShareRaw:    9000,
+			Share:       "90.00%",
+			MinerCnt:    "0",
+			Miners:      []MinerInfo{},
+		}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMessage(tt.line)
+		if err != nil {
+			t.Errorf("%q: %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %#v want %#v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		want error
+	}{
+		{"", ErrMalformed},
+		{"   ", ErrMalformed},
+		{"HELLO 1 2 3", ErrUnknownCode},
+		{"JOINOK", ErrMalformed},
+		{"JOINOK N4ZR3fKhTUod34evnEcDQX3i6XufBDU 1abc!!!", ErrMalformed},
+		{strings.TrimSuffix(testJoinOK, " 1500 3"), ErrMalformed},
+		{strings.TrimSuffix(testJoinOK, " 3") + " deep", ErrMalformed},
+		{strings.Replace(testJoinOK, " 100 ", " abc ", 1), ErrMalformed},
+		{strings.Replace(testJoinOK, "1abc!!!", "1a", 1), ErrMalformed},
+		{strings.Replace(testJoinOK, "ABCDEF0123", "XYZ", 1), ErrMalformed},
+		{strings.Replace(testJoinOK, "ABCDEF0123 9", "ABCDEF0123 11", 1), ErrMalformed},
+		{strings.Replace(testJoinOK, "ABCDEF0123 9", "ABCDEF0123 0", 1), ErrMalformed},
+		{strings.Replace(testJoinOK, " 2 55 ", " -2 55 ", 1), ErrMalformed},
+		{"POOLSTEPS", ErrMalformed},
+		{"POOLSTEPS PoolData 101", ErrMalformed},
+		{"PONG PoolData 100 ABCDEF0123", ErrMalformed},
+		{strings.Replace(testPong, " 1500 ", " fast ", 1), ErrMalformed},
+		{"STEPOK", ErrMalformed},
+		{"STEPOK many", ErrMalformed},
+		{"PAYMENTOK", ErrMalformed},
+		{"PAYMENTOK 1618891646 POOLIP", ErrMalformed},
+		{strings.Replace(testPaymentOK, " 5833 ", " x ", 1), ErrMalformed},
+		{"STATUS", ErrMalformed},
+		{"STATUS 1500 250 9000", ErrMalformed},
+		{"STATUS 1500 x 9000 1", ErrMalformed},
+		{"STATUS 1500 250 9000 1 N4ZR3fKhTUod34evnEcDQX3i6XufBDU", ErrMalformed},
+		{"STATUS 1500 250 9000 1 N4ZR3fKhTUod34evnEcDQX3i6XufBDU:12345", ErrMalformed},
+		{"STATUS 1500 250 9000 1 N4ZR3fKhTUod34evnEcDQX3i6XufBDU:12345:3:4", ErrMalformed},
+		{"STATUS 1500 250 9000 1 :12345:3", ErrMalformed},
+		{"STATUS 1500 250 9000 1 N4ZR3fKhTUod34evnEcDQX3i6XufBDU:lots:3", ErrMalformed},
+		{"STATUS 1500 250 9000 1 N4ZR3fKhTUod34evnEcDQX3i6XufBDU:12345:soon", ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		msg, err := ParseMessage(tt.line)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%q: got %v want %v", tt.line, err, tt.want)
+		}
+		if msg != nil {
+			t.Errorf("%q: got a message with the error: %#v", tt.line, msg)
+		}
+	}
+}
+
+// Generate implementations so testing/quick builds messages the pool
+// could actually send
+
+func randWord(r *rand.Rand, alphabet string, min, max int) string {
+	b := make([]byte, min+r.Intn(max-min+1))
+	for i := range b {
+		b[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
+const (
+	alnum  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	hexits = "0123456789abcdefABCDEF"
+	digits = "0123456789"
+)
+
+func (PoolData) Generate(r *rand.Rand, size int) reflect.Value {
+	target := randWord(r, hexits, 1, 32)
+	return reflect.ValueOf(PoolData{
+		Block:             r.Intn(1 << 20),
+		TargetString:      target,
+		TargetChars:       1 + r.Intn(len(target)),
+		Step:              r.Intn(100),
+		Diff:              r.Intn(200),
+		Balance:           randWord(r, digits, 1, 12),
+		BlocksTillPayment: r.Intn(200) - 100,
+		PoolHashRate:      r.Int63(),
+		PoolDepth:         r.Intn(10),
+	})
+}
+
+func (JoinOK) Generate(r *rand.Rand, size int) reflect.Value {
+	m := JoinOK{
+		PoolAddr:  randWord(r, alnum, 1, 40),
+		MinerSeed: randWord(r, alnum+"!", 3, 12),
+		PoolData:  PoolData{}.Generate(r, size).Interface().(PoolData),
+	}
+	if r.Intn(2) == 0 {
+		m.SeedSlot = fmt.Sprintf("%d/%d", r.Intn(8), 8)
+	}
+	return reflect.ValueOf(m)
+}
+
+func (PaymentOK) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(PaymentOK{
+		Timestamp: r.Int63(),
+		Pool:      randWord(r, alnum+".", 1, 20),
+		Wallet:    randWord(r, alnum, 1, 40),
+		Kind:      "2",
+		Block:     r.Intn(1 << 20),
+		Amount:    randWord(r, digits, 1, 4) + "." + randWord(r, digits, 8, 8),
+		OrderId:   randWord(r, alnum, 1, 60),
+	})
+}
+
+func (PoolStatus) Generate(r *rand.Rand, size int) reflect.Value {
+	miners := make([]MinerInfo, r.Intn(size+1))
+	for i := range miners {
+		balance := randWord(r, digits, 1, 12)
+		miners[i] = MinerInfo{
+			Address:           randWord(r, alnum, 1, 40),
+			Balance:           balance,
+			BalanceHR:         formatBalance(balance),
+			BlocksTillPayment: fmt.Sprint(r.Intn(100)),
+		}
+	}
+	hr := fmt.Sprint(r.Int63())
+	fee, share := r.Intn(10000), r.Intn(10000)
+	return reflect.ValueOf(PoolStatus{
+		HashRateRaw: hr,
+		HashRate:    formatHashRate(hr + "000"),
+		FeeRaw:      fee,
+		Fee:         fmt.Sprintf("%.2f%%", float64(fee)/100),
+		ShareRaw:    share,
+		Share:       fmt.Sprintf("%.2f%%", float64(share)/100),
+		MinerCnt:    fmt.Sprint(len(miners)),
+		Miners:      miners,
+	})
+}
+
+// roundTrips reports whether m parses back from its own String
+func roundTrips(t *testing.T, m Message) bool {
+	got, err := ParseMessage(m.String())
+	if err != nil {
+		t.Logf("%q: %v", m.String(), err)
+		return false
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Logf("%q: got %#v want %#v", m.String(), got, m)
+		return false
+	}
+	return true
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	checks := []interface{}{
+		func(m JoinOK) bool { return roundTrips(t, m) },
+		func(d PoolData) bool { return roundTrips(t, PoolSteps{d}) },
+		func(d PoolData) bool { return roundTrips(t, Pong{Data: &d}) },
+		func(shares uint16) bool { return roundTrips(t, StepOK{Shares: int(shares)}) },
+		func(m PaymentOK) bool { return roundTrips(t, m) },
+		func(m PoolStatus) bool { return roundTrips(t, m) },
+	}
+	for _, f := range checks {
+		if err := quick.Check(f, nil); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, m := range []Message{Pong{}, StepFail{}, PassFailed{}} {
+		if !roundTrips(t, m) {
+			t.Errorf("%#v doesn't round trip", m)
+		}
+	}
+}
+
+// TestParseMessageGarbage feeds ParseMessage damaged copies of valid lines
+// and random strings. It must return a message or an error, never panic
+func TestParseMessageGarbage(t *testing.T) {
+	valid := []string{testJoinOK, testJoinOK + " NGSLOT=1/4", testPoolSteps, testPong, "STEPOK 3", testPaymentOK, testStatus}
+	junk := []string{"", "-1", "0", "x", ":", "::", "a:b", "a:1:2:3", "99999999999999999999", "PoolData", "NGSLOT=", " "}
+
+	r := rand.New(rand.NewSource(1))
+	parse := func(line string) (ok bool) {
+		defer func() {
+			if err := recover(); err != nil {
+				t.Errorf("%q: panic: %v", line, err)
+				ok = false
+			}
+		}()
+		msg, err := ParseMessage(line)
+		if (msg == nil) == (err == nil) {
+			t.Errorf("%q: got %#v and %v", line, msg, err)
+		}
+		return true
+	}
+
+	for i := 0; i < 20000; i++ {
+		fields := strings.Split(valid[r.Intn(len(valid))], " ")
+		switch r.Intn(3) {
+		case 0:
+			fields = fields[:r.Intn(len(fields)+1)]
+		case 1:
+			fields[r.Intn(len(fields))] = junk[r.Intn(len(junk))]
+		case 2:
+			i := r.Intn(len(fields))
+			fields = append(fields[:i], fields[i+1:]...)
+		}
+		if !parse(strings.Join(fields, " ")) {
+			return
+		}
+	}
+
+	if err := quick.Check(parse, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,585 @@
+package miner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
+)
+
+// ProxyOpts configures the downstream side of a mining proxy
+type ProxyOpts struct {
+	// Listen is the address downstream miners connect to (e.g. ":8082")
+	Listen string
+	// Password downstream miners must send, empty accepts any password
+	Password string
+	// Slots is the number of seed space partitions, which is also the
+	// maximum number of downstream miners
+	Slots int
+}
+
+// downstream is a single miner connected to the proxy
+type downstream struct {
+	id       int
+	conn     net.Conn
+	addr     string
+	wallet   string
+	slot     int
+	joined   time.Time
+	send     chan string
+	hashRate int // in KHash/s, as sent in PING
+
+	stepsSent     int
+	stepsAccepted int
+	stepsFailed   int
+	sharesEarned  int
+}
+
+type downstreamMsg struct {
+	d    *downstream
+	line string
+}
+
+type proxy struct {
+	opts      *Opts
+	proxyOpts *ProxyOpts
+	comms     *Comms
+	client    *TcpClient
+	stats     *Stats
+
+	// upstream state, from the last JOINOK/POOLSTEPS/PONG
+	poolAddr  string
+	minerSeed string
+	poolData  *PoolData
+
+	// upstreamReset is closed when the client resets the pool state,
+	// which it does whenever the upstream connection ends
+	upstreamReset <-chan struct{}
+
+	nextId      int
+	downstreams map[int]*downstream
+	slots       []*downstream
+
+	// Pool responses carry no request id, so replies are matched to the
+	// downstream that asked in the order the requests were sent
+	pendingSteps    []*downstream
+	pendingPayments []*downstream
+	pendingStatus   []*downstream
+
+	// When payment was last requested for each wallet, see PaymentPolicy
+	paymentRequested map[string]time.Time
+	paymentNote      string
+
+	// upstreamFull is set while the upstream send queue is full, so it is
+	// only logged once
+	upstreamFull bool
+
+	joins  chan *downstream
+	leaves chan *downstream
+	msgs   chan downstreamMsg
+
+	// done is closed once the proxy stops reading joins, leaves and msgs
+	done chan struct{}
+}
+
+// Proxy holds a single upstream pool connection and serves the same line
+// protocol to local noso-go miners, giving each of them its own slice of
+// the seed space and forwarding their steps upstream. It runs until ctx
+// is done, or returns ErrConnectionLost when the upstream connection is
+// lost and Opts.ExitOnRetry is set
+func Proxy(ctx context.Context, opts *Opts, proxyOpts *ProxyOpts) error {
+	printHeader()
+
+	if proxyOpts.Slots < 1 || proxyOpts.Slots > len(hashableSeedChars) {
+		logging.Fatalf("Proxy slots must be between 1 and %d\n", len(hashableSeedChars))
+	}
+
+	ln, err := net.Listen("tcp", proxyOpts.Listen)
+	if err != nil {
+		logging.Fatalf("Could not listen on %s: %v\n", proxyOpts.Listen, err)
+	}
+	logging.Infof("Proxy listening on %s for up to %d miners\n", ln.Addr(), proxyOpts.Slots)
+	logging.Infof("Connecting to %s:%d with password %s\n", opts.IpAddr, opts.IpPort, opts.PoolPw)
+
+	stats := NewStats()
+	if len(opts.RateWindows) > 0 {
+		stats.SetRateWindows(opts.RateWindows)
+	}
+	if err := useDataDir(opts); err != nil {
+		logging.Fatalf("Could not create data directory %s: %v\n", opts.DataDir, err)
+	}
+	if opts.ApiListen != "" {
+		if err := StartAPI(opts.ApiListen, stats); err != nil {
+			logging.Fatalf("Could not start status API on %s: %v\n", opts.ApiListen, err)
+		}
+	}
+	if opts.MetricsListen != "" {
+		if err := StartMetrics(opts.MetricsListen, stats); err != nil {
+			logging.Fatalf("Could not start metrics endpoint on %s: %v\n", opts.MetricsListen, err)
+		}
+	}
+
+	p := newProxy(opts, proxyOpts, stats)
+	err = p.serve(ctx, ln)
+	p.printStatus()
+
+	return err
+}
+
+// newProxy returns a proxy connected to the pool in opts
+func newProxy(opts *Opts, proxyOpts *ProxyOpts, stats *Stats) *proxy {
+	comms := NewComms()
+	return &proxy{
+		opts:        opts,
+		proxyOpts:   proxyOpts,
+		comms:       comms,
+		client:      NewTcpClient(opts, comms, stats, true, true),
+		stats:       stats,
+		downstreams: make(map[int]*downstream),
+		slots:       make([]*downstream, proxyOpts.Slots),
+		joins:       make(chan *downstream, 0),
+		leaves:      make(chan *downstream, 0),
+		msgs:        make(chan downstreamMsg, 100),
+		done:        make(chan struct{}),
+
+		paymentRequested: lastPaymentRequests(),
+	}
+}
+
+// serve accepts downstream miners on ln and runs the proxy until ctx is
+// done or the upstream client stops, then closes ln, every downstream
+// connection and the client
+func (p *proxy) serve(ctx context.Context, ln net.Listener) error {
+	go p.accept(ln)
+	err := p.run(ctx)
+	close(p.done)
+	ln.Close()
+	// The miners are kept for the final status
+	for _, d := range p.downstreams {
+		close(d.send)
+		d.conn.Close()
+	}
+	p.client.Close(time.Second)
+
+	return err
+}
+
+func (p *proxy) accept(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			logging.Warnf("Error accepting miner connection: %v\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		d := &downstream{
+			conn: conn,
+			addr: conn.RemoteAddr().String(),
+			slot: -1,
+			send: make(chan string, 100),
+		}
+		select {
+		case p.joins <- d:
+		case <-p.done:
+			conn.Close()
+			return
+		}
+		go p.read(d)
+		go p.write(d)
+	}
+}
+
+func (p *proxy) read(d *downstream) {
+	scanner := bufio.NewScanner(d.conn)
+	for {
+		d.conn.SetReadDeadline(time.Now().Add(connectionTimeout))
+		if !scanner.Scan() {
+			break
+		}
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			select {
+			case p.msgs <- downstreamMsg{d: d, line: line}:
+			case <-p.done:
+				return
+			}
+		}
+	}
+	select {
+	case p.leaves <- d:
+	case <-p.done:
+	}
+}
+
+func (p *proxy) write(d *downstream) {
+	for msg := range d.send {
+		if _, err := fmt.Fprintf(d.conn, "%s\n", msg); err != nil {
+			d.conn.Close()
+		}
+	}
+	d.conn.Close()
+}
+
+func (p *proxy) run(ctx context.Context) error {
+	statusTicker := time.NewTicker(time.Duration(p.opts.StatusInterval) * time.Second)
+	defer statusTicker.Stop()
+
+	_, p.upstreamReset = p.comms.State.Current()
+
+	for {
+		select {
+		case d := <-p.joins:
+			p.nextId++
+			d.id = p.nextId
+			p.downstreams[d.id] = d
+		case d := <-p.leaves:
+			p.drop(d)
+		case msg := <-p.msgs:
+			if _, ok := p.downstreams[msg.d.id]; ok {
+				p.handleDownstream(msg.d, msg.line)
+			}
+		case resp := <-p.client.RecvChan:
+			p.handleUpstream(resp)
+		case <-p.upstreamReset:
+			_, p.upstreamReset = p.comms.State.Current()
+			p.upstreamLost()
+		case <-statusTicker.C:
+			p.printStatus()
+		case <-ctx.Done():
+			logging.Infof("Shutting down the proxy\n")
+			return nil
+		case <-p.client.Done():
+			logging.Errorf("Exiting because %v and --exit-on-retry is set\n", p.client.Err())
+			return p.client.Err()
+		}
+	}
+}
+
+// drop disconnects a downstream miner and frees its seed slot
+func (p *proxy) drop(d *downstream) {
+	if _, ok := p.downstreams[d.id]; !ok {
+		return
+	}
+	delete(p.downstreams, d.id)
+	if d.slot >= 0 {
+		p.slots[d.slot] = nil
+		logging.Infof("Miner %d (%s, %s) left slot %d\n", d.id, d.addr, d.wallet, d.slot)
+	}
+	close(d.send)
+}
+
+// reset disconnects every downstream miner, forcing them to rejoin with
+// the current upstream seed
+func (p *proxy) reset() {
+	for _, d := range p.downstreams {
+		p.drop(d)
+	}
+	p.pendingSteps = nil
+	p.pendingPayments = nil
+	p.pendingStatus = nil
+}
+
+// upstreamLost forgets the upstream session after the connection to the
+// pool ended. Steps are failed back to the miners until the pool is
+// joined again, and the steps waiting for an answer will never get one
+// (the client drops those it hadn't sent yet)
+func (p *proxy) upstreamLost() {
+	if !p.joinedUpstream() {
+		return
+	}
+	logging.Warnf("Lost the upstream pool, failing steps until it is joined again\n")
+	p.poolAddr = ""
+	p.minerSeed = ""
+	p.pendingSteps = nil
+}
+
+func (p *proxy) joinedUpstream() bool {
+	return p.poolAddr != "" && p.poolData != nil
+}
+
+// handleDownstream handles a "{password} {wallet} {command} ..." line from
+// a downstream miner
+func (p *proxy) handleDownstream(d *downstream, line string) {
+	r := strings.Split(line, " ")
+	if len(r) < 3 {
+		logging.Warnf("Malformed message from miner %d (%s): %q\n", d.id, d.addr, line)
+		return
+	}
+	pw, wallet, cmd, args := r[0], r[1], r[2], r[3:]
+
+	// 'noso-go status' asks for STATUS without joining
+	if cmd != "JOIN" && cmd != "STATUS" && d.slot < 0 {
+		logging.Warnf("Miner %d (%s) sent %s before JOIN, dropping it\n", d.id, d.addr, cmd)
+		p.drop(d)
+		return
+	}
+
+	switch cmd {
+	case "JOIN":
+		if p.proxyOpts.Password != "" && pw != p.proxyOpts.Password {
+			p.sendTo(d, PASSFAILED)
+			return
+		}
+		if !p.joinedUpstream() {
+			// The miner will reconnect and try again
+			logging.Warnf("Miner %d (%s) tried to join before the upstream pool was joined\n", d.id, d.addr)
+			p.drop(d)
+			return
+		}
+		if d.slot < 0 {
+			for i, owner := range p.slots {
+				if owner == nil {
+					d.slot = i
+					p.slots[i] = d
+					break
+				}
+			}
+			if d.slot < 0 {
+				logging.Warnf("No free slots for miner %d (%s), dropping it\n", d.id, d.addr)
+				p.drop(d)
+				return
+			}
+		}
+		d.wallet = wallet
+		d.joined = time.Now()
+		logging.Infof("Miner %d (%s, %s) joined in slot %d\n", d.id, d.addr, d.wallet, d.slot)
+		p.sendTo(d, JoinOK{
+			PoolAddr:  p.poolAddr,
+			MinerSeed: p.minerSeed,
+			PoolData:  *p.poolData,
+			SeedSlot:  fmt.Sprintf("%d/%d", d.slot, p.proxyOpts.Slots),
+		}.String())
+	case "PING":
+		if len(args) > 0 {
+			if hr, err := strconv.Atoi(args[0]); err == nil {
+				d.hashRate = hr
+				p.updateHashRate()
+			}
+		}
+		p.sendTo(d, Pong{Data: p.poolData}.String())
+	case "STEP":
+		if !p.joinedUpstream() {
+			d.stepsFailed++
+			p.sendTo(d, STEPFAIL)
+			return
+		}
+		d.stepsSent++
+		if !p.sendUpstream(strings.Join(r[2:], " ")) {
+			d.stepsFailed++
+			p.sendTo(d, STEPFAIL)
+			return
+		}
+		p.pendingSteps = append(p.pendingSteps, d)
+		p.stats.Update(func(s *MinerStatus) { s.StepsSent++ })
+	case "PAYMENT":
+		// Every miner asks for the proxy's balance, which goes to the
+		// proxy's wallet, so the proxy's payment policy decides
+		if p.poolData == nil {
+			return
+		}
+		balance, _ := ParseNosoAmount(p.poolData.Balance)
+		due, reason := p.opts.Payments.due(time.Now(), balance, p.poolData.BlocksTillPayment, p.paymentRequested[p.opts.CurrentWallet])
+		if reason != p.paymentNote && reason != "" {
+			logging.Infof("Not passing on payment requests: %s\n", reason)
+		}
+		p.paymentNote = reason
+		if !due {
+			return
+		}
+		if !p.sendUpstream(PaymentCmd{}.String()) {
+			return
+		}
+		p.paymentRequested[p.opts.CurrentWallet] = time.Now()
+		p.pendingPayments = append(p.pendingPayments, d)
+		LogPaymentReq(p.opts.IpAddr, p.opts.CurrentWallet, p.poolData.Block, p.poolData.Balance)
+	case "STATUS":
+		if p.sendUpstream(StatusCmd{}.String()) {
+			p.pendingStatus = append(p.pendingStatus, d)
+		}
+	default:
+		logging.Warnf("Unknown command from miner %d (%s): %s\n", d.id, d.addr, cmd)
+	}
+}
+
+func (p *proxy) handleUpstream(resp string) {
+	msg, err := ParseMessage(resp)
+	if err != nil {
+		logging.Warnf("Ignoring pool response %q: %v\n", resp, err)
+		return
+	}
+
+	switch m := msg.(type) {
+	case JoinOK:
+		// A new upstream session may come with a new seed, so every
+		// downstream miner has to rejoin
+		p.reset()
+		// Resets so far were for connections before this one
+		_, p.upstreamReset = p.comms.State.Current()
+		p.poolAddr = m.PoolAddr
+		p.minerSeed = m.MinerSeed
+		p.updatePoolData(m.PoolData)
+		signal(p.comms.Joined)
+	case PoolSteps:
+		p.updatePoolData(m.PoolData)
+		p.broadcast(resp)
+	case Pong:
+		if m.Data != nil {
+			p.updatePoolData(*m.Data)
+		}
+		signal(p.comms.Pong)
+	case StepOK, StepFail:
+		var d *downstream
+		if len(p.pendingSteps) > 0 {
+			d, p.pendingSteps = p.pendingSteps[0], p.pendingSteps[1:]
+		}
+		ok, isOK := m.(StepOK)
+		if isOK {
+			p.stats.AcceptStep(ok.Shares)
+		} else {
+			p.stats.FailStep()
+		}
+		if d == nil {
+			return
+		}
+		if isOK {
+			d.stepsAccepted++
+			d.sharesEarned += ok.Shares
+		} else {
+			d.stepsFailed++
+		}
+		p.reply(d, resp)
+	case PaymentOK:
+		LogPaymentResp(m, p.opts.IpAddr)
+		if len(p.pendingPayments) > 0 {
+			p.reply(p.pendingPayments[0], resp)
+			p.pendingPayments = p.pendingPayments[1:]
+		}
+	case PoolStatus:
+		if len(p.pendingStatus) > 0 {
+			p.reply(p.pendingStatus[0], resp)
+			p.pendingStatus = p.pendingStatus[1:]
+		}
+	case PassFailed:
+		logging.Errorf("Incorrect pool password")
+	}
+}
+
+// sendUpstream queues msg for the pool without blocking the proxy, and
+// reports whether it was queued. The queue only fills up while the pool
+// isn't reading or the client is reconnecting
+func (p *proxy) sendUpstream(msg string) bool {
+	select {
+	case p.client.SendChan <- msg:
+		p.upstreamFull = false
+		return true
+	default:
+		if !p.upstreamFull {
+			logging.Warnf("The upstream pool is not keeping up, failing requests until it does\n")
+			p.upstreamFull = true
+		}
+		return false
+	}
+}
+
+// reply sends resp to d, unless d has disconnected in the meantime
+func (p *proxy) reply(d *downstream, resp string) {
+	if _, ok := p.downstreams[d.id]; ok {
+		p.sendTo(d, resp)
+	}
+}
+
+// sendTo queues msg for d without blocking the proxy. Miners that fall
+// too far behind are dropped
+func (p *proxy) sendTo(d *downstream, msg string) {
+	select {
+	case d.send <- msg:
+	default:
+		logging.Warnf("Miner %d (%s) is not reading, dropping it\n", d.id, d.addr)
+		p.drop(d)
+	}
+}
+
+func (p *proxy) broadcast(resp string) {
+	for _, d := range p.downstreams {
+		if d.slot >= 0 {
+			p.sendTo(d, resp)
+		}
+	}
+}
+
+// updateHashRate passes the combined downstream hash rate to the upstream
+// PING
+func (p *proxy) updateHashRate() {
+	hr := 0
+	for _, d := range p.downstreams {
+		hr += d.hashRate
+	}
+	hr *= 1000
+
+	p.stats.Update(func(s *MinerStatus) { s.HashRate = hr })
+	select {
+	case p.comms.HashRate <- hr:
+	default:
+	}
+}
+
+// updatePoolData records the pool data from the last JOINOK, POOLSTEPS or
+// PONG
+func (p *proxy) updatePoolData(data PoolData) {
+	p.poolData = &data
+	p.stats.Update(func(s *MinerStatus) {
+		s.Block = data.Block
+		s.Step = data.Step
+		s.Diff = data.Diff
+		s.Balance = parseAmount(data.Balance)
+		s.BlocksTillPayment = data.BlocksTillPayment
+		s.PoolHashRate = data.PoolHashRate * 1000
+	})
+	p.stats.SetStepDifficulty(data.Diff, data.PoolDepth)
+}
+
+func (p *proxy) printStatus() {
+	ds := make([]*downstream, 0, len(p.downstreams))
+	for _, d := range p.downstreams {
+		if d.slot >= 0 {
+			ds = append(ds, d)
+		}
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i].slot < ds[j].slot })
+
+	status := p.stats.Status()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n************************************\n\nProxy Status\n\n")
+	fmt.Fprintf(&b, "Pool Wallet Addr : %s\n", status.Wallet)
+	fmt.Fprintf(&b, "Pool Connection  : %s\n", connectionNote(status))
+	fmt.Fprintf(&b, "Current Block    : %d\n", status.Block)
+	fmt.Fprintf(&b, "Total Hash Rate  : %s\n", formatHashRate(strconv.Itoa(status.HashRate)))
+	for _, avg := range status.EffectiveHashRateAvg {
+		fmt.Fprintf(&b, "Effective (%-3s)  : %s\n", avg.Window, formatHashRate(strconv.Itoa(avg.HashRate)))
+	}
+	fmt.Fprintf(&b, "Pool Balance     : %s\n", formatBalance(status.Balance))
+	fmt.Fprintf(&b, "PoP Sent/Accepted: %d/%d (%.1f%% of answered accepted)\n\n", status.StepsSent, status.StepsAccepted, status.AcceptRatio*100)
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SLOT\tMINER\tWALLET\tHASH RATE\tSENT\tACCEPTED\tFAILED\tSHARES")
+	for _, d := range ds {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t%d\t%d\n",
+			d.slot, d.addr, d.wallet, formatHashRate(strconv.Itoa(d.hashRate*1000)),
+			d.stepsSent, d.stepsAccepted, d.stepsFailed, d.sharesEarned)
+	}
+	w.Flush()
+	fmt.Fprintf(&b, "\n************************************\n")
+
+	logging.Infof("%s", b.String())
+}
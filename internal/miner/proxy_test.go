@@ -0,0 +1,312 @@
+package miner
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/mockpool"
+)
+
+// startMiningProxy runs a proxy with slots in front of a mock pool on
+// which every step of one target character is accepted. stop stops the
+// proxy, after which its downstreams may be looked at
+func startMiningProxy(t *testing.T, slots int) (p *proxy, pool *mockpool.Server, addr string, stop func()) {
+	t.Helper()
+	inTempDir(t)
+
+	cfg := mockpool.DefaultConfig()
+	cfg.TargetChars = 4
+	cfg.Diff = 10
+	cfg.PoolDepth = 1
+	pool, endpoint := startMockPool(t, cfg)
+
+	opts := &Opts{
+		IpAddr:         endpoint.Address,
+		IpPort:         endpoint.Port,
+		PoolPw:         endpoint.Password,
+		Wallets:        []string{"proxywallet"},
+		StatusInterval: 60,
+	}
+	p = newProxy(opts, &ProxyOpts{Slots: slots}, NewStats())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.serve(ctx, ln)
+		close(done)
+	}()
+	stop = func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return p, pool, ln.Addr().String(), stop
+}
+
+// testMiner is a downstream miner talking to a proxy
+type testMiner struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	wallet string
+	join   JoinOK
+}
+
+// joinProxy connects to the proxy at addr until it accepts the JOIN,
+// which it only does once it has joined the pool
+func joinProxy(t *testing.T, addr, wallet string) *testMiner {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		m := &testMiner{t: t, conn: conn, r: bufio.NewReader(conn), wallet: wallet}
+		m.send("JOIN test abc")
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := m.r.ReadString('\n')
+		if err != nil {
+			// Dropped before the proxy joined the pool
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		msg, err := ParseMessage(strings.TrimSpace(line))
+		join, ok := msg.(JoinOK)
+		if err != nil || !ok {
+			t.Fatalf("expected JOINOK, got %q", line)
+		}
+		m.join = join
+		return m
+	}
+	t.Fatal("the proxy never accepted a JOIN")
+	return nil
+}
+
+func (m *testMiner) send(cmd string) {
+	fmt.Fprintf(m.conn, "pw %s %s\n", m.wallet, cmd)
+}
+
+// recv returns the next line from the proxy that starts with one of codes
+func (m *testMiner) recv(codes ...string) string {
+	m.t.Helper()
+
+	m.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		line, err := m.r.ReadString('\n')
+		if err != nil {
+			m.t.Fatalf("waiting for %v: %v", codes, err)
+		}
+		line = strings.TrimSpace(line)
+		for _, code := range codes {
+			if line == code || strings.HasPrefix(line, code+" ") {
+				return line
+			}
+		}
+	}
+}
+
+// step sends a step the pool accepts, or one for the wrong block
+func (m *testMiner) step(valid bool) {
+	data := m.join.PoolData
+	if !valid {
+		m.send(fmt.Sprintf("STEP %d %s 0 1", data.Block+1, m.join.MinerSeed))
+		return
+	}
+	for i := 0; ; i++ {
+		hashStr := fmt.Sprintf("%s%d", m.wallet, i)
+		sum := sha256.Sum256([]byte(m.join.MinerSeed + m.join.PoolAddr + hashStr))
+		if strings.Contains(hex.EncodeToString(sum[:]), strings.ToLower(data.TargetString[:1])) {
+			m.send(fmt.Sprintf("STEP %d %s %s 1", data.Block, m.join.MinerSeed, hashStr))
+			return
+		}
+	}
+}
+
+func TestProxyJoin(t *testing.T) {
+	_, pool, addr, _ := startMiningProxy(t, 2)
+
+	a := joinProxy(t, addr, "a")
+	b := joinProxy(t, addr, "b")
+	if a.join.SeedSlot != "0/2" || b.join.SeedSlot != "1/2" {
+		t.Errorf("got slots %q and %q", a.join.SeedSlot, b.join.SeedSlot)
+	}
+	block, target := pool.Block()
+	if a.join.PoolData.Block != block || !strings.EqualFold(a.join.PoolData.TargetString, target) {
+		t.Errorf("got pool data %+v want block %d target %s", a.join.PoolData, block, target)
+	}
+
+	// Every slot is taken
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "pw c JOIN test abc\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err == nil {
+		t.Errorf("third miner got %q", line)
+	}
+}
+
+func TestProxyForwardsSteps(t *testing.T) {
+	p, pool, addr, stop := startMiningProxy(t, 4)
+
+	a := joinProxy(t, addr, "a")
+	b := joinProxy(t, addr, "b")
+
+	// Interleaved, so answers have to go back to the right miner
+	want := map[*testMiner][]string{}
+	for _, s := range []struct {
+		m     *testMiner
+		valid bool
+	}{{a, true}, {b, false}, {a, true}, {b, true}, {b, false}, {a, true}} {
+		s.m.step(s.valid)
+		if s.valid {
+			want[s.m] = append(want[s.m], STEPOK+" 1")
+		} else {
+			want[s.m] = append(want[s.m], STEPFAIL)
+		}
+	}
+	for m, answers := range want {
+		for i, answer := range answers {
+			if got := m.recv(STEPOK, STEPFAIL); got != answer {
+				t.Errorf("miner %s answer %d is %q want %q", m.wallet, i, got, answer)
+			}
+		}
+	}
+
+	steps := pool.Steps()
+	if len(steps) != 6 {
+		t.Fatalf("pool got %d steps want 6", len(steps))
+	}
+	for _, step := range steps {
+		if step.Wallet != "proxywallet" {
+			t.Errorf("step sent with wallet %q", step.Wallet)
+		}
+	}
+
+	stop()
+	accounts := map[string][4]int{}
+	for _, d := range p.downstreams {
+		accounts[d.wallet] = [4]int{d.stepsSent, d.stepsAccepted, d.stepsFailed, d.sharesEarned}
+	}
+	if accounts["a"] != [4]int{3, 3, 0, 3} || accounts["b"] != [4]int{3, 1, 2, 1} {
+		t.Errorf("got sent/accepted/failed/shares %v", accounts)
+	}
+	if status := p.stats.Status(); status.StepsSent != 6 || status.StepsAccepted != 4 || status.StepsFailed != 2 {
+		t.Errorf("got proxy status %+v", status)
+	}
+}
+
+func TestProxyUpstreamBusy(t *testing.T) {
+	inTempDir(t)
+	p := &proxy{
+		opts:        &Opts{},
+		proxyOpts:   &ProxyOpts{Slots: 1},
+		client:      &TcpClient{SendChan: make(chan string, 1)},
+		stats:       NewStats(),
+		downstreams: map[int]*downstream{},
+		poolAddr:    "pool",
+		poolData:    &PoolData{Block: 100},
+	}
+	d := &downstream{id: 1, slot: 0, send: make(chan string, 10)}
+	p.downstreams[d.id] = d
+	answers := func() []string {
+		var lines []string
+		for len(d.send) > 0 {
+			lines = append(lines, <-d.send)
+		}
+		return lines
+	}
+
+	step := "pw a STEP 100 seed hash 1"
+	p.handleDownstream(d, step)
+	// Nothing reads SendChan, so the second step can't be queued and is
+	// failed back right away instead of blocking the proxy
+	p.handleDownstream(d, step)
+	if got := answers(); len(got) != 1 || got[0] != STEPFAIL || len(p.pendingSteps) != 1 {
+		t.Fatalf("got answers %v, %d pending", got, len(p.pendingSteps))
+	}
+
+	// The pool will never answer the step sent before the connection
+	// ended, and steps are failed until it is joined again
+	p.upstreamLost()
+	if len(p.pendingSteps) != 0 || p.joinedUpstream() {
+		t.Fatalf("%d steps still pending after the upstream was lost", len(p.pendingSteps))
+	}
+	// Not sent, so not counted as sent
+	p.handleDownstream(d, step)
+	p.handleUpstream(STEPOK + " 1")
+	if got := answers(); len(got) != 1 || got[0] != STEPFAIL {
+		t.Errorf("got answers %v", got)
+	}
+	if d.stepsSent != 2 || d.stepsAccepted != 0 || d.stepsFailed != 2 {
+		t.Errorf("got sent/accepted/failed %d/%d/%d", d.stepsSent, d.stepsAccepted, d.stepsFailed)
+	}
+}
+
+func TestProxyReconnect(t *testing.T) {
+	_, pool, addr, _ := startMiningProxy(t, 2)
+
+	a := joinProxy(t, addr, "a")
+	pool.DropConnections()
+
+	// Rejoining the pool drops the miners, so they join the new session
+	a.conn.SetReadDeadline(time.Now().Add(connectionTimeout))
+	for {
+		if _, err := a.r.ReadString('\n'); err != nil {
+			break
+		}
+	}
+
+	a = joinProxy(t, addr, "a")
+	a.step(true)
+	if got := a.recv(STEPOK, STEPFAIL); got != STEPOK+" 1" {
+		t.Errorf("got %q after rejoining", got)
+	}
+}
+
+func TestProxyShutdownClosesMiners(t *testing.T) {
+	_, _, addr, stop := startMiningProxy(t, 2)
+
+	a := joinProxy(t, addr, "a")
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	// A miner that keeps sending while the proxy stops
+	go func() {
+		for {
+			if _, err := fmt.Fprintf(idle, "pw idle PING 0\n"); err != nil {
+				return
+			}
+		}
+	}()
+
+	stop()
+	for _, conn := range []net.Conn{a.conn, idle} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		// Closing with unread lines resets the connection instead
+		_, err := io.Copy(io.Discard, conn)
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			t.Errorf("connection not closed by the proxy: %v", err)
+		}
+	}
+}
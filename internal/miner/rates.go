@@ -0,0 +1,126 @@
+package miner
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// DefaultRateWindows are the periods hash rates are averaged over
+var DefaultRateWindows = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour}
+
+// RateAverage is a hash rate averaged over the last Window. Until a whole
+// window has passed, it is averaged over the time there is data for
+type RateAverage struct {
+	Window   string `json:"window"`
+	HashRate int    `json:"hash_rate"`
+}
+
+// rateSample is a number of hashes done between start and end
+type rateSample struct {
+	start, end time.Time
+	hashes     float64
+}
+
+// rateHistory keeps enough samples to average over the longest window.
+// Samples starting within a second of each other are merged, so it never
+// holds more than one sample per second
+type rateHistory struct {
+	first   time.Time
+	samples []rateSample
+}
+
+func (h *rateHistory) add(s rateSample, keep time.Duration) {
+	if h.first.IsZero() {
+		h.first = s.start
+	}
+
+	if n := len(h.samples); n > 0 && s.start.Sub(h.samples[n-1].start) < time.Second {
+		last := &h.samples[n-1]
+		if s.end.After(last.end) {
+			last.end = s.end
+		}
+		last.hashes += s.hashes
+	} else {
+		h.samples = append(h.samples, s)
+	}
+
+	cutoff := s.end.Add(-keep)
+	i := 0
+	for i < len(h.samples) && h.samples[i].end.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		h.samples = append(h.samples[:0], h.samples[i:]...)
+	}
+}
+
+// rate returns the hashes per second over the window ending at now,
+// counting only the time since since. Samples that straddle the start of
+// the window count in proportion
+func (h *rateHistory) rate(now time.Time, window time.Duration, since time.Time) int {
+	cutoff := now.Add(-window)
+	if since.After(cutoff) {
+		cutoff = since
+	}
+	span := now.Sub(cutoff).Seconds()
+	if span <= 0 {
+		return 0
+	}
+
+	hashes := 0.0
+	for _, s := range h.samples {
+		if !s.end.After(cutoff) {
+			continue
+		}
+		if dur := s.end.Sub(s.start); s.start.Before(cutoff) && dur > 0 {
+			hashes += s.hashes * float64(s.end.Sub(cutoff)) / float64(dur)
+		} else {
+			hashes += s.hashes
+		}
+	}
+
+	return int(hashes / span)
+}
+
+// averages returns the rate of h over every window
+func (h *rateHistory) averages(now time.Time, windows []time.Duration, since time.Time) []RateAverage {
+	avgs := make([]RateAverage, len(windows))
+	for i, w := range windows {
+		avgs[i] = RateAverage{Window: formatWindow(w), HashRate: h.rate(now, w, since)}
+	}
+	return avgs
+}
+
+// stepHashes returns how many hashes it takes on average to find a step
+// the pool accepts: a run of at least diff/10+1-depth target characters
+// anywhere in the 64 hex characters of a hash
+func stepHashes(diff, depth int) float64 {
+	n := diff/10 + 1 - depth
+	if n < 1 || n > 64 {
+		return 0
+	}
+	return math.Pow(16, float64(n)) / float64(65-n)
+}
+
+// formatWindow returns a short name for a rate window, like "5m" or "1h"
+func formatWindow(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d >= time.Minute && d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
+
+func maxWindow(windows []time.Duration) time.Duration {
+	max := time.Duration(0)
+	for _, w := range windows {
+		if w > max {
+			max = w
+		}
+	}
+	return max
+}
@@ -0,0 +1,141 @@
+package miner
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateHistory(t *testing.T) {
+	start := time.Date(2021, 4, 20, 0, 0, 0, 0, time.UTC)
+	h := &rateHistory{}
+
+	// 1000 hashes per second for 10 minutes, then 4000 per second for a
+	// minute
+	at := start
+	for i := 0; i < 600; i++ {
+		h.add(rateSample{start: at, end: at.Add(time.Second), hashes: 1000}, time.Hour)
+		at = at.Add(time.Second)
+	}
+	for i := 0; i < 60; i++ {
+		h.add(rateSample{start: at, end: at.Add(time.Second), hashes: 4000}, time.Hour)
+		at = at.Add(time.Second)
+	}
+
+	tests := []struct {
+		window time.Duration
+		want   int
+	}{
+		{time.Minute, 4000},
+		{2 * time.Minute, 2500},
+		{11 * time.Minute, 1272},
+		// Less than an hour of data, so the hour is averaged over 11 minutes
+		{time.Hour, 1272},
+		// Straddles a sample
+		{90 * time.Second, 3000},
+		{60*time.Second + 500*time.Millisecond, 3975},
+	}
+	for _, tt := range tests {
+		if got := h.rate(at, tt.window, h.first); got != tt.want {
+			t.Errorf("%s: got %d want %d", tt.window, got, tt.want)
+		}
+	}
+
+	// Nothing hashed in the last minute
+	if got := h.rate(at.Add(time.Minute), time.Minute, h.first); got != 0 {
+		t.Errorf("got %d after a minute without samples", got)
+	}
+}
+
+func TestRateHistoryPrunes(t *testing.T) {
+	start := time.Date(2021, 4, 20, 0, 0, 0, 0, time.UTC)
+	h := &rateHistory{}
+
+	// Steps accepted 10 times a second are merged into one sample per
+	// second, and only the last minute is kept
+	for i := 0; i < 1200; i++ {
+		at := start.Add(time.Duration(i) * 100 * time.Millisecond)
+		h.add(rateSample{start: at, end: at, hashes: 10}, time.Minute)
+	}
+	if n := len(h.samples); n > 61 {
+		t.Errorf("kept %d samples for a minute", n)
+	}
+
+	end := start.Add(120 * time.Second)
+	if got := h.rate(end, time.Minute, start); got < 95 || got > 100 {
+		t.Errorf("got %d want ~100", got)
+	}
+}
+
+func TestStepHashes(t *testing.T) {
+	tests := []struct {
+		diff, depth int
+		want        float64
+	}{
+		{30, 1, math.Pow(16, 3) / 62},
+		{40, 1, math.Pow(16, 4) / 61},
+		{99, 3, math.Pow(16, 7) / 58},
+		{0, 1, 0},
+		{5, 3, 0},
+	}
+	for _, tt := range tests {
+		if got := stepHashes(tt.diff, tt.depth); got != tt.want {
+			t.Errorf("diff %d depth %d: got %f want %f", tt.diff, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestFormatWindow(t *testing.T) {
+	tests := map[time.Duration]string{
+		time.Minute:      "1m",
+		15 * time.Minute: "15m",
+		time.Hour:        "1h",
+		24 * time.Hour:   "24h",
+		90 * time.Minute: "90m",
+		30 * time.Second: "30s",
+	}
+	for d, want := range tests {
+		if got := formatWindow(d); got != want {
+			t.Errorf("%s: got %q want %q", d, got, want)
+		}
+	}
+}
+
+func TestStatsRates(t *testing.T) {
+	stats := NewStats()
+	stats.SetRateWindows([]time.Duration{time.Minute, time.Hour})
+	stats.SetStepDifficulty(40, 1)
+
+	stats.AddReport(Report{WorkerNum: "1", Hashes: 1000, Duration: time.Second})
+	stats.AddReport(Report{WorkerNum: "2", Hashes: 3000, Duration: time.Second})
+	for i := 0; i < 3; i++ {
+		stats.AcceptStep(2)
+	}
+	stats.FailStep()
+
+	status := stats.Status()
+	if status.StepsAccepted != 3 || status.SharesEarned != 6 || status.StepsFailed != 1 {
+		t.Errorf("unexpected step counts: %+v", status)
+	}
+	if status.AcceptRatio != 0.75 {
+		t.Errorf("got accept ratio %f want 0.75", status.AcceptRatio)
+	}
+	if len(status.HashRateAvg) != 2 || status.HashRateAvg[0].Window != "1m" || status.HashRateAvg[1].Window != "1h" {
+		t.Fatalf("unexpected averages: %+v", status.HashRateAvg)
+	}
+	// Both workers reported a second of hashing just now
+	if hr := status.HashRateAvg[0].HashRate; hr < 3000 || hr > 4000 {
+		t.Errorf("got 1m average %d want ~4000", hr)
+	}
+	if status.EffectiveHashRate <= 0 || len(status.EffectiveHashRateAvg) != 2 || status.EffectiveHashRateAvg[0].HashRate <= 0 {
+		t.Errorf("no effective hash rate: %d %+v", status.EffectiveHashRate, status.EffectiveHashRateAvg)
+	}
+
+	workers := stats.Workers()
+	if len(workers) != 2 || len(workers[1].HashRateAvg) != 2 {
+		t.Fatalf("unexpected workers: %+v", workers)
+	}
+	if hr := workers[1].HashRateAvg[0].HashRate; hr < 2000 || hr > 3000 {
+		t.Errorf("got worker 2 1m average %d want ~3000", hr)
+	}
+}
@@ -0,0 +1,294 @@
+package miner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// PoolEntry describes a named pool that can be mined with
+// 'noso-go mine pool <name>'
+type PoolEntry struct {
+	Name     string   `yaml:"name" json:"name"`
+	Aliases  []string `yaml:"aliases,omitempty" json:"aliases,omitempty"`
+	Address  string   `yaml:"address" json:"address"`
+	Port     int      `yaml:"port" json:"port"`
+	Password string   `yaml:"password" json:"password"`
+	// Fallback addresses in "host:port" form to try if Address is down
+	Fallback []string `yaml:"fallback,omitempty" json:"fallback,omitempty"`
+	// How the pool and its fallbacks are dialed, see DialOpts
+	Proxy         string `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	TLS           bool   `yaml:"tls,omitempty" json:"tls,omitempty"`
+	TLSCA         string `yaml:"tls-ca,omitempty" json:"tls-ca,omitempty"`
+	TLSSkipVerify bool   `yaml:"tls-skip-verify,omitempty" json:"tls-skip-verify,omitempty"`
+
+	// BuiltIn is true for pools that ship with noso-go
+	BuiltIn bool `yaml:"-" json:"-"`
+}
+
+// PoolRegistry is the on-disk list of user defined pools. Files ending
+// in .json are read and written as JSON, anything else as YAML
+type PoolRegistry struct {
+	Pools []PoolEntry `yaml:"pools" json:"pools"`
+}
+
+// DefaultPools returns the pools that ship with noso-go
+func DefaultPools() []PoolEntry {
+	return []PoolEntry{
+		{
+			Name:     "devnoso",
+			Aliases:  []string{"devnoso", "devnosoeu", "devnoso.eu"},
+			Address:  "DevNosoEU.nosocoin.com",
+			Port:     8082,
+			Password: "UnMaTcHeD",
+			BuiltIn:  true,
+		},
+		{
+			Name:     "leviable",
+			Aliases:  []string{"leviable", "nosodev", "noso.dev", "poolnosodev", "pool.noso.dev"},
+			Address:  "pool.noso.dev",
+			Port:     8082,
+			Password: "password",
+			BuiltIn:  true,
+		},
+		{
+			Name:     "dukedog",
+			Aliases:  []string{"dukedogio", "dukedog.io", "duke"},
+			Address:  "noso.dukedog.io",
+			Port:     8082,
+			Password: "duke",
+			BuiltIn:  true,
+		},
+		{
+			Name:     "russiapool",
+			Aliases:  []string{"russiapool"},
+			Address:  "95.54.44.147",
+			Port:     8082,
+			Password: "RussiaPool",
+			BuiltIn:  true,
+		},
+	}
+}
+
+// LoadPoolRegistry reads the registry at path. A missing file is not an
+// error, it simply yields an empty registry
+func LoadPoolRegistry(path string) (*PoolRegistry, error) {
+	reg := &PoolRegistry{}
+
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return reg, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if isJSON(path) {
+		err = json.Unmarshal(data, reg)
+	} else {
+		err = yaml.Unmarshal(data, reg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse pool registry %s: %v", path, err)
+	}
+
+	for i := range reg.Pools {
+		if err := reg.Pools[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid pool in %s: %v", path, err)
+		}
+	}
+
+	return reg, nil
+}
+
+// Save writes the registry to path, creating parent directories as needed
+func (r *PoolRegistry) Save(path string) error {
+	var (
+		data []byte
+		err  error
+	)
+
+	if isJSON(path) {
+		data, err = json.MarshalIndent(r, "", "  ")
+	} else {
+		data, err = yaml.Marshal(r)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Add appends a pool to the registry. Names and aliases must not collide
+// with any other pool in the registry
+func (r *PoolRegistry) Add(entry PoolEntry) error {
+	entry.Name = strings.ToLower(entry.Name)
+	if err := entry.validate(); err != nil {
+		return err
+	}
+
+	for _, p := range r.Pools {
+		for _, name := range entry.names() {
+			if p.matches(name) {
+				return fmt.Errorf("pool %q is already registered by pool %q", name, p.Name)
+			}
+		}
+	}
+
+	r.Pools = append(r.Pools, entry)
+	return nil
+}
+
+// CheckBuiltInNames checks that a pool about to be registered doesn't
+// take a name or alias of a built in pool, other than by replacing the
+// built in pool of the same name
+func CheckBuiltInNames(entry PoolEntry, builtIn []PoolEntry) error {
+	for _, b := range builtIn {
+		if strings.EqualFold(entry.Name, b.Name) {
+			continue
+		}
+		for _, name := range entry.names() {
+			if b.matches(name) {
+				return fmt.Errorf("%q is a name of the built in pool %q, use 'noso-go pool add %s' to replace it", name, b.Name, b.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Remove deletes the pool with the given name or alias from the registry
+func (r *PoolRegistry) Remove(name string) error {
+	for i, p := range r.Pools {
+		if p.matches(name) {
+			r.Pools = append(r.Pools[:i], r.Pools[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("pool %q is not in the registry", name)
+}
+
+// MergePools combines the built in pools with the user registry, keyed by
+// lower case name and alias. User pools replace built in pools of the same
+// name, and take over any other name they share with one
+func MergePools(defaults []PoolEntry, reg *PoolRegistry) map[string]PoolEntry {
+	pools := make(map[string]PoolEntry)
+
+	add := func(entries []PoolEntry) {
+		for _, p := range entries {
+			// Drop any aliases left behind by a pool this one replaces.
+			// Sharing an alias with a pool doesn't replace it
+			for name, old := range pools {
+				if strings.EqualFold(old.Name, p.Name) {
+					delete(pools, name)
+				}
+			}
+			for _, name := range p.names() {
+				pools[name] = p
+			}
+		}
+	}
+
+	add(defaults)
+	if reg != nil {
+		add(reg.Pools)
+	}
+
+	return pools
+}
+
+// UniquePools returns each pool in pools once, sorted by name
+func UniquePools(pools map[string]PoolEntry) []PoolEntry {
+	seen := make(map[string]bool)
+	unique := make([]PoolEntry, 0)
+
+	for _, p := range pools {
+		if seen[p.Name] {
+			continue
+		}
+		seen[p.Name] = true
+		unique = append(unique, p)
+	}
+
+	sort.Slice(unique, func(i, j int) bool { return unique[i].Name < unique[j].Name })
+
+	return unique
+}
+
+// DialOpts returns how the pool is dialed
+func (p PoolEntry) DialOpts() DialOpts {
+	return DialOpts{
+		Proxy:         p.Proxy,
+		TLS:           p.TLS,
+		TLSCA:         p.TLSCA,
+		TLSSkipVerify: p.TLSSkipVerify,
+	}
+}
+
+// Endpoints returns the pool followed by its fallbacks, which share its
+// password and default to its port
+func (p PoolEntry) Endpoints() []PoolEndpoint {
+	pools := []PoolEndpoint{{Address: p.Address, Port: p.Port, Password: p.Password, Dial: p.DialOpts()}}
+	for _, fb := range p.Fallback {
+		host, port, err := splitHostPort(fb, p.Port)
+		if err != nil {
+			continue
+		}
+		pools = append(pools, PoolEndpoint{Address: host, Port: port, Password: p.Password, Dial: p.DialOpts()})
+	}
+	return pools
+}
+
+func (p PoolEntry) names() []string {
+	names := []string{strings.ToLower(p.Name)}
+	for _, alias := range p.Aliases {
+		names = append(names, strings.ToLower(alias))
+	}
+	return names
+}
+
+func (p PoolEntry) matches(name string) bool {
+	name = strings.ToLower(name)
+	for _, n := range p.names() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p PoolEntry) validate() error {
+	if p.Name == "" {
+		return errors.New("pool name cannot be empty")
+	}
+	if p.Address == "" {
+		return fmt.Errorf("pool %q has no address", p.Name)
+	}
+	if p.Port < 1 || p.Port > 65535 {
+		return fmt.Errorf("pool %q has invalid port %d", p.Name, p.Port)
+	}
+	for _, fb := range p.Fallback {
+		if _, _, err := splitHostPort(fb, p.Port); err != nil {
+			return fmt.Errorf("pool %q has invalid fallback address %q: %v", p.Name, fb, err)
+		}
+	}
+	if err := p.DialOpts().Validate(); err != nil {
+		return fmt.Errorf("pool %q: %v", p.Name, err)
+	}
+	return nil
+}
+
+func isJSON(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
@@ -0,0 +1,174 @@
+package miner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPoolRegistryRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "noso-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"pools.yaml", "pools.json"} {
+		path := filepath.Join(dir, name)
+
+		reg, err := LoadPoolRegistry(path)
+		if err != nil {
+			t.Fatalf("%s: unexpected error loading missing file: %v", name, err)
+		}
+		if len(reg.Pools) != 0 {
+			t.Fatalf("%s: got %d pools want 0", name, len(reg.Pools))
+		}
+
+		entry := PoolEntry{
+			Name:     "MyPool",
+			Aliases:  []string{"mp"},
+			Address:  "pool.example.com",
+			Port:     8082,
+			Password: "secret",
+			Fallback: []string{"backup.example.com:9000", "backup2.example.com"},
+		}
+		if err := reg.Add(entry); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := reg.Save(path); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		got, err := LoadPoolRegistry(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		entry.Name = "mypool"
+		if !reflect.DeepEqual(got.Pools, []PoolEntry{entry}) {
+			t.Errorf("%s: got %+v want %+v", name, got.Pools, []PoolEntry{entry})
+		}
+	}
+}
+
+func TestPoolRegistryAddRemove(t *testing.T) {
+	reg := &PoolRegistry{}
+	entry := PoolEntry{Name: "mypool", Aliases: []string{"mp"}, Address: "127.0.0.1", Port: 8082}
+
+	if err := reg.Add(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	conflicts := []PoolEntry{
+		{Name: "MYPOOL", Address: "127.0.0.1", Port: 8082},
+		{Name: "other", Aliases: []string{"mp"}, Address: "127.0.0.1", Port: 8082},
+	}
+	for _, c := range conflicts {
+		if err := reg.Add(c); err == nil {
+			t.Errorf("expected error adding %+v", c)
+		}
+	}
+
+	invalid := []PoolEntry{
+		{Name: "", Address: "127.0.0.1", Port: 8082},
+		{Name: "noaddr", Port: 8082},
+		{Name: "badport", Address: "127.0.0.1", Port: 70000},
+		{Name: "badfallback", Address: "127.0.0.1", Port: 8082, Fallback: []string{"host:port"}},
+	}
+	for _, i := range invalid {
+		if err := reg.Add(i); err == nil {
+			t.Errorf("expected error adding %+v", i)
+		}
+	}
+
+	if err := reg.Remove("MP"); err != nil {
+		t.Fatalf("unexpected error removing by alias: %v", err)
+	}
+	if err := reg.Remove("mypool"); err == nil {
+		t.Error("expected error removing missing pool")
+	}
+}
+
+func TestMergePools(t *testing.T) {
+	reg := &PoolRegistry{
+		Pools: []PoolEntry{
+			{Name: "devnoso", Address: "10.0.0.1", Port: 9000, Password: "mine"},
+			{Name: "private", Aliases: []string{"priv"}, Address: "10.0.0.2", Port: 8082},
+		},
+	}
+
+	pools := MergePools(DefaultPools(), reg)
+
+	if p := pools["devnoso"]; p.Address != "10.0.0.1" || p.BuiltIn {
+		t.Errorf("user pool should replace built in pool, got %+v", p)
+	}
+	if _, ok := pools["devnoso.eu"]; ok {
+		t.Error("aliases of a replaced built in pool should be dropped")
+	}
+	if p := pools["priv"]; p.Name != "private" {
+		t.Errorf("alias lookup failed, got %+v", p)
+	}
+	if p := pools["duke"]; p.Name != "dukedog" || !p.BuiltIn {
+		t.Errorf("built in alias lookup failed, got %+v", p)
+	}
+
+	if got, want := len(UniquePools(pools)), len(DefaultPools())+1; got != want {
+		t.Errorf("got %d unique pools want %d", got, want)
+	}
+
+	// A pool named after an alias of a built in pool only takes that name
+	reg = &PoolRegistry{Pools: []PoolEntry{{Name: "duke", Address: "10.0.0.3", Port: 8082}}}
+	pools = MergePools(DefaultPools(), reg)
+	if p := pools["duke"]; p.Address != "10.0.0.3" {
+		t.Errorf("got %+v for the user pool", p)
+	}
+	for _, name := range []string{"dukedog", "dukedog.io"} {
+		if p := pools[name]; p.Name != "dukedog" || !p.BuiltIn {
+			t.Errorf("got %+v for %s", p, name)
+		}
+	}
+}
+
+func TestCheckBuiltInNames(t *testing.T) {
+	allowed := []PoolEntry{
+		{Name: "mypool", Aliases: []string{"mp"}},
+		{Name: "DukeDog", Aliases: []string{"duke"}},
+	}
+	for _, p := range allowed {
+		if err := CheckBuiltInNames(p, DefaultPools()); err != nil {
+			t.Errorf("%+v: %v", p, err)
+		}
+	}
+
+	taken := []PoolEntry{
+		{Name: "duke"},
+		{Name: "mypool", Aliases: []string{"devnoso.eu"}},
+		{Name: "mypool", Aliases: []string{"RussiaPool"}},
+	}
+	for _, p := range taken {
+		if err := CheckBuiltInNames(p, DefaultPools()); err == nil {
+			t.Errorf("no error for %+v", p)
+		}
+	}
+}
+
+func TestPoolEntryEndpoints(t *testing.T) {
+	p := PoolEntry{
+		Name:     "mypool",
+		Address:  "pool.example.com",
+		Port:     8082,
+		Password: "secret",
+		Fallback: []string{"backup.example.com:9000", "backup2.example.com"},
+	}
+
+	got := p.Endpoints()
+	want := []PoolEndpoint{
+		{Address: "pool.example.com", Port: 8082, Password: "secret"},
+		{Address: "backup.example.com", Port: 9000, Password: "secret"},
+		{Address: "backup2.example.com", Port: 8082, Password: "secret"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+}
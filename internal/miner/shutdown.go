@@ -0,0 +1,120 @@
+package miner
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
+)
+
+// shutdownTimeout bounds how long drain waits for the pool
+const shutdownTimeout = 5 * time.Second
+
+// drain waits for the miners to finish their jobs, has the solution
+// manager send what they found, and waits for the pool to answer every
+// step sent so the session summary is complete. The miners must already
+// have been told to stop
+func drain(comms *Comms, client *TcpClient, stats *Stats, queue *stepQueue, rule ShareRule, miners *sync.WaitGroup, solutionsSent <-chan struct{}) {
+	minersDone := make(chan struct{}, 0)
+	go func() {
+		miners.Wait()
+		close(minersDone)
+	}()
+
+	deadline := time.After(shutdownTimeout)
+	sent := false
+
+	for {
+		pending := queue.pending()
+		if sent && pending <= 0 {
+			return
+		}
+
+		select {
+		case report := <-comms.Reports:
+			stats.AddReport(report)
+		case <-minersDone:
+			// Nothing else will be found, let the solution manager finish
+			minersDone = nil
+			close(comms.Solutions)
+		case <-solutionsSent:
+			solutionsSent = nil
+			sent = true
+		case shares := <-comms.StepSolved:
+			stepAnswered(queue, stats, rule, true, shares)
+		case <-comms.StepFailed:
+			stepAnswered(queue, stats, rule, false, 0)
+		case <-client.Done():
+			// Nothing can be sent or answered any more
+			return
+		case <-deadline:
+			if sent {
+				logging.Warnf("The pool didn't answer %d step(s) in time\n", pending)
+			} else {
+				logging.Warnf("Could not send every solution to the pool in time\n")
+			}
+			return
+		}
+	}
+}
+
+// printSummary logs the totals of a mining session
+func printSummary(status MinerStatus) {
+	uptime := time.Since(status.Started)
+	avg := 0
+	if uptime > 0 {
+		avg = int(float64(status.TotalHashes) / uptime.Seconds())
+	}
+	uptime = uptime.Round(time.Second)
+
+	if logging.Default().Format() == logging.FormatJSON {
+		logging.Infof("Session summary: uptime %s, total hashes %d, average hash rate %d, effective hash rate %d, stale hashing %s in %d job(s), bad solutions %d, workers stopped %d, PoP sent %d, accepted %d, failed %d, resent %d, lost %d, accept ratio %.3f, shares earned %d, shares expected %d, share discrepancies %d",
+			uptime, status.TotalHashes, avg, status.EffectiveHashRate, status.StaleTime.Round(time.Millisecond), status.StaleJobs, status.BadSolutions, status.WorkersDisabled, status.StepsSent, status.StepsAccepted, status.StepsFailed, status.StepsResent, status.StepsDiscarded, status.AcceptRatio, status.SharesEarned, status.SharesExpected, status.ShareDiscrepancies)
+		return
+	}
+	logging.Infof(
+		summaryMsg,
+		uptime,
+		status.TotalHashes,
+		formatHashRate(strconv.Itoa(avg)),
+		formatHashRate(strconv.Itoa(status.EffectiveHashRate)),
+		status.StaleTime.Round(time.Millisecond),
+		status.StaleJobs,
+		status.BadSolutions,
+		status.WorkersDisabled,
+		status.StepsSent,
+		status.StepsAccepted,
+		status.StepsFailed,
+		status.StepsResent,
+		status.StepsDiscarded,
+		status.AcceptRatio*100,
+		status.SharesEarned,
+		status.SharesExpected,
+		status.ShareDiscrepancies,
+	)
+}
+
+const summaryMsg = `
+************************************
+
+Session Summary
+
+Uptime              : %s
+Total Hashes        : %d
+Average Hash Rate   : %s
+Effective Hash Rate : %s
+Stale Hashing       : %s in %d job(s)
+Bad Solutions       : %d (%d worker(s) stopped)
+
+PoP Sent            : %d
+PoP Accepted        : %d
+PoP Failed          : %d
+PoP Resent          : %d
+PoP Lost            : %d
+PoP Accept Ratio    : %.1f%%
+Shares Earned       : %d (%d expected, %d step(s) credited differently)
+
+************************************
+
+`
@@ -0,0 +1,196 @@
+package miner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/mockpool"
+)
+
+// miningPool starts a mock pool with an easy target and returns the
+// options to mine on it
+func miningPool(t *testing.T) (*mockpool.Server, *Opts) {
+	t.Helper()
+
+	cfg := mockpool.DefaultConfig()
+	cfg.TargetChars = 4
+	cfg.Diff = 30
+	cfg.PoolDepth = 1
+
+	pool, endpoint := startMockPool(t, cfg)
+	return pool, &Opts{
+		IpAddr:         endpoint.Address,
+		IpPort:         endpoint.Port,
+		PoolPw:         endpoint.Password,
+		Wallets:        []string{"wallet"},
+		Cpu:            1,
+		StatusInterval: 60,
+	}
+}
+
+// inTempDir runs the test in an empty directory, so payments.csv ends up
+// there
+func inTempDir(t *testing.T) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "noso-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	})
+}
+
+func TestMineShutdown(t *testing.T) {
+	inTempDir(t)
+	pool, opts := miningPool(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Mine(ctx, opts) }()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for pool.Stats().StepsAccepted == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no steps accepted")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Mine returned %v", err)
+		}
+	case <-time.After(shutdownTimeout + 10*time.Second):
+		t.Fatal("Mine didn't return after it was cancelled")
+	}
+
+	// The miners have stopped and the connection is closed. Let the pool
+	// work through what was sent before it first
+	steps := len(pool.Steps())
+	for settled := time.Now().Add(10 * time.Second); time.Now().Before(settled); {
+		time.Sleep(250 * time.Millisecond)
+		n := len(pool.Steps())
+		if n == steps {
+			break
+		}
+		steps = n
+	}
+	time.Sleep(500 * time.Millisecond)
+	if got := len(pool.Steps()); got != steps {
+		t.Errorf("pool got %d steps after Mine returned", got-steps)
+	}
+}
+
+func TestExitOnRetry(t *testing.T) {
+	pool, opts := miningPool(t)
+	opts.ExitOnRetry = true
+
+	client := NewTcpClient(opts, NewComms(), NewStats(), false, true)
+	recvLine(t, client, JOINOK)
+	pool.Close()
+
+	select {
+	case <-client.Done():
+	case <-time.After(connectionTimeout + 10*time.Second):
+		t.Fatal("client didn't stop after the connection was lost")
+	}
+	if err := client.Err(); err != ErrConnectionLost {
+		t.Errorf("got %v want %v", err, ErrConnectionLost)
+	}
+}
+
+func TestMineExitOnRetry(t *testing.T) {
+	inTempDir(t)
+	pool, opts := miningPool(t)
+	opts.ExitOnRetry = true
+
+	done := make(chan error, 1)
+	go func() { done <- Mine(context.Background(), opts) }()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for pool.Stats().StepsAccepted == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no steps accepted")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	// The workers keep reporting their hash rate after the connection is
+	// lost
+	pool.Close()
+
+	select {
+	case err := <-done:
+		if err != ErrConnectionLost {
+			t.Errorf("got %v want %v", err, ErrConnectionLost)
+		}
+	case <-time.After(connectionTimeout + shutdownTimeout + 10*time.Second):
+		t.Fatal("Mine didn't return after the connection was lost")
+	}
+}
+
+func TestExitOnAuthFailure(t *testing.T) {
+	pool, opts := miningPool(t)
+	pool.SetPassFailed(true)
+	opts.ExitOnRetry = true
+
+	stats := NewStats()
+	client := NewTcpClient(opts, NewComms(), stats, false, true)
+	recvLine(t, client, PASSFAILED)
+
+	select {
+	case <-client.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("client didn't stop after the password was rejected")
+	}
+	if err := client.Err(); err != ErrAuthFailed {
+		t.Errorf("got %v want %v", err, ErrAuthFailed)
+	}
+	if status := stats.Status(); status.Connection != ConnAuthFailed || status.AuthFailures != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	pool, opts := miningPool(t)
+
+	client := NewTcpClient(opts, NewComms(), NewStats(), false, true)
+	recvLine(t, client, JOINOK)
+
+	client.SendChan <- "STATUS"
+	recvLine(t, client, STATUS)
+	client.SendChan <- "STATUS"
+	client.Close(time.Second)
+
+	select {
+	case <-client.Done():
+	default:
+		t.Fatal("client is still running after Close")
+	}
+	if err := client.Err(); err != nil {
+		t.Errorf("got %v after Close", err)
+	}
+
+	// The second STATUS was sent before the connection was closed
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Stats().Status != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool got %d STATUS requests want 2", pool.Stats().Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
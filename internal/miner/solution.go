@@ -1,31 +1,11 @@
 package miner
 
 import (
-	"fmt"
-	"log"
+	"github.com/Noso-Project/noso-go/internal/logging"
 )
 
-func NewSolutionComms(sendChan chan string) *SolutionComms {
-	return &SolutionComms{
-		Block:    make(chan int, 0),
-		Step:     make(chan int, 0),
-		Diff:     make(chan int, 0),
-		Solution: make(chan Solution, 0),
-		SendChan: sendChan,
-		StepSent: make(chan struct{}, 0),
-	}
-}
-
-type SolutionComms struct {
-	Block    chan int
-	Step     chan int
-	Diff     chan int
-	Solution chan Solution
-	SendChan chan string
-	StepSent chan struct{}
-}
-
 type Solution struct {
+	PoolAddr   string
 	Seed       string
 	HashStr    string
 	Block      int
@@ -35,51 +15,52 @@ type Solution struct {
 	TargetLen  int
 	Target     string
 	FullTarget string
+	// MatchTarget is the part of the pool target the job was matched
+	// against, Target is a prefix of it
+	MatchTarget string
+	Diff        int
+	PoolDepth   int
+	Worker      string
 }
 
-func SolutionManager(solComms *SolutionComms, showPop bool) {
-	var (
-		block int
-		diff  int
-		sol   Solution
-	)
+// SolutionManager sends the solutions found by the miners to the pool
+// through queue, dropping those that were mined for an older block or
+// another pool, and those checker finds wrong
+func SolutionManager(comms *Comms, queue *stepQueue, checker *solutionChecker, stats *Stats, showPop bool) {
+	for sol := range comms.Solutions {
+		state, _ := comms.State.Current()
 
-	for {
-		select {
-		case newBlock := <-solComms.Block:
-			// log.Println("Block is: ", block)
-			if newBlock != block {
-				block = newBlock
+		if sol.PoolAddr != state.PoolAddr {
+			// Drop solutions mined for another pool, or while there is
+			// no pool to send them to
+			logging.Infof("Dropping Solution (old pool): %+v\n", sol)
+			continue
+		} else if sol.Block != state.Block {
+			// Drop stale solutions
+			logging.Infof("Dropping Solution (old block): %+v\n", sol)
+			continue
+		} else if !checker.check(sol) {
+			continue
+		} else if sol.TargetLen <= sol.Chars-2 {
+			// PoP solution
+			if showPop {
+				printFoundSolution(sol, false)
 			}
-		case _ = <-solComms.Step:
-		case diff = <-solComms.Diff:
-		case sol = <-solComms.Solution:
-			// log.Printf("Solution is: %+v\n", sol)
-			if sol.Block != block {
-				// Drop stale solutions
-				log.Printf("Dropping Solution (old block): %+v\n", sol)
-				continue
-			} else if sol.TargetLen <= sol.Chars-2 {
-				// PoP solution
-				if showPop {
-					printFoundSolution(sol, false)
-				}
-			} else if sol.TargetLen == sol.Chars-1 && diff%10 == 0 {
-				// PoP solution
-				// When diff%10 == 0, there are no low steps
-				if showPop {
-					printFoundSolution(sol, false)
-				}
-			} else if sol.TargetLen == sol.Chars-1 && diff%10 != 0 {
-				// Low step solution
-				printFoundSolution(sol, true)
-			} else {
-				// High step solution
-				printFoundSolution(sol, true)
+		} else if sol.TargetLen == sol.Chars-1 && state.Diff%10 == 0 {
+			// PoP solution
+			// When diff%10 == 0, there are no low steps
+			if showPop {
+				printFoundSolution(sol, false)
 			}
-			solComms.SendChan <- fmt.Sprintf("STEP %d %s %s %d %s", sol.Block, sol.Seed, sol.HashStr, sol.TargetLen, instanceId)
-			solComms.StepSent <- struct{}{}
+		} else if sol.TargetLen == sol.Chars-1 && state.Diff%10 != 0 {
+			// Low step solution
+			printFoundSolution(sol, true)
+		} else {
+			// High step solution
+			printFoundSolution(sol, true)
 		}
+		stats.Update(func(s *MinerStatus) { s.StepsSent++ })
+		queue.send(sol, state)
 	}
 }
 
@@ -88,7 +69,12 @@ func printFoundSolution(sol Solution, isStep bool) {
 	if isStep {
 		stepOrPop = "STEP"
 	}
-	log.Printf(
+	if logging.Default().Format() == logging.FormatJSON {
+		logging.Infof("Found %s solution: block %d, step %d, seed %s, hashed string %s, sha256 %s, target len %d, target %s, full target %s",
+			stepOrPop, sol.Block, sol.Step, sol.Seed, sol.HashStr, sol.SolvedHash, sol.TargetLen, sol.Target, sol.FullTarget)
+		return
+	}
+	logging.Infof(
 		found_one,
 		stepOrPop,
 		sol.Block,
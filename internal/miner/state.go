@@ -0,0 +1,94 @@
+package miner
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// PoolState is everything the pool has told the miner about the current
+// session and block. A PoolState is never modified once it has been
+// published, so whoever holds one sees a block, target and difficulty
+// that belong together
+type PoolState struct {
+	// Set by JOINOK, and the same for the whole session. Session counts
+	// the JOINOKs, so a rejoin tells apart sessions that are otherwise
+	// the same
+	PoolAddr  string
+	MinerSeed string
+	SeedSlot  string
+	Session   int
+
+	// Set by JOINOK and POOLSTEPS
+	Block             int
+	TargetString      string
+	TargetChars       int
+	Step              int
+	Diff              int
+	Balance           string
+	BlocksTillPayment int
+	PoolHashRate      int64
+	PoolDepth         int
+}
+
+// Ready reports whether the state has everything needed to build jobs
+func (s *PoolState) Ready() bool {
+	return s.PoolAddr != "" && len(s.MinerSeed) >= 3 && s.Block != 0 && s.Step >= 0 &&
+		s.Diff != 0 && s.TargetChars != 0 && s.TargetString != "" && s.PoolDepth != 0
+}
+
+// SameSession reports whether s and o come from the same pool join, so
+// work done for one is still valid for the other
+func (s *PoolState) SameSession(o *PoolState) bool {
+	return s.Session == o.Session && s.PoolAddr == o.PoolAddr && s.MinerSeed == o.MinerSeed && s.SeedSlot == o.SeedSlot
+}
+
+// emptyPoolState is the state before a pool has been joined. Step is the
+// only number that can actually be 0
+var emptyPoolState = PoolState{Balance: "0", Step: -1}
+
+type poolStateVersion struct {
+	state   *PoolState
+	changed chan struct{}
+}
+
+// PoolStateFeed holds the latest PoolState and lets any number of
+// goroutines wait for the next one
+type PoolStateFeed struct {
+	mu      sync.Mutex
+	current atomic.Value // poolStateVersion
+}
+
+func NewPoolStateFeed() *PoolStateFeed {
+	f := &PoolStateFeed{}
+	state := emptyPoolState
+	f.current.Store(poolStateVersion{state: &state, changed: make(chan struct{}, 0)})
+	return f
+}
+
+// Current returns the latest state, and a channel that is closed once it
+// has been replaced
+func (f *PoolStateFeed) Current() (*PoolState, <-chan struct{}) {
+	v := f.current.Load().(poolStateVersion)
+	return v.state, v.changed
+}
+
+// Update publishes a copy of the current state modified by fn
+func (f *PoolStateFeed) Update(fn func(state *PoolState)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v := f.current.Load().(poolStateVersion)
+	state := *v.state
+	fn(&state)
+	f.current.Store(poolStateVersion{state: &state, changed: make(chan struct{}, 0)})
+	close(v.changed)
+}
+
+// Reset publishes an empty state, used when the pool connection is lost
+func (f *PoolStateFeed) Reset() {
+	f.Update(func(state *PoolState) {
+		session := state.Session
+		*state = emptyPoolState
+		state.Session = session
+	})
+}
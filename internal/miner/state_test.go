@@ -0,0 +1,183 @@
+package miner
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+const (
+	testJoinOK    = "JOINOK N4ZR3fKhTUod34evnEcDQX3i6XufBDU 1abc!!! PoolData 100 ABCDEF0123 9 2 55 12345 0 1500 3"
+	testPoolSteps = "POOLSTEPS PoolData 101 bbccdd4455 10 0 66 23456 1 1600 3"
+)
+
+func TestPoolStateFeed(t *testing.T) {
+	feed := NewPoolStateFeed()
+
+	state, changed := feed.Current()
+	if state.Ready() {
+		t.Fatalf("empty state is ready: %+v", state)
+	}
+
+	feed.Update(func(s *PoolState) { s.Block = 7 })
+
+	select {
+	case <-changed:
+	default:
+		t.Fatal("changed was not closed by Update")
+	}
+	if state.Block != 0 {
+		t.Errorf("Update modified a published state: %+v", state)
+	}
+
+	next, nextChanged := feed.Current()
+	if next.Block != 7 {
+		t.Errorf("got block %d want 7", next.Block)
+	}
+	select {
+	case <-nextChanged:
+		t.Fatal("changed of the latest state is closed")
+	default:
+	}
+
+	feed.Reset()
+	if state, _ := feed.Current(); state.Block != 0 || state.Step != -1 {
+		t.Errorf("Reset left %+v", state)
+	}
+}
+
+func TestParsePoolState(t *testing.T) {
+	comms := NewComms()
+
+	Parse(comms, "pool", testJoinOK, nil)
+	state, _ := comms.State.Current()
+	want := PoolState{
+		PoolAddr:          "N4ZR3fKhTUod34evnEcDQX3i6XufBDU",
+		MinerSeed:         "1abc!!!",
+		Session:           1,
+		Block:             100,
+		TargetString:      "abcdef0123",
+		TargetChars:       9,
+		Step:              2,
+		Diff:              55,
+		Balance:           "12345",
+		BlocksTillPayment: 0,
+		PoolHashRate:      1500000,
+		PoolDepth:         3,
+	}
+	if *state != want {
+		t.Fatalf("after JOINOK got %+v want %+v", *state, want)
+	}
+	if !state.Ready() {
+		t.Error("state is not ready after JOINOK")
+	}
+
+	Parse(comms, "pool", testPoolSteps, nil)
+	next, _ := comms.State.Current()
+	if !next.SameSession(state) || next.Block != 101 || next.TargetString != "bbccdd4455" || next.Step != 0 || next.BlocksTillPayment != 1 {
+		t.Errorf("after POOLSTEPS got %+v", *next)
+	}
+	if state.Block != 100 {
+		t.Errorf("POOLSTEPS modified the JOINOK state: %+v", *state)
+	}
+
+	// Rejoining with the same seed is still a new session
+	Parse(comms, "pool", testJoinOK, nil)
+	if rejoined, _ := comms.State.Current(); rejoined.SameSession(state) {
+		t.Errorf("rejoin kept the session: %+v", *rejoined)
+	}
+}
+
+func TestParseLinesKeepsPoolStateOrder(t *testing.T) {
+	comms := NewComms()
+	client := parsingClient(t, comms)
+
+	state, changed := comms.State.Current()
+	client.RecvChan <- testJoinOK
+	for block := 101; block <= 150; block++ {
+		client.RecvChan <- fmt.Sprintf("POOLSTEPS PoolData %d bbccdd4455 10 0 66 23456 1 1600 3", block)
+	}
+
+	deadline := time.After(5 * time.Second)
+	for state.Block != 150 {
+		select {
+		case <-changed:
+		case <-deadline:
+			t.Fatalf("got block %d want 150", state.Block)
+		}
+		prev := state.Block
+		state, changed = comms.State.Current()
+		if state.Block < prev {
+			t.Fatalf("block went back from %d to %d", prev, state.Block)
+		}
+	}
+
+	// Nothing older arrives after the last state
+	time.Sleep(100 * time.Millisecond)
+	if state, _ := comms.State.Current(); state.Block != 150 {
+		t.Errorf("got block %d after the last POOLSTEPS", state.Block)
+	}
+}
+
+func TestParseLinesSkipsUnaskedStatus(t *testing.T) {
+	comms := NewComms()
+	client := parsingClient(t, comms)
+
+	state, changed := comms.State.Current()
+	client.RecvChan <- testStatus
+	client.RecvChan <- testJoinOK
+	client.RecvChan <- PONG
+	client.RecvChan <- testJoinOK
+
+	deadline := time.After(5 * time.Second)
+	for state.Session != 2 {
+		select {
+		case <-changed:
+		case <-deadline:
+			t.Fatalf("parsing stopped at session %d", state.Session)
+		}
+		state, changed = comms.State.Current()
+	}
+}
+
+func TestJobFeederFollowsPoolState(t *testing.T) {
+	comms := NewComms()
+	go JobFeeder(comms)
+
+	Parse(comms, "pool", testJoinOK, nil)
+	if job := <-comms.Jobs; job.Block != 100 || job.TargetString != "abcdef0123" {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+
+	Parse(comms, "pool", testPoolSteps, nil)
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case job := <-comms.Jobs:
+			if job.Block == 100 && job.TargetString == "abcdef0123" {
+				// Built before the update
+				continue
+			}
+			if job.Block != 101 || job.TargetString != "bbccdd4455" || job.TargetChars != 10 || job.Diff != 66 {
+				t.Fatalf("job mixes pool states: %+v", job)
+			}
+		case <-deadline:
+			t.Fatal("timed out waiting for a job for the new block")
+		}
+		break
+	}
+
+	// No jobs once the connection is gone
+	comms.State.Reset()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-comms.Jobs:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	select {
+	case job := <-comms.Jobs:
+		t.Fatalf("got a job while disconnected: %+v", job)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,329 @@
+package miner
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Pool connection states reported in MinerStatus. ConnAuthFailed means
+// the pool answered PASSFAILED and the client is waiting before it tries
+// again
+const (
+	ConnConnecting   = "connecting"
+	ConnConnected    = "connected"
+	ConnJoined       = "joined"
+	ConnDisconnected = "disconnected"
+	ConnAuthFailed   = "auth_failed"
+)
+
+// MinerStatus is a point in time snapshot of a running miner
+type MinerStatus struct {
+	Wallet            string    `json:"wallet"`
+	Pool              string    `json:"pool"`
+	Connection        string    `json:"connection"`
+	Started           time.Time `json:"started"`
+	Block             int       `json:"block"`
+	Step              int       `json:"step"`
+	Diff              int       `json:"diff"`
+	HashRate          int       `json:"hash_rate"`
+	TotalHashes       int       `json:"total_hashes"`
+	PoolHashRate      int64     `json:"pool_hash_rate"`
+	Balance           string    `json:"balance"`
+	BlocksTillPayment int       `json:"blocks_till_payment"`
+	StepsSent         int       `json:"pop_sent"`
+	StepsAccepted     int       `json:"pop_accepted"`
+	StepsFailed       int       `json:"pop_failed"`
+	SharesEarned      int       `json:"shares_earned"`
+	Reconnects        int       `json:"reconnects"`
+	WatchdogTriggers  int       `json:"watchdog_triggers"`
+	PoolSwitches      int       `json:"pool_switches"`
+	AuthFailures      int       `json:"auth_failures"`
+
+	// Steps not answered yet, steps sent again after a reconnect, and
+	// steps lost because their block was over by the time the miner
+	// rejoined, see stepQueue
+	StepsPending   int `json:"pop_pending"`
+	StepsResent    int `json:"pop_resent"`
+	StepsDiscarded int `json:"pop_discarded"`
+
+	// Jobs the workers were still hashing when a new block or step made
+	// them stale, and the hashing time spent on them after that
+	StaleJobs int           `json:"stale_jobs"`
+	StaleTime time.Duration `json:"stale_time_ns"`
+
+	// Solutions that failed verification and weren't sent, and the
+	// workers stopped for finding too many of them
+	BadSolutions    int `json:"bad_solutions"`
+	WorkersDisabled int `json:"workers_disabled"`
+
+	// The shares the accepted steps should have earned, and how many of
+	// them the pool credited differently, see ShareRule
+	SharesExpected     int `json:"shares_expected"`
+	ShareDiscrepancies int `json:"share_discrepancies"`
+
+	// Filled in by Status. The effective hash rate is what the accepted
+	// steps prove was hashed, and AcceptRatio is the part of the answered
+	// steps the pool accepted
+	HashRateAvg          []RateAverage `json:"hash_rate_avg"`
+	EffectiveHashRate    int           `json:"effective_hash_rate"`
+	EffectiveHashRateAvg []RateAverage `json:"effective_hash_rate_avg"`
+	AcceptRatio          float64       `json:"accept_ratio"`
+}
+
+// WorkerStatus is the most recent Report from a single mining goroutine,
+// and its hash rate averages
+type WorkerStatus struct {
+	Worker      string        `json:"worker"`
+	Hashes      int           `json:"hashes"`
+	Duration    time.Duration `json:"duration_ns"`
+	HashRate    int           `json:"hash_rate"`
+	HashRateAvg []RateAverage `json:"hash_rate_avg"`
+
+	BadSolutions int  `json:"bad_solutions"`
+	Disabled     bool `json:"disabled"`
+}
+
+// Stats collects the state of a running miner so it can be read by the
+// status printer and the status API without racing the mining loop
+type Stats struct {
+	mu      sync.RWMutex
+	status  MinerStatus
+	workers map[string]Report
+	bad     map[string]int
+	stopped map[string]bool
+	events  []PoolEvent
+
+	windows  []time.Duration
+	rates    map[string]*rateHistory
+	accepted rateHistory
+	work     float64
+	stepWork float64
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		status: MinerStatus{
+			Connection: ConnConnecting,
+			Started:    time.Now(),
+			Balance:    parseAmount("0"),
+		},
+		workers: make(map[string]Report),
+		bad:     make(map[string]int),
+		stopped: make(map[string]bool),
+		windows: DefaultRateWindows,
+		rates:   make(map[string]*rateHistory),
+	}
+}
+
+// SetRateWindows sets the periods hash rates are averaged over
+func (s *Stats) SetRateWindows(windows []time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.windows = append([]time.Duration{}, windows...)
+}
+
+// SetStepDifficulty sets the pool difficulty and depth that steps accepted
+// from now on were found at
+func (s *Stats) SetStepDifficulty(diff, depth int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stepWork = stepHashes(diff, depth)
+}
+
+// AcceptStep records a step accepted by the pool
+func (s *Stats) AcceptStep(shares int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.StepsAccepted++
+	s.status.SharesEarned += shares
+	s.work += s.stepWork
+
+	now := time.Now()
+	s.accepted.add(rateSample{start: now, end: now, hashes: s.stepWork}, maxWindow(s.windows))
+}
+
+// AuditStep records the shares expected for an accepted step the pool
+// credited shares for
+func (s *Stats) AuditStep(expected, shares int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.SharesExpected += expected
+	if shares != expected {
+		s.status.ShareDiscrepancies++
+	}
+}
+
+// FailStep records a step rejected by the pool
+func (s *Stats) FailStep() {
+	s.Update(func(status *MinerStatus) { status.StepsFailed++ })
+}
+
+// Update calls fn with the current status while holding the write lock
+func (s *Stats) Update(fn func(status *MinerStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(&s.status)
+}
+
+func (s *Stats) SetConnection(state string) {
+	s.Update(func(status *MinerStatus) { status.Connection = state })
+}
+
+// AddReport records a worker report and returns the new total hash rate
+func (s *Stats) AddReport(report Report) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.workers[report.WorkerNum] = report
+
+	end := time.Now()
+	h, ok := s.rates[report.WorkerNum]
+	if !ok {
+		h = &rateHistory{}
+		s.rates[report.WorkerNum] = h
+	}
+	h.add(rateSample{start: end.Add(-report.Duration), end: end, hashes: float64(report.Hashes)}, maxWindow(s.windows))
+
+	hr := s.hashRate()
+	s.status.HashRate = hr
+	s.status.TotalHashes += report.Hashes
+	if report.Stale > 0 {
+		s.status.StaleJobs++
+		s.status.StaleTime += report.Stale
+	}
+
+	return hr
+}
+
+// AddBadSolution counts a solution of worker that failed verification,
+// and returns how many the worker has found
+func (s *Stats) AddBadSolution(worker string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.BadSolutions++
+	s.bad[worker]++
+	return s.bad[worker]
+}
+
+// DisableWorker records that worker was stopped. Its hash rate no longer
+// counts towards the miner's
+func (s *Stats) DisableWorker(worker string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped[worker] {
+		return
+	}
+	s.stopped[worker] = true
+	s.status.WorkersDisabled++
+	s.status.HashRate = s.hashRate()
+}
+
+// hashRate adds up the latest hash rates of the running workers. s.mu
+// must be held
+func (s *Stats) hashRate() int {
+	hr := 0
+	for _, rep := range s.workers {
+		if !s.stopped[rep.WorkerNum] {
+			hr += reportHashRate(rep)
+		}
+	}
+	return hr
+}
+
+// AddPoolEvent records a change of the active pool. Only the most recent
+// events are kept
+func (s *Stats) AddPoolEvent(event PoolEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Pool = event.To
+	s.status.PoolSwitches++
+	s.events = append(s.events, event)
+	if len(s.events) > maxPoolEvents {
+		s.events = s.events[len(s.events)-maxPoolEvents:]
+	}
+}
+
+// PoolEvents returns the recorded pool changes, oldest first
+func (s *Stats) PoolEvents() []PoolEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]PoolEvent{}, s.events...)
+}
+
+// Status returns a copy of the current status, with the hash rate
+// averages up to date
+func (s *Stats) Status() MinerStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	status := s.status
+
+	status.HashRateAvg = make([]RateAverage, len(s.windows))
+	for i, w := range s.windows {
+		status.HashRateAvg[i].Window = formatWindow(w)
+		for _, h := range s.rates {
+			status.HashRateAvg[i].HashRate += h.rate(now, w, h.first)
+		}
+	}
+	status.EffectiveHashRateAvg = s.accepted.averages(now, s.windows, status.Started)
+	if uptime := now.Sub(status.Started).Seconds(); uptime > 0 {
+		status.EffectiveHashRate = int(s.work / uptime)
+	}
+	if answered := status.StepsAccepted + status.StepsFailed; answered > 0 {
+		status.AcceptRatio = float64(status.StepsAccepted) / float64(answered)
+	}
+
+	return status
+}
+
+// Workers returns the latest report of every worker, ordered by worker
+func (s *Stats) Workers() []WorkerStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	workers := make([]WorkerStatus, 0, len(s.workers))
+	for _, rep := range s.workers {
+		workers = append(workers, WorkerStatus{
+			Worker:      rep.WorkerNum,
+			Hashes:      rep.Hashes,
+			Duration:    rep.Duration,
+			HashRate:    reportHashRate(rep),
+			HashRateAvg: s.rates[rep.WorkerNum].averages(now, s.windows, s.rates[rep.WorkerNum].first),
+
+			BadSolutions: s.bad[rep.WorkerNum],
+			Disabled:     s.stopped[rep.WorkerNum],
+		})
+	}
+
+	sort.Slice(workers, func(i, j int) bool {
+		a, errA := strconv.Atoi(workers[i].Worker)
+		b, errB := strconv.Atoi(workers[j].Worker)
+		if errA != nil || errB != nil {
+			return workers[i].Worker < workers[j].Worker
+		}
+		return a < b
+	})
+
+	return workers
+}
+
+func reportHashRate(rep Report) int {
+	dur := float64(rep.Duration) / float64(time.Second)
+	if dur == 0 {
+		return 0
+	}
+	return int(float64(rep.Hashes) / dur)
+}
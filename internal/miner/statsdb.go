@@ -0,0 +1,279 @@
+package miner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
+)
+
+// DefaultStatsFile is where the miner keeps its per-block statistics
+const DefaultStatsFile = "stats.jsonl"
+
+// BlockRecord is what the miner did while the pool was on one block.
+// Replies to steps sent just before a block change may be counted in the
+// next block
+type BlockRecord struct {
+	Block         int       `json:"block"`
+	Pool          string    `json:"pool"`
+	Wallet        string    `json:"wallet"`
+	Session       string    `json:"session,omitempty"`
+	Started       time.Time `json:"started"`
+	Ended         time.Time `json:"ended"`
+	StepsSent     int       `json:"pop_sent"`
+	StepsAccepted int       `json:"pop_accepted"`
+	StepsFailed   int       `json:"pop_failed"`
+	SharesEarned  int       `json:"shares_earned"`
+	Hashes        int       `json:"hashes"`
+	HashRate      int       `json:"hash_rate"`
+
+	// The share audit, see ShareRule. Records without a Session were
+	// saved before the miner audited shares
+	SharesExpected     int `json:"shares_expected"`
+	ShareDiscrepancies int `json:"share_discrepancies"`
+}
+
+// StatsDB is a file of BlockRecords, one JSON object per line. Records are
+// only ever appended, so a crash loses at most the line being written
+type StatsDB struct {
+	mu   sync.Mutex
+	path string
+}
+
+// OpenStatsDB opens the stats file at path, creating it if it doesn't
+// exist
+func OpenStatsDB(path string) (*StatsDB, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return &StatsDB{path: path}, nil
+}
+
+// Path returns the file the records are kept in
+func (db *StatsDB) Path() string {
+	return db.path
+}
+
+// Add appends rec to the file
+func (db *StatsDB) Add(rec BlockRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	f, err := os.OpenFile(db.path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	// Don't add to a line a crash cut short
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = append([]byte{'\n'}, line...)
+		}
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Records returns the records that ended in [from, to), ordered by when
+// they started. A zero from or to leaves that end open. Lines that can't
+// be parsed, like one cut short by a crash, are skipped with a warning
+func (db *StatsDB) Records(from, to time.Time) ([]BlockRecord, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	f, err := os.Open(db.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		records []BlockRecord
+		n       int
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec BlockRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			logging.Warnf("Skipping line %d of %s: %v\n", n, db.path, err)
+			continue
+		}
+		if !from.IsZero() && rec.Ended.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !rec.Ended.Before(to) {
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Started.Before(records[j].Started) })
+	return records, nil
+}
+
+// StatsTotals adds up a set of BlockRecords
+type StatsTotals struct {
+	Key           string        `json:"key,omitempty"`
+	Blocks        int           `json:"blocks"`
+	First         time.Time     `json:"first"`
+	Last          time.Time     `json:"last"`
+	Mined         time.Duration `json:"mined_ns"`
+	StepsSent     int           `json:"pop_sent"`
+	StepsAccepted int           `json:"pop_accepted"`
+	StepsFailed   int           `json:"pop_failed"`
+	SharesEarned  int           `json:"shares_earned"`
+	Hashes        int           `json:"hashes"`
+	HashRate      int           `json:"hash_rate"`
+
+	SharesExpected     int `json:"shares_expected"`
+	ShareDiscrepancies int `json:"share_discrepancies"`
+}
+
+func (t *StatsTotals) add(rec BlockRecord) {
+	if t.Blocks == 0 || rec.Started.Before(t.First) {
+		t.First = rec.Started
+	}
+	if rec.Ended.After(t.Last) {
+		t.Last = rec.Ended
+	}
+	t.Blocks++
+	t.Mined += rec.Ended.Sub(rec.Started)
+	t.StepsSent += rec.StepsSent
+	t.StepsAccepted += rec.StepsAccepted
+	t.StepsFailed += rec.StepsFailed
+	t.SharesEarned += rec.SharesEarned
+	t.SharesExpected += rec.SharesExpected
+	t.ShareDiscrepancies += rec.ShareDiscrepancies
+	t.Hashes += rec.Hashes
+	if t.Mined > 0 {
+		t.HashRate = int(float64(t.Hashes) / t.Mined.Seconds())
+	}
+}
+
+// Stats groupings for SumBlockRecords
+const (
+	GroupTotal   = "total"
+	GroupBlock   = "block"
+	GroupDay     = "day"
+	GroupMonth   = "month"
+	GroupPool    = "pool"
+	GroupWallet  = "wallet"
+	GroupSession = "session"
+)
+
+// StatsGroups are the groupings SumBlockRecords supports
+var StatsGroups = []string{GroupTotal, GroupBlock, GroupDay, GroupMonth, GroupPool, GroupWallet, GroupSession}
+
+// SumBlockRecords adds up records by group, in the order each group was
+// first seen. Days and months are in local time
+func SumBlockRecords(records []BlockRecord, group string) ([]StatsTotals, error) {
+	var key func(BlockRecord) string
+	switch group {
+	case GroupTotal, "":
+		key = func(BlockRecord) string { return "" }
+	case GroupBlock:
+		key = func(r BlockRecord) string { return fmt.Sprint(r.Block) }
+	case GroupDay:
+		key = func(r BlockRecord) string { return r.Started.Local().Format("2006-01-02") }
+	case GroupMonth:
+		key = func(r BlockRecord) string { return r.Started.Local().Format("2006-01") }
+	case GroupPool:
+		key = func(r BlockRecord) string { return r.Pool }
+	case GroupWallet:
+		key = func(r BlockRecord) string { return r.Wallet }
+	case GroupSession:
+		key = func(r BlockRecord) string { return r.Session }
+	default:
+		return nil, fmt.Errorf("unknown grouping %q", group)
+	}
+
+	totals := []StatsTotals{}
+	index := map[string]int{}
+	for _, rec := range records {
+		k := key(rec)
+		i, ok := index[k]
+		if !ok {
+			i = len(totals)
+			index[k] = i
+			totals = append(totals, StatsTotals{Key: k})
+		}
+		totals[i].add(rec)
+	}
+	return totals, nil
+}
+
+// blockRecorder turns the running totals in Stats into a BlockRecord for
+// every block mined on
+type blockRecorder struct {
+	db    *StatsDB
+	stats *Stats
+
+	rec   BlockRecord
+	start MinerStatus
+}
+
+func newBlockRecorder(db *StatsDB, stats *Stats) *blockRecorder {
+	return &blockRecorder{db: db, stats: stats}
+}
+
+// next finishes the current record and starts one for block
+func (r *blockRecorder) next(block int, pool, wallet string) {
+	r.finish()
+	r.start = r.stats.Status()
+	r.rec = BlockRecord{Block: block, Pool: pool, Wallet: wallet, Session: instanceId, Started: time.Now()}
+}
+
+// finish writes the current record, if there is one
+func (r *blockRecorder) finish() {
+	if r.rec.Block == 0 {
+		return
+	}
+	status := r.stats.Status()
+
+	rec := r.rec
+	rec.Ended = time.Now()
+	rec.StepsSent = status.StepsSent - r.start.StepsSent
+	rec.StepsAccepted = status.StepsAccepted - r.start.StepsAccepted
+	rec.StepsFailed = status.StepsFailed - r.start.StepsFailed
+	rec.SharesEarned = status.SharesEarned - r.start.SharesEarned
+	rec.SharesExpected = status.SharesExpected - r.start.SharesExpected
+	rec.ShareDiscrepancies = status.ShareDiscrepancies - r.start.ShareDiscrepancies
+	rec.Hashes = status.TotalHashes - r.start.TotalHashes
+	if secs := rec.Ended.Sub(rec.Started).Seconds(); secs > 0 {
+		rec.HashRate = int(float64(rec.Hashes) / secs)
+	}
+	r.rec = BlockRecord{}
+
+	if r.db == nil {
+		return
+	}
+	if err := r.db.Add(rec); err != nil {
+		logging.Warnf("Could not save the stats of block %d to %s: %v\n", rec.Block, r.db.Path(), err)
+	}
+}
@@ -0,0 +1,162 @@
+package miner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempStatsDB(t *testing.T) *StatsDB {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "noso-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := OpenStatsDB(filepath.Join(dir, DefaultStatsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestStatsDB(t *testing.T) {
+	db := tempStatsDB(t)
+	start := time.Date(2021, 5, 1, 12, 0, 0, 0, time.Local)
+
+	for i := 0; i < 4; i++ {
+		rec := BlockRecord{
+			Block:         100 + i,
+			Pool:          "pool-a",
+			Wallet:        "wallet",
+			Started:       start.Add(time.Duration(i) * 10 * time.Minute),
+			Ended:         start.Add(time.Duration(i+1) * 10 * time.Minute),
+			StepsSent:     10,
+			StepsAccepted: 9,
+			StepsFailed:   1,
+			SharesEarned:  9,
+			Hashes:        600000,
+		}
+		if i == 3 {
+			rec.Pool = "pool-b"
+			rec.Started = rec.Started.Add(24 * time.Hour)
+			rec.Ended = rec.Ended.Add(24 * time.Hour)
+		}
+		if err := db.Add(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A line cut short by a crash
+	f, err := os.OpenFile(db.Path(), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"block":104,"pool":"po`)
+	f.Close()
+	if err := db.Add(BlockRecord{Block: 105, Started: start.Add(48 * time.Hour), Ended: start.Add(48 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := db.Records(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 5 || all[4].Block != 105 {
+		t.Fatalf("got %d records want 5", len(all))
+	}
+	all = all[:4]
+
+	ranged, err := db.Records(start.Add(15*time.Minute), start.Add(30*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranged) != 1 || ranged[0].Block != 101 {
+		t.Errorf("got %+v want only block 101", ranged)
+	}
+
+	totals, err := SumBlockRecords(all, GroupTotal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 {
+		t.Fatalf("got %d totals want 1", len(totals))
+	}
+	got := totals[0]
+	if got.Blocks != 4 || got.StepsSent != 40 || got.StepsAccepted != 36 || got.StepsFailed != 4 ||
+		got.SharesEarned != 36 || got.Hashes != 2400000 || got.Mined != 40*time.Minute || got.HashRate != 1000 {
+		t.Errorf("unexpected totals: %+v", got)
+	}
+	if !got.First.Equal(start) || !got.Last.Equal(start.Add(24*time.Hour+40*time.Minute)) {
+		t.Errorf("got range %s - %s", got.First, got.Last)
+	}
+
+	for group, want := range map[string][]string{
+		GroupPool:  {"pool-a", "pool-b"},
+		GroupDay:   {"2021-05-01", "2021-05-02"},
+		GroupMonth: {"2021-05"},
+		GroupBlock: {"100", "101", "102", "103"},
+	} {
+		totals, err := SumBlockRecords(all, group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := []string{}
+		for _, t := range totals {
+			keys = append(keys, t.Key)
+		}
+		if len(keys) != len(want) {
+			t.Errorf("%s: got %v want %v", group, keys, want)
+			continue
+		}
+		for i := range keys {
+			if keys[i] != want[i] {
+				t.Errorf("%s: got %v want %v", group, keys, want)
+				break
+			}
+		}
+	}
+
+	if _, err := SumBlockRecords(all, "fortnight"); err == nil {
+		t.Error("no error for an unknown grouping")
+	}
+}
+
+func TestBlockRecorder(t *testing.T) {
+	db := tempStatsDB(t)
+	stats := NewStats()
+	blocks := newBlockRecorder(db, stats)
+
+	// Nothing is saved before the first block
+	blocks.finish()
+
+	blocks.next(100, "pool", "wallet")
+	stats.Update(func(s *MinerStatus) { s.StepsSent += 3 })
+	stats.AcceptStep(2)
+	stats.AcceptStep(2)
+	stats.FailStep()
+	stats.AddReport(Report{WorkerNum: "1", Hashes: 5000, Duration: time.Second})
+
+	blocks.next(101, "pool", "wallet")
+	stats.Update(func(s *MinerStatus) { s.StepsSent++ })
+	stats.AcceptStep(5)
+	blocks.finish()
+
+	records, err := db.Records(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records want 2", len(records))
+	}
+	if r := records[0]; r.Block != 100 || r.StepsSent != 3 || r.StepsAccepted != 2 || r.StepsFailed != 1 || r.SharesEarned != 4 || r.Hashes != 5000 {
+		t.Errorf("unexpected record for block 100: %+v", r)
+	}
+	if r := records[1]; r.Block != 101 || r.StepsSent != 1 || r.StepsAccepted != 1 || r.StepsFailed != 0 || r.SharesEarned != 5 || r.Hashes != 0 {
+		t.Errorf("unexpected record for block 101: %+v", r)
+	}
+}
@@ -22,37 +22,36 @@ THE SOFTWARE.
 package miner
 
 import (
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
-)
 
-func GetPoolStatus(opts *Opts) {
-	var (
-		resp string
-	)
+	"github.com/Noso-Project/noso-go/internal/logging"
+)
 
-	log.Printf("Connecting to %s:%d with password %s\n", opts.IpAddr, opts.IpPort, opts.PoolPw)
-	log.Printf("Using wallet address: %s\n", opts.CurrentWallet)
+// GetPoolStatus asks the pool for its status
+func GetPoolStatus(opts *Opts, timeout time.Duration) (PoolStatus, error) {
+	logging.Infof("Connecting to %s:%d\n", opts.IpAddr, opts.IpPort)
 	comms := NewComms()
-	client := NewTcpClient(opts, comms, false, false)
+	client := NewTcpClient(opts, comms, NewStats(), false, false)
+	defer client.Close(time.Second)
 
-	client.SendChan <- "STATUS"
+	stop := make(chan struct{})
+	defer close(stop)
+	go parseLines(comms, client, stop)
 
-loop:
+	client.SendChan <- StatusCmd{}.String()
+
+	deadline := time.After(timeout)
 	for {
 		select {
-		case resp = <-client.RecvChan:
-			go Parse(comms, opts.IpAddr, opts.CurrentWallet, 0, resp)
 		case status := <-comms.PoolStatus:
-			status.PrettyPrint()
-			break loop
-		case <-time.After(5 * time.Second):
-			log.Println("Failed to get a response back from the pool")
-			os.Exit(1)
+			return status, nil
+		case <-deadline:
+			return PoolStatus{}, errors.New("failed to get a response back from the pool")
 		}
 	}
 }
@@ -68,52 +67,14 @@ type PoolStatus struct {
 	Miners      []MinerInfo
 }
 
-func (p *PoolStatus) PrettyPrint() {
-	status := `
-Hash Rate: %s
-Fee      : %s
-Share    : %s
-Miners   : %s
-
-`
-	log.Printf(status, strings.TrimSpace(p.HashRate), p.Fee, p.Share, p.MinerCnt)
-}
-
-// s[] -> STATUS {hashrate} {fee} {share} {minerCount} [list of miners: {address}:{balance}:{blocks_until_paymet}]
-func NewPoolStatus(s []string) PoolStatus {
-	var (
-		fee   string
-		share string
-	)
-
-	hrRaw := s[0]
-	hr := formatHashRate(hrRaw + "000")
-	feeRaw, err := strconv.Atoi(s[1])
-	if err != nil {
-		log.Println("Error converting Fee to int")
-	} else {
-		fee = fmt.Sprintf("%.2f%%", float64(feeRaw)/100)
-	}
-	shareRaw, err := strconv.Atoi(s[2])
-	if err != nil {
-		log.Println("Error converting Share to int")
-	} else {
-		share = fmt.Sprintf("%.2f%%", float64(shareRaw)/100)
-	}
-	minerCnt := s[3]
-
-	miners := formatMiners(s[4:])
-
-	return PoolStatus{
-		HashRateRaw: hrRaw,
-		HashRate:    hr,
-		FeeRaw:      feeRaw,
-		Fee:         fee,
-		ShareRaw:    shareRaw,
-		Share:       share,
-		MinerCnt:    minerCnt,
-		Miners:      miners,
+// Find returns the pool's entry for wallet
+func (p *PoolStatus) Find(wallet string) (MinerInfo, bool) {
+	for _, m := range p.Miners {
+		if m.Address == wallet {
+			return m, true
+		}
 	}
+	return MinerInfo{}, false
 }
 
 type MinerInfo struct {
@@ -123,28 +84,96 @@ type MinerInfo struct {
 	BlocksTillPayment string
 }
 
-func newMinerInfo(m string) MinerInfo {
+// parseMinerInfo parses a "{address}:{balance}:{blocksTillPayment}" entry
+// of a STATUS response
+func parseMinerInfo(m string) (MinerInfo, error) {
 	split := strings.Split(m, ":")
-	address := split[0]
-	balance := split[1]
-	btp := split[2]
+	if len(split) != 3 || split[0] == "" {
+		return MinerInfo{}, fmt.Errorf("has a bad miner entry %q", m)
+	}
+	address, balance, btp := split[0], split[1], split[2]
+	if _, err := strconv.ParseUint(balance, 10, 64); err != nil {
+		return MinerInfo{}, fmt.Errorf("has a bad balance in miner entry %q", m)
+	}
+	if _, err := strconv.Atoi(btp); err != nil {
+		return MinerInfo{}, fmt.Errorf("has bad blocks till payment in miner entry %q", m)
+	}
 	return MinerInfo{
 		Address:           address,
 		Balance:           balance,
 		BalanceHR:         formatBalance(balance),
 		BlocksTillPayment: btp,
+	}, nil
+}
+
+// Amount returns the balance of the miner
+func (m MinerInfo) Amount() NosoAmount {
+	amount, _ := ParseNosoAmount(m.Balance)
+	return amount
+}
+
+// Blocks returns the blocks till payment of the miner
+func (m MinerInfo) Blocks() int {
+	btp, _ := strconv.Atoi(m.BlocksTillPayment)
+	return btp
+}
+
+// Orders SortMiners supports
+const (
+	SortByBalance = "balance"
+	SortByAddress = "address"
+	SortByBlocks  = "blocks"
+)
+
+// MinerSorts are the orders SortMiners supports
+var MinerSorts = []string{SortByBalance, SortByAddress, SortByBlocks}
+
+// SortMiners sorts miners by the largest balance, by address, or by the
+// fewest blocks till payment
+func SortMiners(miners []MinerInfo, by string) error {
+	var less func(a, b MinerInfo) bool
+	switch by {
+	case SortByBalance, "":
+		less = func(a, b MinerInfo) bool { return a.Amount() > b.Amount() }
+	case SortByAddress:
+		less = func(a, b MinerInfo) bool { return a.Address < b.Address }
+	case SortByBlocks:
+		less = func(a, b MinerInfo) bool { return a.Blocks() < b.Blocks() }
+	default:
+		return fmt.Errorf("unknown order %q, use %s", by, strings.Join(MinerSorts, ", "))
 	}
+	sort.SliceStable(miners, func(i, j int) bool { return less(miners[i], miners[j]) })
+	return nil
 }
 
-func formatMiners(m []string) []MinerInfo {
-	miners := make([]MinerInfo, 0)
+// MinerFilter picks miners from a pool's miner list. The zero value
+// picks every miner
+type MinerFilter struct {
+	// Match is part of the address, in any case
+	Match string
+
+	// MinBalance is the smallest balance picked
+	MinBalance NosoAmount
 
-	for _, miner := range m {
-		if len(miner) == 0 {
+	// Vested picks only the miners whose balance can be paid out
+	Vested bool
+}
+
+// Filter returns the miners f picks, in the same order
+func (f MinerFilter) Filter(miners []MinerInfo) []MinerInfo {
+	match := strings.ToLower(f.Match)
+	picked := []MinerInfo{}
+	for _, m := range miners {
+		if match != "" && !strings.Contains(strings.ToLower(m.Address), match) {
+			continue
+		}
+		if m.Amount() < f.MinBalance {
 			continue
 		}
-		miners = append(miners, newMinerInfo(miner))
+		if f.Vested && m.Blocks() <= 0 {
+			continue
+		}
+		picked = append(picked, m)
 	}
-
-	return miners
+	return picked
 }
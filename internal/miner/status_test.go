@@ -0,0 +1,73 @@
+package miner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testMiners() []MinerInfo {
+	return []MinerInfo{
+		{Address: "NbSoma", Balance: "250000000", BlocksTillPayment: "3"},
+		{Address: "NaOther", Balance: "50000", BlocksTillPayment: "-10"},
+		{Address: "NcThird", Balance: "1200000000", BlocksTillPayment: "0"},
+		{Address: "NdSomebody", Balance: "250000000", BlocksTillPayment: "1"},
+	}
+}
+
+func addresses(miners []MinerInfo) []string {
+	a := []string{}
+	for _, m := range miners {
+		a = append(a, m.Address)
+	}
+	return a
+}
+
+func TestSortMiners(t *testing.T) {
+	tests := map[string][]string{
+		SortByBalance: {"NcThird", "NbSoma", "NdSomebody", "NaOther"},
+		SortByAddress: {"NaOther", "NbSoma", "NcThird", "NdSomebody"},
+		SortByBlocks:  {"NaOther", "NcThird", "NdSomebody", "NbSoma"},
+	}
+	for by, want := range tests {
+		miners := testMiners()
+		if err := SortMiners(miners, by); err != nil {
+			t.Fatal(err)
+		}
+		if got := addresses(miners); !reflect.DeepEqual(got, want) {
+			t.Errorf("by %s got %v want %v", by, got, want)
+		}
+	}
+
+	if err := SortMiners(testMiners(), "hashrate"); err == nil {
+		t.Error("no error for an unknown order")
+	}
+}
+
+func TestMinerFilter(t *testing.T) {
+	tests := []struct {
+		filter MinerFilter
+		want   []string
+	}{
+		{MinerFilter{}, []string{"NbSoma", "NaOther", "NcThird", "NdSomebody"}},
+		{MinerFilter{Match: "som"}, []string{"NbSoma", "NdSomebody"}},
+		{MinerFilter{MinBalance: 250000000}, []string{"NbSoma", "NcThird", "NdSomebody"}},
+		{MinerFilter{Vested: true}, []string{"NbSoma", "NdSomebody"}},
+		{MinerFilter{Match: "n", MinBalance: 300000000, Vested: true}, []string{}},
+	}
+	for _, tt := range tests {
+		if got := addresses(tt.filter.Filter(testMiners())); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v got %v want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestPoolStatusFind(t *testing.T) {
+	status := PoolStatus{Miners: testMiners()}
+	m, ok := status.Find("NcThird")
+	if !ok || m.Amount() != 1200000000 || m.Blocks() != 0 {
+		t.Errorf("got %+v, %v", m, ok)
+	}
+	if _, ok := status.Find("ncthird"); ok {
+		t.Error("found a wallet by another case")
+	}
+}
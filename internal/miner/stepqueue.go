@@ -0,0 +1,174 @@
+package miner
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
+)
+
+// stepQueue keeps the steps sent to the pool until the pool answers them.
+// The pool answers the steps of a session in the order they were sent,
+// without saying which step an answer is for, so every STEPOK or STEPFAIL
+// answers the oldest one. Steps still unanswered when the session ends
+// are sent again once the miner has rejoined, unless their block is over
+type stepQueue struct {
+	// sendMu keeps the steps in order on their way to the pool. It is
+	// held while waiting on sendChan, so mu never is
+	sendMu   sync.Mutex
+	mu       sync.Mutex
+	sendChan chan string
+	stats    *Stats
+
+	session int
+	sent    []Solution // sent in session, oldest first
+	held    []Solution // unanswered when the last session ended
+}
+
+func newStepQueue(sendChan chan string, stats *Stats) *stepQueue {
+	return &stepQueue{sendChan: sendChan, stats: stats}
+}
+
+// send sends the step for sol, which was checked against state
+func (q *stepQueue) send(sol Solution, state *PoolState) {
+	q.sendMu.Lock()
+	defer q.sendMu.Unlock()
+
+	q.mu.Lock()
+	steps := append(q.sync(state), sol)
+	q.sent = append(q.sent, sol)
+	q.updateStats()
+	q.mu.Unlock()
+
+	q.push(steps)
+}
+
+// answered forgets the oldest step sent, once the pool has answered it,
+// and returns it. ok is false if no step was waiting for an answer
+func (q *stepQueue) answered() (sol Solution, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.sent) > 0 {
+		sol, ok = q.sent[0], true
+		q.sent = q.sent[1:]
+	}
+	q.updateStats()
+	return sol, ok
+}
+
+// pending returns how many steps haven't been answered yet
+func (q *stepQueue) pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.sent) + len(q.held)
+}
+
+// update follows the pool state. When the connection is lost, the steps
+// sent are held, since their answers are lost with it. Once the miner has
+// rejoined, the held steps are sent again if they are still for the
+// pool's current block, and discarded otherwise. update never waits on
+// the pool connection, the steps are resent in the background
+func (q *stepQueue) update(state *PoolState) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !state.Ready() {
+		q.hold()
+	} else if state.Session > q.session {
+		go q.resend(state)
+	}
+	q.updateStats()
+}
+
+// resend sends the held steps that are still current for state
+func (q *stepQueue) resend(state *PoolState) {
+	q.sendMu.Lock()
+	defer q.sendMu.Unlock()
+
+	q.mu.Lock()
+	steps := q.sync(state)
+	q.updateStats()
+	q.mu.Unlock()
+
+	q.push(steps)
+}
+
+// sync moves on to the session of state, if it's a newer one, and
+// returns the held steps to send again, which it counts as sent. q.mu must
+// be held
+func (q *stepQueue) sync(state *PoolState) []Solution {
+	if state.Session <= q.session {
+		return nil
+	}
+	q.hold()
+	q.session = state.Session
+
+	held := q.held
+	q.held = nil
+	var resend []Solution
+	discarded := map[string]int{}
+	reasons := []string{}
+	for _, sol := range held {
+		reason := ""
+		switch {
+		case sol.PoolAddr != state.PoolAddr:
+			reason = "the miner moved to another pool"
+		case sol.Block != state.Block:
+			reason = fmt.Sprintf("the pool moved on from block %d to %d", sol.Block, state.Block)
+		case !strings.HasPrefix(sol.Seed, state.MinerSeed[:len(state.MinerSeed)-3]):
+			reason = "the pool gave the miner a new seed"
+		default:
+			resend = append(resend, sol)
+			continue
+		}
+		if discarded[reason] == 0 {
+			reasons = append(reasons, reason)
+		}
+		discarded[reason]++
+	}
+	q.sent = append(q.sent, resend...)
+
+	for _, reason := range reasons {
+		logging.Warnf("Discarded %d unanswered step(s): %s\n", discarded[reason], reason)
+	}
+	if lost := len(held) - len(resend); lost > 0 {
+		q.stats.Update(func(s *MinerStatus) { s.StepsDiscarded += lost })
+	}
+	if len(resend) > 0 {
+		logging.Infof("Resending %d step(s) left unanswered by the lost connection\n", len(resend))
+		q.stats.Update(func(s *MinerStatus) { s.StepsResent += len(resend) })
+	}
+	return resend
+}
+
+// hold keeps the steps sent until the next session. q.mu must be held
+func (q *stepQueue) hold() {
+	if len(q.sent) == 0 {
+		return
+	}
+	logging.Warnf("Connection lost with %d step(s) unanswered, they are resent if their block is still current after reconnecting\n", len(q.sent))
+	q.held = append(q.held, q.sent...)
+	q.sent = nil
+}
+
+// push hands the steps to the pool connection. q.sendMu must be held
+func (q *stepQueue) push(steps []Solution) {
+	for _, sol := range steps {
+		q.sendChan <- StepCmd{
+			Block:      sol.Block,
+			Seed:       sol.Seed,
+			HashStr:    sol.HashStr,
+			TargetLen:  sol.TargetLen,
+			InstanceId: instanceId,
+		}.String()
+	}
+}
+
+// updateStats publishes the number of pending steps. q.mu must be held
+func (q *stepQueue) updateStats() {
+	pending := len(q.sent) + len(q.held)
+	q.stats.Update(func(s *MinerStatus) { s.StepsPending = pending })
+}
@@ -0,0 +1,144 @@
+package miner
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStepQueue(t *testing.T) {
+	sendChan := make(chan string, 20)
+	stats := NewStats()
+	queue := newStepQueue(sendChan, stats)
+
+	state := &PoolState{
+		PoolAddr: "pool:8082", MinerSeed: "1abc!!!", Block: 100, Step: 0,
+		Diff: 9, TargetChars: 2, TargetString: "ABCDEF0123", PoolDepth: 3, Session: 1,
+	}
+	sol := func(block int, hashStr string) Solution {
+		return Solution{PoolAddr: "pool:8082", Seed: "1abc!!!000", HashStr: hashStr, Block: block, TargetLen: 9}
+	}
+	sentSteps := func() []string {
+		var steps []string
+		for len(sendChan) > 0 {
+			steps = append(steps, <-sendChan)
+		}
+		return steps
+	}
+	// Steps are resent in the background
+	waitFor := func(cond func() bool) {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if cond() {
+				return
+			}
+		}
+		t.Fatal("steps not resent or discarded")
+	}
+
+	queue.update(state)
+	queue.send(sol(100, "a"), state)
+	queue.send(sol(100, "b"), state)
+	queue.send(sol(100, "c"), state)
+	if sol, ok := queue.answered(); !ok || sol.HashStr != "a" {
+		t.Errorf("answered %+v, %v", sol, ok)
+	}
+	if n := queue.pending(); n != 2 {
+		t.Errorf("got %d pending want 2", n)
+	}
+	if steps := sentSteps(); len(steps) != 3 {
+		t.Fatalf("got steps %q", steps)
+	}
+
+	// The connection is lost and the miner rejoins during the same block
+	queue.update(&PoolState{})
+	rejoined := *state
+	rejoined.Session = 2
+	queue.update(&rejoined)
+	waitFor(func() bool { return len(sendChan) == 2 })
+	steps := sentSteps()
+	if len(steps) != 2 || !strings.Contains(steps[0], " b ") || !strings.Contains(steps[1], " c ") {
+		t.Errorf("got resent steps %q", steps)
+	}
+	if status := stats.Status(); status.StepsResent != 2 || status.StepsPending != 2 {
+		t.Errorf("got resent %d, pending %d", status.StepsResent, status.StepsPending)
+	}
+
+	// This time the pool is on a new block when the miner rejoins
+	queue.answered()
+	queue.update(&PoolState{})
+	next := rejoined
+	next.Session, next.Block = 3, 101
+	queue.update(&next)
+	waitFor(func() bool { return stats.Status().StepsDiscarded == 1 })
+	if steps := sentSteps(); len(steps) != 0 {
+		t.Errorf("got resent steps %q", steps)
+	}
+	status := stats.Status()
+	if status.StepsDiscarded != 1 || status.StepsPending != 0 || queue.pending() != 0 {
+		t.Errorf("got discarded %d, pending %d", status.StepsDiscarded, status.StepsPending)
+	}
+}
+
+func TestDropSteps(t *testing.T) {
+	client := &TcpClient{SendChan: make(chan string, 10)}
+	client.SendChan <- "STEP 100 1abc!!!000 a 9"
+	client.SendChan <- "STATUS"
+	client.SendChan <- "STEP 100 1abc!!!000 b 9"
+
+	client.dropSteps()
+
+	if n := len(client.SendChan); n != 1 {
+		t.Fatalf("got %d messages want 1", n)
+	}
+	if msg := <-client.SendChan; msg != "STATUS" {
+		t.Errorf("got %q want STATUS", msg)
+	}
+}
+
+// parsingClient returns a client that isn't connected anywhere, with its
+// received lines parsed into comms until the test ends
+func parsingClient(t *testing.T, comms *Comms) *TcpClient {
+	t.Helper()
+
+	client := &TcpClient{
+		pools:    []PoolEndpoint{{Address: "pool"}},
+		RecvChan: make(chan string, 100),
+		mutex:    &sync.Mutex{},
+	}
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go parseLines(comms, client, stop)
+	return client
+}
+
+func TestStepAnswersInOrder(t *testing.T) {
+	comms := NewComms()
+	client := parsingClient(t, comms)
+
+	answers := []string{}
+	for i := 1; i <= 50; i++ {
+		answer := fmt.Sprintf("STEPOK %d", i)
+		if i%3 == 0 {
+			answer = STEPFAIL
+		}
+		answers = append(answers, answer)
+		client.RecvChan <- answer
+	}
+
+	for i, want := range answers {
+		got := ""
+		select {
+		case shares := <-comms.StepSolved:
+			got = fmt.Sprintf("STEPOK %d", shares)
+		case <-comms.StepFailed:
+			got = STEPFAIL
+		case <-time.After(5 * time.Second):
+			t.Fatalf("answer %d not parsed", i)
+		}
+		if got != want {
+			t.Fatalf("answer %d is %s want %s", i, got, want)
+		}
+	}
+}
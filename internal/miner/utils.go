@@ -3,6 +3,8 @@ package miner
 import (
 	"fmt"
 	"math"
+	"net"
+	"strconv"
 	"strings"
 )
 
@@ -35,6 +37,12 @@ func formatHashRate(hr string) string {
 	return fmt.Sprintf("%3s.%s %sash/s", whole, frac, mag)
 }
 
+// FormatHashRate formats a hash rate in hashes per second, like
+// "7.637 Mhash/s"
+func FormatHashRate(hr int) string {
+	return strings.TrimSpace(formatHashRate(strconv.Itoa(hr)))
+}
+
 func formatBalance(balance string) string {
 	return fmt.Sprintf("%s Noso", parseAmount(balance))
 }
@@ -52,3 +60,23 @@ func parseAmount(amount string) string {
 	l := len(amount)
 	return fmt.Sprintf("%s.%s", amount[:l-8], amount[l-8:])
 }
+
+// splitHostPort splits "host:port" into its parts. If addr has no port,
+// defaultPort is used
+func splitHostPort(addr string, defaultPort int) (string, int, error) {
+	if !strings.Contains(addr, ":") {
+		return addr, defaultPort, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+
+	return host, port, nil
+}
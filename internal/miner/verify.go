@@ -0,0 +1,97 @@
+package miner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Noso-Project/noso-go/internal/logging"
+)
+
+// DefaultMaxSolutionErrors is how many bad solutions a worker may find
+// before it is stopped
+const DefaultMaxSolutionErrors = 3
+
+// verifySolution recomputes the hash of sol and the length of the target
+// it matches, so a solution miscalculated by an overclocked or faulty CPU
+// is never sent to the pool
+func verifySolution(sol Solution) error {
+	sum := sha256.Sum256([]byte(sol.Seed + sol.PoolAddr + sol.HashStr))
+	hash := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(hash, sol.SolvedHash) {
+		return fmt.Errorf("hashed string %s hashes to %s, not %s", sol.HashStr, hash, sol.SolvedHash)
+	}
+
+	target := strings.ToLower(sol.MatchTarget)
+	if sol.TargetLen < 1 || sol.TargetLen > len(target) || !strings.EqualFold(sol.Target, target[:sol.TargetLen]) {
+		return fmt.Errorf("target %s of length %d is not part of %s", sol.Target, sol.TargetLen, sol.MatchTarget)
+	}
+
+	// The miner may report less than the hash matches, since it only
+	// looks as far as the pool depth, but never more
+	matched := 0
+	for matched < len(target) && strings.Contains(hash, target[:matched+1]) {
+		matched++
+	}
+	if matched < sol.TargetLen {
+		return fmt.Errorf("hash %s matches %d chars of target %s, not %d", hash, matched, sol.MatchTarget, sol.TargetLen)
+	}
+	return nil
+}
+
+// solutionChecker verifies the solutions found by the workers before they
+// are sent, and stops a worker once it has found too many bad ones
+type solutionChecker struct {
+	mu        sync.Mutex
+	stats     *Stats
+	maxErrors int
+	stops     map[string]context.CancelFunc
+}
+
+// newSolutionChecker returns a checker that stops a worker after
+// maxErrors bad solutions. Zero only warns about them
+func newSolutionChecker(stats *Stats, maxErrors int) *solutionChecker {
+	return &solutionChecker{stats: stats, maxErrors: maxErrors, stops: map[string]context.CancelFunc{}}
+}
+
+// addWorker registers the function that stops worker
+func (c *solutionChecker) addWorker(worker string, stop context.CancelFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stops[worker] = stop
+}
+
+// check reports whether sol is right and may be sent to the pool
+func (c *solutionChecker) check(sol Solution) bool {
+	err := verifySolution(sol)
+	if err == nil {
+		return true
+	}
+
+	errors := c.stats.AddBadSolution(sol.Worker)
+	logging.Errorf("Worker %s found a bad solution for block %d, not sending it (%d so far): %v\n", sol.Worker, sol.Block, errors, err)
+	if c.maxErrors <= 0 || errors < c.maxErrors {
+		return false
+	}
+
+	c.mu.Lock()
+	stop, ok := c.stops[sol.Worker]
+	delete(c.stops, sol.Worker)
+	left := len(c.stops)
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	stop()
+	c.stats.DisableWorker(sol.Worker)
+	logging.Errorf("Stopped worker %s after %d bad solutions, check the CPU isn't overclocked or overheating\n", sol.Worker, errors)
+	if left == 0 {
+		logging.Errorf("Every worker has been stopped, nothing is being mined\n")
+	}
+	return false
+}
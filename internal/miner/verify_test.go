@@ -0,0 +1,99 @@
+package miner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Noso-Project/noso-go/internal/hasher"
+)
+
+// testSolutions returns solutions found by hashJob for an easy job
+func testSolutions(t *testing.T) []Solution {
+	job := newBenchmarkJob(0, 1)
+	job.Diff = 30
+	job.PoolDepth = 1
+
+	var sols []Solution
+	hashJob(context.Background(), job, newHasher(t, hasher.Auto), func(sol Solution) {
+		sols = append(sols, sol)
+	})
+	if len(sols) < 2 {
+		t.Fatalf("got %d solutions", len(sols))
+	}
+	return sols
+}
+
+func TestVerifySolution(t *testing.T) {
+	sols := testSolutions(t)
+	for _, sol := range sols {
+		if err := verifySolution(sol); err != nil {
+			t.Fatalf("%+v: %v", sol, err)
+		}
+	}
+
+	sol := sols[0]
+	bad := map[string]Solution{}
+	s := sol
+	s.HashStr = sols[1].HashStr
+	bad["wrong hashed string"] = s
+	s = sol
+	s.SolvedHash = sols[1].SolvedHash
+	bad["wrong hash"] = s
+	s = sol
+	s.PoolAddr += "x"
+	bad["wrong pool"] = s
+	for _, short := range sols {
+		// More than the hash matches
+		if short.TargetLen < len(short.MatchTarget) {
+			short.TargetLen++
+			short.Target = short.MatchTarget[:short.TargetLen]
+			bad["target too long"] = short
+			break
+		}
+	}
+	s = sol
+	s.TargetLen = len(sol.MatchTarget) + 1
+	s.Target = sol.MatchTarget + "0"
+	bad["target longer than matched"] = s
+	s = sol
+	s.Target = "zz"
+	bad["target not in full target"] = s
+
+	for name, sol := range bad {
+		if err := verifySolution(sol); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestSolutionChecker(t *testing.T) {
+	sol := testSolutions(t)[0]
+	sol.Worker = "2"
+	bad := sol
+	bad.HashStr += "x"
+
+	stats := NewStats()
+	checker := newSolutionChecker(stats, 2)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	checker.addWorker("2", stop)
+
+	if !checker.check(sol) {
+		t.Error("good solution rejected")
+	}
+	if checker.check(bad) {
+		t.Error("bad solution accepted")
+	}
+	if ctx.Err() != nil {
+		t.Fatal("worker stopped after one bad solution")
+	}
+	checker.check(bad)
+	if ctx.Err() == nil {
+		t.Fatal("worker not stopped after two bad solutions")
+	}
+
+	status := stats.Status()
+	if status.BadSolutions != 2 || status.WorkersDisabled != 1 {
+		t.Errorf("got %d bad solutions, %d workers disabled", status.BadSolutions, status.WorkersDisabled)
+	}
+}
@@ -0,0 +1,525 @@
+// Package mockpool implements the pool side of the Noso pool protocol so
+// noso-go can be developed and tested without a live pool.
+//
+// Miners send "{password} {wallet} {command} {args...}" lines. The server
+// understands JOIN, PING, STEP, PAYMENT and STATUS, announces new blocks
+// with POOLSTEPS, and can inject PASSFAILED, STEPFAIL, malformed lines and
+// dropped connections for fault testing.
+package mockpool
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes the pool the server pretends to be
+type Config struct {
+	PoolAddr          string
+	MinerSeed         string
+	Password          string // empty accepts any password
+	Block             int    // first block
+	TargetChars       int
+	Diff              int
+	PoolDepth         int
+	Balance           int64 // in the pool's integer units (1 Noso = 100000000)
+	BlocksTillPayment int
+	HashRate          int // pool hash rate in KHash/s, as the pool reports it
+	Shares            int // shares credited for each accepted step
+	Fee               int // pool fee in hundredths of a percent
+	Share             int // pool share in hundredths of a percent
+
+	// BlockInterval starts a new block this often, 0 disables new blocks
+	BlockInterval time.Duration
+
+	// TLS, if set, makes miners connect over TLS
+	TLS *tls.Config
+
+	// Fault injection
+	PassFailed        bool          // answer every JOIN with PASSFAILED
+	StepFailRate      float64       // fraction of valid steps answered with STEPFAIL
+	MalformedInterval time.Duration // send a malformed line this often, 0 disables
+	DropInterval      time.Duration // drop every connection this often, 0 disables
+
+	// Logf, if set, receives a line for every message sent and received
+	Logf func(format string, args ...interface{})
+}
+
+// DefaultConfig returns a pool with a realistic difficulty
+func DefaultConfig() Config {
+	return Config{
+		PoolAddr:          "N4ZR3fKhTUod34evnEcDQX3i6XufBDU",
+		MinerSeed:         "1bd1a0ee9!!!",
+		Block:             1000,
+		TargetChars:       9,
+		Diff:              89,
+		PoolDepth:         3,
+		Balance:           0,
+		BlocksTillPayment: -30,
+		HashRate:          100000,
+		Shares:            1,
+		Fee:               100,
+		Share:             9000,
+	}
+}
+
+// Step is a STEP submission received from a miner
+type Step struct {
+	Wallet    string
+	Block     int
+	Seed      string
+	HashStr   string
+	TargetLen int
+	Hash      string
+	Accepted  bool
+	Reason    string // why the step was rejected
+}
+
+// Stats counts what the server has seen
+type Stats struct {
+	Connections   int
+	Joins         int
+	PassFailed    int
+	Pings         int
+	StepsAccepted int
+	StepsFailed   int
+	Payments      int
+	Status        int
+	Unknown       int
+}
+
+type conn struct {
+	net.Conn
+	wallet string
+	send   chan string
+}
+
+// Server is a mock Noso pool
+type Server struct {
+	mu           sync.Mutex
+	cfg          Config
+	ln           net.Listener
+	conns        map[*conn]bool
+	balances     map[string]int64
+	target       string
+	failNext     int
+	silent       bool
+	steps        []Step
+	stats        Stats
+	orderId      int
+	rand         *rand.Rand
+	done         chan struct{}
+	closeOnce    sync.Once
+	backgroundWg sync.WaitGroup
+}
+
+func New(cfg Config) *Server {
+	s := &Server{
+		cfg:      cfg,
+		conns:    make(map[*conn]bool),
+		balances: make(map[string]int64),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		done:     make(chan struct{}),
+	}
+	s.target = s.newTarget()
+	return s
+}
+
+// Start listens on addr (e.g. "127.0.0.1:0") and serves miners in the
+// background until Close is called
+func (s *Server) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if s.cfg.TLS != nil {
+		ln = tls.NewListener(ln, s.cfg.TLS)
+	}
+	s.ln = ln
+
+	go s.accept()
+	s.every(s.cfg.BlockInterval, s.NewBlock)
+	s.every(s.cfg.MalformedInterval, func() { s.Broadcast(s.malformedLine()) })
+	s.every(s.cfg.DropInterval, s.DropConnections)
+
+	return nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops the server and drops every connection
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.ln.Close()
+		s.DropConnections()
+		s.backgroundWg.Wait()
+	})
+	return err
+}
+
+// NewBlock moves the pool on to the next block and announces it to every
+// connected miner with POOLSTEPS
+func (s *Server) NewBlock() {
+	s.mu.Lock()
+	s.cfg.Block++
+	s.target = s.newTarget()
+	s.mu.Unlock()
+
+	s.Broadcast(POOLSTEPS + " " + s.poolData(""))
+}
+
+// FailNextSteps answers the next n valid steps with STEPFAIL
+func (s *Server) FailNextSteps(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failNext = n
+}
+
+// SetPassFailed makes the server answer every JOIN with PASSFAILED
+func (s *Server) SetPassFailed(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg.PassFailed = fail
+}
+
+// SetSilent stops the server from answering PINGs, which trips the
+// client's connection watchdog
+func (s *Server) SetSilent(silent bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.silent = silent
+}
+
+// Broadcast sends a raw line to every connected miner. Use it to inject
+// malformed or unexpected messages
+func (s *Server) Broadcast(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		s.sendLocked(c, line)
+	}
+}
+
+// DropConnections closes every miner connection
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// Block returns the current block and its target string
+func (s *Server) Block() (int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg.Block, s.target
+}
+
+// Stats returns a copy of the server counters
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Steps returns every STEP received so far
+func (s *Server) Steps() []Step {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Step(nil), s.steps...)
+}
+
+func (s *Server) every(interval time.Duration, fn func()) {
+	if interval <= 0 {
+		return
+	}
+
+	s.backgroundWg.Add(1)
+	go func() {
+		defer s.backgroundWg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Server) accept() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			s.logf("accept error: %v", err)
+			continue
+		}
+
+		c := &conn{Conn: nc, send: make(chan string, 100)}
+		s.mu.Lock()
+		s.conns[c] = true
+		s.stats.Connections++
+		s.mu.Unlock()
+
+		go s.write(c)
+		go s.read(c)
+	}
+}
+
+func (s *Server) write(c *conn) {
+	for line := range c.send {
+		if _, err := fmt.Fprintf(c, "%s\n", line); err != nil {
+			c.Close()
+		}
+	}
+}
+
+func (s *Server) read(c *conn) {
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		s.logf("<- %s", line)
+		if reply := s.handle(c, line); reply != "" {
+			// Replies block like a real pool would on a slow miner,
+			// only broadcasts are dropped
+			s.logf("-> %s", reply)
+			c.send <- reply
+		}
+	}
+
+	c.Close()
+	s.mu.Lock()
+	delete(s.conns, c)
+	close(c.send)
+	s.mu.Unlock()
+}
+
+// sendLocked queues a line for c. s.mu must be held
+func (s *Server) sendLocked(c *conn, line string) {
+	if !s.conns[c] {
+		return
+	}
+	s.logf("-> %s", line)
+	select {
+	case c.send <- line:
+	default:
+		// Slow reader, treat it like a dropped connection
+		c.Close()
+	}
+}
+
+// handle answers a single line from c and returns the reply, if any
+func (s *Server) handle(c *conn, line string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := strings.Split(line, " ")
+	if len(r) < 3 {
+		s.stats.Unknown++
+		return ""
+	}
+	pw, wallet, cmd, args := r[0], r[1], r[2], r[3:]
+
+	switch cmd {
+	case "JOIN":
+		if s.cfg.PassFailed || (s.cfg.Password != "" && pw != s.cfg.Password) {
+			s.stats.PassFailed++
+			return PASSFAILED
+		}
+		s.stats.Joins++
+		c.wallet = wallet
+		if _, ok := s.balances[wallet]; !ok {
+			s.balances[wallet] = s.cfg.Balance
+		}
+		return fmt.Sprintf("%s %s %s %s", JOINOK, s.cfg.PoolAddr, s.cfg.MinerSeed, s.poolData(wallet))
+	case "PING":
+		s.stats.Pings++
+		if !s.silent {
+			return PONG + " " + s.poolData(wallet)
+		}
+	case "STEP":
+		step := s.checkStep(wallet, args)
+		if step.Accepted && s.failNext > 0 {
+			s.failNext--
+			step.Accepted, step.Reason = false, "injected failure"
+		} else if step.Accepted && s.rand.Float64() < s.cfg.StepFailRate {
+			step.Accepted, step.Reason = false, "injected failure"
+		}
+		s.steps = append(s.steps, step)
+
+		if !step.Accepted {
+			s.stats.StepsFailed++
+			return STEPFAIL
+		}
+		s.stats.StepsAccepted++
+		return fmt.Sprintf("%s %d", STEPOK, s.cfg.Shares)
+	case "PAYMENT":
+		s.stats.Payments++
+		s.orderId++
+		amount := s.balances[wallet]
+		s.balances[wallet] = 0
+		return fmt.Sprintf("%s %d %s %s 2 %d %d OR%08d",
+			PAYMENTOK, time.Now().Unix(), s.host(), wallet, s.cfg.Block, amount, s.orderId)
+	case "STATUS":
+		s.stats.Status++
+		return s.statusLine()
+	default:
+		s.stats.Unknown++
+	}
+
+	return ""
+}
+
+// checkStep validates "STEP {block} {seed} {hashStr} {targetLen} {instanceId}"
+func (s *Server) checkStep(wallet string, args []string) Step {
+	step := Step{Wallet: wallet}
+
+	if len(args) < 4 {
+		step.Reason = "malformed step"
+		return step
+	}
+
+	block, err := strconv.Atoi(args[0])
+	if err != nil {
+		step.Reason = "bad block"
+		return step
+	}
+	targetLen, err := strconv.Atoi(args[3])
+	if err != nil {
+		step.Reason = "bad target length"
+		return step
+	}
+	step.Block, step.Seed, step.HashStr, step.TargetLen = block, args[1], args[2], targetLen
+
+	sum := sha256.Sum256([]byte(step.Seed + s.cfg.PoolAddr + step.HashStr))
+	step.Hash = hex.EncodeToString(sum[:])
+
+	seedBase := s.cfg.MinerSeed[:len(s.cfg.MinerSeed)-3]
+	target := strings.ToLower(s.target)
+
+	switch {
+	case block != s.cfg.Block:
+		step.Reason = fmt.Sprintf("wrong block %d, pool is on %d", block, s.cfg.Block)
+	case !strings.HasPrefix(step.Seed, seedBase) || len(step.Seed) != len(s.cfg.MinerSeed):
+		step.Reason = "seed does not match miner seed"
+	case targetLen < s.minTargetLen() || targetLen > s.cfg.TargetChars:
+		step.Reason = fmt.Sprintf("target length %d out of range", targetLen)
+	case !strings.Contains(step.Hash, target[:targetLen]):
+		step.Reason = "hash does not contain target"
+	default:
+		step.Accepted = true
+	}
+
+	return step
+}
+
+// minTargetLen is the shortest target the pool accepts as PoP
+func (s *Server) minTargetLen() int {
+	return s.cfg.Diff/10 + 1 - s.cfg.PoolDepth
+}
+
+// poolData returns "PoolData {block} {target} {chars} {step} {diff}
+// {balance} {blocksTillPayment} {poolHashRate} {poolDepth}"
+func (s *Server) poolData(wallet string) string {
+	return fmt.Sprintf("PoolData %d %s %d %d %d %d %d %d %d",
+		s.cfg.Block,
+		s.target,
+		s.cfg.TargetChars,
+		0,
+		s.cfg.Diff,
+		s.balances[wallet],
+		s.cfg.BlocksTillPayment,
+		s.cfg.HashRate,
+		s.cfg.PoolDepth,
+	)
+}
+
+// statusLine returns "STATUS {hashrate} {fee} {share} {minerCount}
+// {address}:{balance}:{blocksTillPayment} ..."
+func (s *Server) statusLine() string {
+	wallets := make([]string, 0, len(s.balances))
+	for w := range s.balances {
+		wallets = append(wallets, w)
+	}
+	sort.Strings(wallets)
+
+	miners := make([]string, 0, len(wallets))
+	for _, w := range wallets {
+		miners = append(miners, fmt.Sprintf("%s:%d:%d", w, s.balances[w], s.cfg.BlocksTillPayment))
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%s %d %d %d %d %s",
+		STATUS, s.cfg.HashRate, s.cfg.Fee, s.cfg.Share, len(miners), strings.Join(miners, " ")))
+}
+
+func (s *Server) host() string {
+	host, _, err := net.SplitHostPort(s.ln.Addr().String())
+	if err != nil {
+		return s.ln.Addr().String()
+	}
+	return host
+}
+
+func (s *Server) newTarget() string {
+	b := make([]byte, 16)
+	s.rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+func (s *Server) malformedLine() string {
+	lines := []string{
+		JOINOK,
+		PONG,
+		POOLSTEPS + " PoolData",
+		STEPOK + " notanumber",
+		STATUS + " 1",
+		"GARBAGE",
+	}
+	return lines[s.rand.Intn(len(lines))]
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.cfg.Logf != nil {
+		s.cfg.Logf(format, args...)
+	}
+}
+
+// Responses sent by the pool
+const (
+	JOINOK     = "JOINOK"
+	PASSFAILED = "PASSFAILED"
+	PAYMENTOK  = "PAYMENTOK"
+	PONG       = "PONG"
+	POOLSTEPS  = "POOLSTEPS"
+	STATUS     = "STATUS"
+	STEPOK     = "STEPOK"
+	STEPFAIL   = "STEPFAIL"
+)
@@ -0,0 +1,178 @@
+package mockpool
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testMiner struct {
+	t       *testing.T
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+func dial(t *testing.T, s *Server) *testMiner {
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testMiner{t: t, conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+func (m *testMiner) send(format string, args ...interface{}) {
+	fmt.Fprintf(m.conn, "pw wallet "+format+"\n", args...)
+}
+
+func (m *testMiner) recv() []string {
+	m.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if !m.scanner.Scan() {
+		m.t.Fatalf("no response from mock pool: %v", m.scanner.Err())
+	}
+	return strings.Split(m.scanner.Text(), " ")
+}
+
+func startServer(t *testing.T, cfg Config) *Server {
+	s := New(cfg)
+	if err := s.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// solve brute forces a hash string that contains the first targetLen chars
+// of the current target
+func solve(t *testing.T, cfg Config, target string, targetLen int) (string, string) {
+	seed := cfg.MinerSeed[:len(cfg.MinerSeed)-3] + "abc"
+	want := strings.ToLower(target[:targetLen])
+	for i := 0; i < 10000000; i++ {
+		hashStr := fmt.Sprintf("%d", i)
+		sum := sha256.Sum256([]byte(seed + cfg.PoolAddr + hashStr))
+		if strings.Contains(hex.EncodeToString(sum[:]), want) {
+			return seed, hashStr
+		}
+	}
+	t.Fatal("could not find a solution")
+	return "", ""
+}
+
+func TestJoinPingAndBlocks(t *testing.T) {
+	cfg := DefaultConfig()
+	s := startServer(t, cfg)
+	m := dial(t, s)
+
+	m.send("JOIN noso-go-test abc")
+	r := m.recv()
+	if r[0] != JOINOK || r[1] != cfg.PoolAddr || r[2] != cfg.MinerSeed || r[3] != "PoolData" || len(r) != 13 {
+		t.Fatalf("unexpected JOINOK: %v", r)
+	}
+	if r[4] != fmt.Sprint(cfg.Block) {
+		t.Errorf("got block %s want %d", r[4], cfg.Block)
+	}
+
+	m.send("PING 100 abc")
+	if r = m.recv(); r[0] != PONG || len(r) != 11 {
+		t.Fatalf("unexpected PONG: %v", r)
+	}
+
+	s.NewBlock()
+	if r = m.recv(); r[0] != POOLSTEPS || r[2] != fmt.Sprint(cfg.Block+1) {
+		t.Fatalf("unexpected POOLSTEPS: %v", r)
+	}
+
+	s.SetSilent(true)
+	m.send("PING 100 abc")
+	m.send("STATUS")
+	if r = m.recv(); r[0] != STATUS || r[4] != "1" || !strings.HasPrefix(r[5], "wallet:") {
+		t.Fatalf("expected STATUS without a PONG, got: %v", r)
+	}
+
+	stats := s.Stats()
+	if stats.Joins != 1 || stats.Pings != 2 || stats.Status != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestSteps(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Diff = 30
+	cfg.PoolDepth = 1
+	cfg.Shares = 2
+	s := startServer(t, cfg)
+	m := dial(t, s)
+
+	m.send("JOIN noso-go-test abc")
+	m.recv()
+
+	block, target := s.Block()
+	seed, hashStr := solve(t, cfg, target, 3)
+
+	m.send("STEP %d %s %s 3 abc", block, seed, hashStr)
+	if r := m.recv(); r[0] != STEPOK || r[1] != "2" {
+		t.Fatalf("expected STEPOK 2, got %v", r)
+	}
+
+	s.FailNextSteps(1)
+	m.send("STEP %d %s %s 3 abc", block, seed, hashStr)
+	if r := m.recv(); r[0] != STEPFAIL {
+		t.Fatalf("expected injected STEPFAIL, got %v", r)
+	}
+
+	tests := []struct {
+		name string
+		step string
+	}{
+		{"wrong block", fmt.Sprintf("STEP %d %s %s 3 abc", block-1, seed, hashStr)},
+		{"wrong hash", fmt.Sprintf("STEP %d %s %sx 3 abc", block, seed, hashStr)},
+		{"wrong seed", fmt.Sprintf("STEP %d %s %s 3 abc", block, "xyz"+seed[3:], hashStr)},
+		{"target too short", fmt.Sprintf("STEP %d %s %s 1 abc", block, seed, hashStr)},
+		{"malformed", "STEP nope"},
+	}
+	for _, tt := range tests {
+		m.send(tt.step)
+		if r := m.recv(); r[0] != STEPFAIL {
+			t.Errorf("%s: expected STEPFAIL, got %v", tt.name, r)
+		}
+	}
+
+	steps := s.Steps()
+	if len(steps) != 2+len(tests) || !steps[0].Accepted || steps[1].Reason != "injected failure" {
+		t.Errorf("unexpected steps: %+v", steps)
+	}
+	if stats := s.Stats(); stats.StepsAccepted != 1 || stats.StepsFailed != 1+len(tests) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Password = "secret"
+	s := startServer(t, cfg)
+
+	m := dial(t, s)
+	m.send("JOIN noso-go-test abc")
+	if r := m.recv(); r[0] != PASSFAILED {
+		t.Fatalf("expected PASSFAILED for a wrong password, got %v", r)
+	}
+
+	s.Broadcast("GARBAGE")
+	if r := m.recv(); r[0] != "GARBAGE" {
+		t.Fatalf("expected injected line, got %v", r)
+	}
+
+	s.DropConnections()
+	m.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if m.scanner.Scan() {
+		t.Fatalf("expected connection to be dropped, got %q", m.scanner.Text())
+	}
+	if err, ok := m.scanner.Err().(net.Error); ok && err.Timeout() {
+		t.Fatal("connection was not dropped")
+	}
+}